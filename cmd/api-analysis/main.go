@@ -3,8 +3,8 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
-	"net/http"
 	"os"
 	"sort"
 	"strings"
@@ -17,33 +17,25 @@ func main() {
 	fmt.Println("API Response Analysis - Root Categories Filtering")
 	fmt.Println("=" + strings.Repeat("=", 60))
 
-	// Test the actual API call to see what root categories are returned
-	baseURL := "https://data.jw-api.org/mediator/v1"
-	lang := "E"
+	settings := &config.Settings{
+		Lang:              "E",
+		IncludeCategories: []string{"VideoOnDemand"},
+		ExcludeCategories: []string{"VODSJJMeetings"},
+		Quiet:             1,
+	}
+	config.EndpointFlags(flag.CommandLine, settings)
+	flag.Parse()
 
-	fmt.Printf("Making API call to: %s/categories/%s/?detailed=1\n", baseURL, lang)
+	client := api.NewClient(settings)
+
+	// Test the actual API call to see what root categories are returned
+	fmt.Printf("Making API call to: %s/categories/%s/?detailed=1\n", settings.APIURL, settings.Lang)
 
-	resp, err := http.Get(fmt.Sprintf("%s/categories/%s/?detailed=1", baseURL, lang))
+	rootResp, err := client.FetchRootCategories(settings.Lang)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error making API call: %v\n", err)
 		os.Exit(1)
 	}
-	defer func() {
-		if closeErr := resp.Body.Close(); closeErr != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to close response body: %v\n", closeErr)
-		}
-	}()
-
-	if resp.StatusCode != http.StatusOK {
-		fmt.Fprintf(os.Stderr, "API returned status: %s\n", resp.Status)
-		return
-	}
-
-	var rootResp api.RootCategoriesResponse
-	if err := json.NewDecoder(resp.Body).Decode(&rootResp); err != nil {
-		fmt.Fprintf(os.Stderr, "Error decoding JSON: %v\n", err)
-		return
-	}
 
 	fmt.Printf("Total categories returned by API: %d\n\n", len(rootResp.Categories))
 
@@ -146,15 +138,6 @@ func main() {
 	fmt.Println("COMPARING WITH CLIENT LOGIC")
 	fmt.Println(strings.Repeat("=", 60))
 
-	settings := &config.Settings{
-		Lang:              "E",
-		IncludeCategories: []string{"VideoOnDemand"},
-		ExcludeCategories: []string{"VODSJJMeetings"},
-		Quiet:             1,
-	}
-
-	client := api.NewClient(settings)
-
 	// Get root categories using the client logic
 	clientCategories, err := client.GetRootCategories()
 	if err != nil {
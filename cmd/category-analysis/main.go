@@ -2,6 +2,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"sort"
@@ -21,6 +22,8 @@ func main() {
 		ExcludeCategories: []string{"VODSJJMeetings"}, // Default exclude
 		Quiet:             1,
 	}
+	config.EndpointFlags(flag.CommandLine, settings)
+	flag.Parse()
 
 	fmt.Printf("Configuration:\n")
 	fmt.Printf("  Language: %s\n", settings.Lang)
@@ -46,15 +49,13 @@ func main() {
 		var mediaCount int
 		var subtitleCount int
 
-		for _, item := range cat.Contents {
-			if media, ok := item.(*api.Media); ok {
-				mediaCount++
-				totalMedia++
+		for _, media := range cat.Media {
+			mediaCount++
+			totalMedia++
 
-				if media.SubtitleURL != "" {
-					subtitleCount++
-					totalWithSubtitles++
-				}
+			if media.SubtitleURL != "" {
+				subtitleCount++
+				totalWithSubtitles++
 			}
 		}
 
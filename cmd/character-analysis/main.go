@@ -3,6 +3,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -23,6 +24,8 @@ func main() {
 		ExcludeCategories: []string{"VODSJJMeetings"},
 		Quiet:             1, // Reduce noise
 	}
+	config.EndpointFlags(flag.CommandLine, settings)
+	flag.Parse()
 
 	client := api.NewClient(settings)
 	data, err := client.ParseBroadcasting()
@@ -43,59 +46,57 @@ func main() {
 	fmt.Println("Analyzing media items for character handling issues...")
 
 	for _, cat := range data {
-		for _, item := range cat.Contents {
-			if media, ok := item.(*api.Media); ok {
-				totalMedia++
+		for _, media := range cat.Media {
+			totalMedia++
 
-				if media.SubtitleURL != "" {
-					// Test filename generation with both safe and unsafe modes
-					safeName := formatFilename(media.Name+".vtt", true)
-					unsafeName := formatFilename(media.Name+".vtt", false)
+			if media.SubtitleURL != "" {
+				// Test filename generation with both safe and unsafe modes
+				safeName := formatFilename(media.Name+".vtt", true)
+				unsafeName := formatFilename(media.Name+".vtt", false)
 
-					safeSubtitleFilename := getSubtitleFilenameSafe(media.SubtitleURL, true)
-					unsafeSubtitleFilename := getSubtitleFilenameSafe(media.SubtitleURL, false)
+				safeSubtitleFilename := getSubtitleFilenameSafe(media.SubtitleURL, true)
+				unsafeSubtitleFilename := getSubtitleFilenameSafe(media.SubtitleURL, false)
 
-					// Track filename collisions
-					filenameMapSafe[safeName]++
-					filenameMapUnsafe[unsafeName]++
+				// Track filename collisions
+				filenameMapSafe[safeName]++
+				filenameMapUnsafe[unsafeName]++
 
-					// Check for problematic characters
-					problematicChars := []rune{}
+				// Check for problematic characters
+				problematicChars := []rune{}
 
-					for _, r := range media.Name + media.SubtitleURL {
-						characterStats[r]++
+				for _, r := range media.Name + media.SubtitleURL {
+					characterStats[r]++
 
-						// Check for characters that would be filtered in safe mode
-						if strings.ContainsRune(`<>|?\\*/`, r) || r == '\x00' || r == '\n' {
-							problematicChars = append(problematicChars, r)
-						}
+					// Check for characters that would be filtered in safe mode
+					if strings.ContainsRune(`<>|?\\*/`, r) || r == '\x00' || r == '\n' {
+						problematicChars = append(problematicChars, r)
 					}
+				}
 
-					// Check if safe vs unsafe processing creates different results
-					if safeName != unsafeName || safeSubtitleFilename != unsafeSubtitleFilename {
-						problematicMedia = append(problematicMedia, ProblematicItem{
-							Name:                   media.Name,
-							SubtitleURL:            media.SubtitleURL,
-							SafeName:               safeName,
-							UnsafeName:             unsafeName,
-							SafeSubtitleFilename:   safeSubtitleFilename,
-							UnsafeSubtitleFilename: unsafeSubtitleFilename,
-							ProblematicChars:       string(problematicChars),
-						})
-					}
+				// Check if safe vs unsafe processing creates different results
+				if safeName != unsafeName || safeSubtitleFilename != unsafeSubtitleFilename {
+					problematicMedia = append(problematicMedia, ProblematicItem{
+						Name:                   media.Name,
+						SubtitleURL:            media.SubtitleURL,
+						SafeName:               safeName,
+						UnsafeName:             unsafeName,
+						SafeSubtitleFilename:   safeSubtitleFilename,
+						UnsafeSubtitleFilename: unsafeSubtitleFilename,
+						ProblematicChars:       string(problematicChars),
+					})
+				}
 
-					// Check for empty filenames after processing
-					if safeName == "" || unsafeName == "" || safeSubtitleFilename == "" || unsafeSubtitleFilename == "" {
-						problematicMedia = append(problematicMedia, ProblematicItem{
-							Name:                   media.Name + " [EMPTY_FILENAME]",
-							SubtitleURL:            media.SubtitleURL,
-							SafeName:               safeName,
-							UnsafeName:             unsafeName,
-							SafeSubtitleFilename:   safeSubtitleFilename,
-							UnsafeSubtitleFilename: unsafeSubtitleFilename,
-							ProblematicChars:       "EMPTY_RESULT",
-						})
-					}
+				// Check for empty filenames after processing
+				if safeName == "" || unsafeName == "" || safeSubtitleFilename == "" || unsafeSubtitleFilename == "" {
+					problematicMedia = append(problematicMedia, ProblematicItem{
+						Name:                   media.Name + " [EMPTY_FILENAME]",
+						SubtitleURL:            media.SubtitleURL,
+						SafeName:               safeName,
+						UnsafeName:             unsafeName,
+						SafeSubtitleFilename:   safeSubtitleFilename,
+						UnsafeSubtitleFilename: unsafeSubtitleFilename,
+						ProblematicChars:       "EMPTY_RESULT",
+					})
 				}
 			}
 		}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"sort"
@@ -23,6 +24,8 @@ func main() {
 		ExcludeCategories: []string{"VODSJJMeetings"},
 		Quiet:             1,
 	}
+	config.EndpointFlags(flag.CommandLine, settings)
+	flag.Parse()
 
 	client := api.NewClient(settings)
 	data, err := client.ParseBroadcasting()
@@ -47,13 +50,11 @@ func main() {
 		var mediaCount int
 		var subtitleCount int
 
-		for _, item := range cat.Contents {
-			if media, ok := item.(*api.Media); ok {
-				mediaCount++
-				if media.SubtitleURL != "" {
-					subtitleCount++
-					totalWithSubtitles++
-				}
+		for _, media := range cat.Media {
+			mediaCount++
+			if media.SubtitleURL != "" {
+				subtitleCount++
+				totalWithSubtitles++
 			}
 		}
 
@@ -6,9 +6,13 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/darkace1998/jw-scripts/internal/books"
 	"github.com/darkace1998/jw-scripts/internal/config"
+	"github.com/darkace1998/jw-scripts/internal/httpclient"
+	"github.com/darkace1998/jw-scripts/internal/ratelimit"
+	"github.com/darkace1998/jw-scripts/internal/storage"
 )
 
 func main() {
@@ -22,7 +26,17 @@ func main() {
 		format         = flag.String("format", "pdf", "Format to download (use --list-formats to see options)")
 		search         = flag.String("search", "", "Search for publications")
 		outputDir      = flag.String("output", "downloads", "Output directory for downloads")
+		storageFlag    = flag.String("storage", config.EnvDefault(config.EnvStorage, ""), "Where downloads and metadata are kept: a directory or s3://bucket/prefix (default: the output directory)")
+		limitRate      = flag.Float64("limit-rate", 0, "Maximum download rate of all downloads together, in megabytes/s (0 for unlimited)")
+		limitSchedule  = flag.String("limit-schedule", "", "Daily download rates overriding --limit-rate, as HH:MM-HH:MM=RATE windows in local time")
 		writeMetadata  = flag.Bool("metadata", false, "Embed metadata in downloaded MP3/MP4 files; other formats get a JSON sidecar file")
+		retries        = flag.Int("retries", httpclient.DefaultMaxRetries, "Number of times a failed request is retried")
+		verifyRetries  = flag.Int("verify-retries", 2, "Number of times a download failing its size, checksum or structure check is downloaded again")
+		quarantine     = flag.String("quarantine", "", "Directory receiving downloads that fail verification (default: .quarantine in the output directory)")
+		cacheMaxAge    = flag.Duration("cache-max-age", 7*24*time.Hour, "Maximum age of cached publication data (0 disables the cache)")
+		refresh        = flag.Bool("refresh", false, "Ignore cached publication data and fetch everything again")
+		pubMediaURL    = flag.String("pub-media-url", config.EnvDefault(config.EnvPubMediaURL, config.DefaultPubMediaURL), "URL of the publication media API")
+		userAgent      = flag.String("user-agent", config.EnvDefault(config.EnvUserAgent, ""), "User-Agent header sent with every request")
 		help           = flag.Bool("help", false, "Show help information")
 	)
 
@@ -33,11 +47,33 @@ func main() {
 		return
 	}
 
+	schedule, err := ratelimit.ParseSchedule(*limitSchedule)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --limit-schedule: %v\n", err)
+		os.Exit(1)
+	}
+
+	library, err := storage.New(*storageFlag, *outputDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --storage: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Create settings
 	settings := &config.Settings{
 		Quiet:         0,
-		RateLimit:     0,
+		RateLimit:     *limitRate,
+		RateSchedule:  schedule,
 		WriteMetadata: *writeMetadata,
+		Retries:       *retries,
+		VerifyRetries: *verifyRetries,
+		QuarantineDir: *quarantine,
+		WorkDir:       *outputDir,
+		Storage:       library,
+		CacheMaxAge:   *cacheMaxAge,
+		Refresh:       *refresh,
+		PubMediaURL:   *pubMediaURL,
+		UserAgent:     *userAgent,
 	}
 
 	// Create client and downloader
@@ -92,7 +128,17 @@ func printHelp() {
 	fmt.Println("  --format FORMAT       Format to download (default: pdf)")
 	fmt.Println("  --search QUERY        Search for publications")
 	fmt.Println("  --output DIR          Output directory (default: downloads)")
+	fmt.Println("  --storage LOCATION    Keep downloads in a directory or s3://bucket/prefix (env JWB_STORAGE)")
+	fmt.Println("  --limit-rate MB       Maximum download rate in megabytes/s (default: unlimited)")
+	fmt.Println("  --limit-schedule S    Daily rates such as 22:00-06:00=0,06:00-22:00=2 (MB/s, 0 unlimited)")
 	fmt.Println("  --metadata            Embed metadata in MP3/MP4 downloads (JSON sidecar for other formats)")
+	fmt.Println("  --retries N           Retry failed requests N times (default: 3)")
+	fmt.Println("  --verify-retries N    Download files failing verification again N times (default: 2)")
+	fmt.Println("  --quarantine DIR      Directory for files failing verification (default: OUTPUT/.quarantine)")
+	fmt.Println("  --cache-max-age DUR   Maximum age of cached publication data (default: 168h, 0 disables)")
+	fmt.Println("  --refresh             Ignore cached publication data")
+	fmt.Println("  --pub-media-url URL   Publication media API URL (env JWB_PUB_MEDIA_URL)")
+	fmt.Println("  --user-agent UA       User-Agent header for all requests (env JWB_USER_AGENT)")
 	fmt.Println("  --help                Show this help message")
 	fmt.Println()
 	fmt.Println("Examples:")
@@ -7,22 +7,33 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/darkace1998/jw-scripts/internal/api"
+	"github.com/darkace1998/jw-scripts/internal/catalog"
 	"github.com/darkace1998/jw-scripts/internal/config"
 	"github.com/darkace1998/jw-scripts/internal/downloader"
+	"github.com/darkace1998/jw-scripts/internal/httpclient"
 	"github.com/darkace1998/jw-scripts/internal/output"
+	"github.com/darkace1998/jw-scripts/internal/ratelimit"
+	"github.com/darkace1998/jw-scripts/internal/storage"
+	"github.com/darkace1998/jw-scripts/internal/subtitle"
 	"github.com/spf13/cobra"
 )
 
 var settings = &config.Settings{}
 var sinceDate string
+var limitSchedule string
+var storageLocation string
 var noWarning bool
+var catalogOptions catalog.Options
+var languages []string
 
 var rootCmd = &cobra.Command{
 	Use:   "jwb-index",
 	Short: "Index or download media from jw.org",
+	Args:  cobra.ArbitraryArgs,
 	Run: func(_ *cobra.Command, args []string) {
 		if len(args) > 0 {
 			settings.WorkDir = args[0]
@@ -35,36 +46,60 @@ var rootCmd = &cobra.Command{
 }
 
 func init() {
+	rootCmd.Flags().StringVar(&settings.APIURL, "api-url", config.EnvDefault(config.EnvAPIURL, config.DefaultAPIURL), "base URL of the JW Broadcasting mediator API (env "+config.EnvAPIURL+")")
 	rootCmd.Flags().BoolVar(&settings.Append, "append", false, "append to file instead of overwriting")
 	rootCmd.Flags().BoolVar(&settings.AudioOnly, "audio-only", false, "download only audio (MP3) files, skip video-only content")
 	rootCmd.Flags().StringSliceVarP(&settings.IncludeCategories, "category", "c", []string{"VideoOnDemand"}, "comma separated list of categories to index (use --list-categories-all to see available categories)")
 	rootCmd.Flags().BoolVar(&settings.ListCategories, "list-categories-all", false, "list all available root categories")
+	rootCmd.Flags().DurationVar(&settings.CacheMaxAge, "cache-max-age", 7*24*time.Hour, "maximum age of cached API responses before they are fetched again in full (0 disables the cache)")
 	rootCmd.Flags().BoolVar(&settings.Checksums, "checksum", false, "validate MD5 checksums")
 	rootCmd.Flags().BoolVar(&settings.CleanAllSymlinks, "clean-symlinks", false, "remove all old symlinks (mode=filesystem)")
 	rootCmd.Flags().StringSliceVar(&settings.Command, "command", []string{}, "command to execute in run mode")
 	rootCmd.Flags().BoolVarP(&settings.Download, "download", "d", false, "download media files")
 	rootCmd.Flags().BoolVar(&settings.DownloadSubtitles, "download-subtitles", false, "download VTT subtitle files")
+	rootCmd.Flags().BoolVar(&settings.DownloadImages, "download-images", false, "download the thumbnail or poster image of every media item and category")
 	rootCmd.Flags().StringSliceVar(&settings.ExcludeCategories, "exclude", []string{"VODSJJMeetings"}, "comma separated list of categories to skip")
 	rootCmd.Flags().BoolVar(&settings.OverwriteBad, "fix-broken", false, "check existing files and re-download them if they are broken")
 	rootCmd.Flags().Int64Var(&settings.KeepFree, "free", 0, "disk space in MiB to keep free")
 	rootCmd.Flags().BoolVarP(&settings.FriendlyFilenames, "friendly", "H", false, "save downloads with human readable names")
 	rootCmd.Flags().BoolVar(&settings.HardSubtitles, "hard-subtitles", false, "prefer videos with hard-coded subtitles")
+	rootCmd.Flags().BoolVar(&settings.ExplainSelection, "explain-selection", false, "print why each video file was selected or passed over")
 	rootCmd.Flags().StringVar(&settings.ImportDir, "import", "", "import of media files from this directory (offline)")
-	rootCmd.Flags().StringVarP(&settings.Lang, "lang", "l", "E", "language code")
+	rootCmd.Flags().IntVar(&settings.IndexWorkers, "index-workers", 4, "number of categories to fetch in parallel while indexing")
+	rootCmd.Flags().IntVarP(&settings.Jobs, "jobs", "j", 1, "number of files to download in parallel")
+	rootCmd.Flags().StringSliceVarP(&languages, "lang", "l", []string{"E"}, "language code, or a comma separated list of codes to index in one run")
 	rootCmd.Flags().BoolVarP(&settings.ListLanguages, "languages", "L", false, "display a list of valid language codes")
 	rootCmd.Flags().BoolVar(&settings.WriteMetadata, "metadata", false, "embed metadata in downloaded media files (ID3 for MP3, MP4 atoms for video); unsupported formats get a JSON sidecar file")
 	rootCmd.Flags().BoolVarP(&settings.Latest, "latest", "D", false, "fetch subtitles and videos from the past 31 days up to today (31-day window ending today)")
-	rootCmd.Flags().Float64VarP(&settings.RateLimit, "limit-rate", "R", 25.0, "maximum download rate, in megabytes/s")
+	rootCmd.Flags().Float64VarP(&settings.RateLimit, "limit-rate", "R", 25.0, "maximum download rate of all downloads together, in megabytes/s (0 for unlimited)")
+	rootCmd.Flags().StringVar(&limitSchedule, "limit-schedule", "", "daily download rates overriding --limit-rate, as HH:MM-HH:MM=RATE windows in local time (e.g. 22:00-06:00=0,06:00-22:00=2)")
 	rootCmd.Flags().StringVarP(&settings.PrintCategory, "list-categories", "C", "", "print a list of (sub) category names")
 	rootCmd.Flags().StringVarP(&settings.Mode, "mode", "m", "", "output mode (filesystem, html, m3u, run, stdout, txt)")
+	rootCmd.Flags().BoolVar(&settings.NoHardSubtitles, "no-hard-subtitles", false, "never select videos with hard-coded subtitles")
 	rootCmd.Flags().StringVarP(&settings.OutputFilename, "output", "o", "", "output filename for txt/m3u/html modes")
+	rootCmd.Flags().BoolVar(&catalogOptions.Disabled, "no-catalog", false, "do not record indexed media in the catalog file ("+catalog.FileName+") in the work directory")
 	rootCmd.Flags().BoolVar(&noWarning, "no-warning", false, "do not warn when the disk space limit (--free) seems wrong")
 	rootCmd.Flags().IntVarP(&settings.Quality, "quality", "Q", 720, "maximum video quality")
+	rootCmd.Flags().StringVar(&settings.PubMediaURL, "pub-media-url", config.EnvDefault(config.EnvPubMediaURL, config.DefaultPubMediaURL), "URL of the publication media API (env "+config.EnvPubMediaURL+")")
+	rootCmd.Flags().StringVar(&catalogOptions.ReportFormat, "report", "", "print the changes since the previous run (text, json)")
+	rootCmd.Flags().StringVar(&catalogOptions.ReportFile, "report-file", "", "write the --report to this file instead of standard output")
+	rootCmd.Flags().StringVar(&settings.QuarantineDir, "quarantine", "", "directory receiving downloads that fail verification (default: "+downloader.QuarantineDirName+" in the work directory)")
 	rootCmd.Flags().IntVarP(&settings.Quiet, "quiet", "q", 0, "less info, can be used multiple times")
+	rootCmd.Flags().BoolVar(&settings.Refresh, "refresh", false, "ignore cached API responses and fetch everything again")
+	rootCmd.Flags().IntVar(&settings.Retries, "retries", httpclient.DefaultMaxRetries, "number of times a failed request is retried (with exponential backoff)")
 	rootCmd.Flags().BoolVar(&settings.SafeFilenames, "safe-filenames", runtime.GOOS == "windows", "use filesystem-safe filenames (automatically enabled on Windows)")
+	rootCmd.Flags().IntVar(&settings.Segments, "segments", 1, "number of connections used to download each file of 32 MiB or more")
+	rootCmd.Flags().StringVar(&settings.Selection, "select", string(api.PolicyMax), "video selection policy relative to --quality (max, exact, min, nearest, smallest, largest)")
 	rootCmd.Flags().StringVar(&sinceDate, "since", "", "only index media newer than this date (YYYY-MM-DD)")
 	rootCmd.Flags().StringVar(&settings.Sort, "sort", "", "sort output (newest, oldest, name, random)")
+	rootCmd.Flags().StringVar(&storageLocation, "storage", config.EnvDefault(config.EnvStorage, ""), "where downloads, metadata and playlists are kept: a directory or s3://bucket/prefix (default: the work directory; env "+config.EnvStorage+")")
+	rootCmd.Flags().StringVar(&settings.SubtitleFallbackLang, "subtitle-fallback", "", "language of the subtitles to download when one of --subtitle-langs has none")
+	rootCmd.Flags().StringVar(&settings.SubtitleFormat, "subtitle-format", "", "also save downloaded subtitles in this format (srt, ass)")
+	rootCmd.Flags().StringSliceVar(&settings.SubtitleLangs, "subtitle-langs", nil, "comma separated list of languages to download subtitles in, saved as <name>.<lang>.vtt")
 	rootCmd.Flags().BoolVar(&settings.Update, "update", false, "update existing categories with the latest videos")
+	rootCmd.Flags().BoolVar(&settings.UpgradeQuality, "upgrade-quality", false, "replace downloaded videos of a lower resolution than the one now selected")
+	rootCmd.Flags().StringVar(&settings.UserAgent, "user-agent", config.EnvDefault(config.EnvUserAgent, ""), "User-Agent header sent with every request (env "+config.EnvUserAgent+")")
+	rootCmd.Flags().IntVar(&settings.VerifyRetries, "verify-retries", 2, "number of times a download failing its size, checksum or structure check is downloaded again")
 }
 
 func main() {
@@ -77,6 +112,18 @@ func main() {
 func run(s *config.Settings) error {
 	s.Warning = !noWarning
 
+	if len(languages) == 0 {
+		return fmt.Errorf("please specify at least one language with --lang")
+	}
+	// Listing and single-language runs use the first language
+	s.Lang = languages[0]
+
+	// The work directory must be known before the client is created, since
+	// the API response cache lives inside it.
+	if s.WorkDir == "" {
+		s.WorkDir = "."
+	}
+
 	client := api.NewClient(s)
 
 	if s.ListLanguages {
@@ -129,10 +176,31 @@ func run(s *config.Settings) error {
 		return nil
 	}
 
-	if s.Mode == "" && !s.Download && !s.DownloadSubtitles && s.ImportDir == "" {
+	if s.Mode == "" && !s.Download && !s.DownloadSubtitles && !s.DownloadImages && len(s.SubtitleLangs) == 0 && s.ImportDir == "" {
 		return fmt.Errorf("please use --mode or --download")
 	}
 
+	if err := catalogOptions.Check(s); err != nil {
+		return err
+	}
+	if s.UpgradeQuality && !catalogOptions.Enabled(s) {
+		return fmt.Errorf("--upgrade-quality relies on the catalog, which is not kept with --no-catalog or for stdout listings")
+	}
+	if _, err := api.ParsePolicy(s.Selection); err != nil {
+		return err
+	}
+	if s.HardSubtitles && s.NoHardSubtitles {
+		return fmt.Errorf("--hard-subtitles and --no-hard-subtitles cannot be combined")
+	}
+	if s.SubtitleFormat != "" {
+		if _, err := subtitle.ParseFormat(s.SubtitleFormat); err != nil {
+			return err
+		}
+		if !s.DownloadSubtitles && len(s.SubtitleLangs) == 0 {
+			return fmt.Errorf("--subtitle-format requires --download-subtitles or --subtitle-langs")
+		}
+	}
+
 	if s.Update {
 		s.Append = true
 		s.Latest = true
@@ -150,6 +218,21 @@ func run(s *config.Settings) error {
 		s.MinDate = t.Unix()
 	}
 
+	schedule, err := ratelimit.ParseSchedule(limitSchedule)
+	if err != nil {
+		return fmt.Errorf("invalid --limit-schedule: %w", err)
+	}
+	s.RateSchedule = schedule
+
+	st, err := storage.New(storageLocation, s.WorkDir)
+	if err != nil {
+		return fmt.Errorf("invalid --storage: %w", err)
+	}
+	s.Storage = st
+	if err := downloader.CheckDiskLimit(s); err != nil {
+		return err
+	}
+
 	if s.Latest {
 		// Set date range for 31-day window: from today back to 31 days ago when --latest flag is used
 		now := time.Now()
@@ -177,9 +260,10 @@ func run(s *config.Settings) error {
 	// Convert MiB to bytes for disk space calculations
 	s.KeepFree *= 1024 * 1024
 
-	if s.WorkDir == "" {
-		s.WorkDir = "."
+	if len(languages) > 1 {
+		return runLanguages(s, languages)
 	}
+
 	if !strings.HasPrefix(s.Mode, "stdout") {
 		s.SubDir = "jwb-" + s.Lang
 	}
@@ -188,6 +272,9 @@ func run(s *config.Settings) error {
 	if err != nil {
 		return err
 	}
+	if len(s.SubtitleLangs) > 0 {
+		client.FetchSubtitles(data)
+	}
 
 	// Offline import: scan the import directory for media files and add them to the data
 	if s.ImportDir != "" {
@@ -198,12 +285,19 @@ func run(s *config.Settings) error {
 		data = append(data, importedData...)
 	}
 
-	if s.Download || s.DownloadSubtitles {
+	if err := annotateFromCatalog(s, []api.IndexedLanguage{{Settings: s, Data: data}}); err != nil {
+		return err
+	}
+	if s.Download || s.DownloadSubtitles || s.DownloadImages || len(s.SubtitleLangs) > 0 {
 		if err := downloader.DownloadAll(s, data); err != nil {
 			return err
 		}
 	}
 
+	if err := catalog.Update(s, []api.IndexedLanguage{{Settings: s, Data: data}}, catalogOptions); err != nil {
+		return err
+	}
+
 	if s.Mode != "" {
 		if err := output.CreateOutput(s, data); err != nil {
 			return err
@@ -213,6 +307,91 @@ func run(s *config.Settings) error {
 	return nil
 }
 
+// runLanguages indexes several languages concurrently, each into its own
+// "jwb-<lang>" subdirectory, cross-references media shared between them and
+// then downloads and writes the output per language plus a combined
+// playlist.
+func runLanguages(s *config.Settings, langs []string) error {
+	indexes := make([]api.IndexedLanguage, len(langs))
+	errs := make([]error, len(langs))
+
+	var wg sync.WaitGroup
+	for i, lang := range langs {
+		ls := *s
+		ls.Lang = lang
+		ls.SubDir = "jwb-" + lang
+		indexes[i].Settings = &ls
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client := api.NewClient(indexes[i].Settings)
+			indexes[i].Data, errs[i] = client.ParseBroadcasting()
+			if errs[i] == nil && len(s.SubtitleLangs) > 0 {
+				client.FetchSubtitles(indexes[i].Data)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("indexing language %s failed: %w", langs[i], err)
+		}
+	}
+
+	// Offline imports are not tied to a language; they are added to the
+	// first one.
+	if s.ImportDir != "" {
+		importedData, err := importOfflineMedia(s)
+		if err != nil {
+			return fmt.Errorf("offline import failed: %v", err)
+		}
+		indexes[0].Data = append(indexes[0].Data, importedData...)
+	}
+
+	api.LinkTranslations(indexes)
+
+	if err := annotateFromCatalog(s, indexes); err != nil {
+		return err
+	}
+	if s.Download || s.DownloadSubtitles || s.DownloadImages || len(s.SubtitleLangs) > 0 {
+		for _, l := range indexes {
+			if err := downloader.DownloadAll(l.Settings, l.Data); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := catalog.Update(s, indexes, catalogOptions); err != nil {
+		return err
+	}
+
+	if s.Mode != "" {
+		if err := output.CreateMultiLanguageOutput(s, indexes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// annotateFromCatalog tells the downloader and the outputs which variant of
+// every media item is already downloaded, as recorded in the catalog.
+func annotateFromCatalog(s *config.Settings, langs []api.IndexedLanguage) error {
+	if !catalogOptions.Enabled(s) {
+		return nil
+	}
+	c, err := catalog.Load(catalog.Path(s.WorkDir))
+	if err != nil {
+		return err
+	}
+	for _, l := range langs {
+		c.Annotate(l.Settings, l.Data)
+	}
+	return nil
+}
+
 // importOfflineMedia scans the import directory for media files and returns
 // them as categories that can be processed by the output/download pipeline.
 func importOfflineMedia(s *config.Settings) ([]*api.Category, error) {
@@ -267,15 +446,15 @@ func importOfflineMedia(s *config.Settings) ([]*api.Category, error) {
 		// must always be set, not only when --friendly is enabled.
 		media.FriendlyName = entry.Name()
 
-		cat.Contents = append(cat.Contents, media)
+		cat.Media = append(cat.Media, media)
 	}
 
-	if len(cat.Contents) == 0 {
+	if len(cat.Media) == 0 {
 		return nil, nil
 	}
 
 	if s.Quiet < 1 {
-		fmt.Fprintf(os.Stderr, "imported %d files from %s\n", len(cat.Contents), s.ImportDir)
+		fmt.Fprintf(os.Stderr, "imported %d files from %s\n", len(cat.Media), s.ImportDir)
 	}
 
 	return []*api.Category{cat}, nil
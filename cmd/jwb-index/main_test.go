@@ -1,11 +1,23 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/darkace1998/jw-scripts/internal/catalog"
+	"github.com/darkace1998/jw-scripts/internal/config"
+	"github.com/darkace1998/jw-scripts/internal/metadata"
+	"github.com/darkace1998/jw-scripts/internal/testutil"
 )
 
 // apiReachable reports whether the live JW.org API can be reached, so
@@ -43,3 +55,320 @@ func TestJwbIndexLanguages(t *testing.T) {
 		t.Errorf("expected language listing, got: %s", out)
 	}
 }
+
+// newMultiLanguageServer serves one category per language whose only video
+// is the same item, plus the video files themselves.
+func newMultiLanguageServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".mp4") {
+			_, _ = w.Write(testutil.MP4("video " + path.Base(r.URL.Path)))
+			return
+		}
+		// /categories/<lang>/VideoOnDemand
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(parts) != 3 || parts[0] != "categories" || parts[2] != "VideoOnDemand" {
+			http.NotFound(w, r)
+			return
+		}
+		lang := parts[1]
+		fmt.Fprintf(w, `{"category":{"key":"VideoOnDemand","name":"Videos %[1]s","media":[{
+			"title":"Title %[1]s","type":"video","firstPublished":"2024-01-01T00:00:00.000Z",
+			"languageAgnosticNaturalKey":"pub-test_1_VIDEO",
+			"files":[{"progressiveDownloadURL":"%[2]s/video_%[1]s.mp4","label":"720p"}]}]}}`, lang, server.URL)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRunMultipleLanguages(t *testing.T) {
+	server := newMultiLanguageServer(t)
+	dir := t.TempDir()
+
+	oldLanguages := languages
+	languages = []string{"E", "S"}
+	defer func() { languages = oldLanguages }()
+
+	s := &config.Settings{
+		WorkDir:           dir,
+		APIURL:            server.URL,
+		IncludeCategories: []string{"VideoOnDemand"},
+		Quality:           720,
+		Quiet:             2,
+		Mode:              "m3u",
+		Download:          true,
+		WriteMetadata:     true,
+	}
+	if err := run(s); err != nil {
+		t.Fatalf("run() returned error: %v", err)
+	}
+
+	for _, name := range []string{"jwb-E/video_E.mp4", "jwb-S/video_S.mp4", "playlist_E.m3u", "playlist_S.m3u", "playlist.m3u"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s: %v", name, err)
+		}
+	}
+
+	// #nosec G304 - path is inside t.TempDir()
+	combined, err := os.ReadFile(filepath.Join(dir, "playlist.m3u"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Title E (E)", filepath.Join("jwb-E", "video_E.mp4"), "Title S (S)", filepath.Join("jwb-S", "video_S.mp4")} {
+		if !strings.Contains(string(combined), want) {
+			t.Errorf("combined playlist missing %q:\n%s", want, combined)
+		}
+	}
+
+	// #nosec G304 - path is inside t.TempDir()
+	data, err := os.ReadFile(metadata.SidecarPath(filepath.Join(dir, "jwb-E"), "video_E.mp4"))
+	if err != nil {
+		t.Fatalf("expected metadata sidecar: %v", err)
+	}
+	var meta metadata.FileMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatal(err)
+	}
+	want := []metadata.Translation{{Language: "S", Title: "Title S", Path: "../jwb-S/video_S.mp4", URL: server.URL + "/video_S.mp4"}}
+	if !reflect.DeepEqual(meta.Translations, want) {
+		t.Errorf("got translations %+v, want %+v", meta.Translations, want)
+	}
+
+	c, err := catalog.Load(catalog.Path(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Media) != 2 {
+		t.Fatalf("expected both languages in the catalog, got %d entries", len(c.Media))
+	}
+	for _, e := range c.Media {
+		if e.State != catalog.StateDownloaded || e.SubDir != "jwb-"+e.Lang {
+			t.Errorf("unexpected catalog entry %+v", e)
+		}
+	}
+}
+
+func TestRunWritesChangeReport(t *testing.T) {
+	server := newMultiLanguageServer(t)
+	dir := t.TempDir()
+	reportPath := filepath.Join(dir, "report.json")
+
+	oldLanguages, oldOptions := languages, catalogOptions
+	languages, catalogOptions = []string{"E"}, catalog.Options{ReportFormat: "json", ReportFile: reportPath}
+	defer func() { languages, catalogOptions = oldLanguages, oldOptions }()
+
+	var reports []catalog.Report
+	for range 2 {
+		s := &config.Settings{
+			WorkDir:           dir,
+			APIURL:            server.URL,
+			IncludeCategories: []string{"VideoOnDemand"},
+			Quality:           720,
+			Quiet:             2,
+			Mode:              "m3u",
+		}
+		if err := run(s); err != nil {
+			t.Fatalf("run() returned error: %v", err)
+		}
+		// #nosec G304 - path is inside t.TempDir()
+		data, err := os.ReadFile(reportPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r catalog.Report
+		if err := json.Unmarshal(data, &r); err != nil {
+			t.Fatalf("report is not valid JSON: %v", err)
+		}
+		reports = append(reports, r)
+	}
+
+	if len(reports[0].FirstRun) != 1 || reports[0].FirstRun[0].Media != 1 {
+		t.Errorf("expected a first-run summary, got %+v", reports[0])
+	}
+	if reports[1].Previous.IsZero() || len(reports[1].FirstRun) != 0 || len(reports[1].Added)+len(reports[1].Removed)+len(reports[1].Changed) != 0 {
+		t.Errorf("expected an empty report for an unchanged index, got %+v", reports[1])
+	}
+}
+
+func TestSearch(t *testing.T) {
+	server := newMultiLanguageServer(t)
+	dir := t.TempDir()
+
+	oldLanguages := languages
+	languages = []string{"E", "S"}
+	defer func() { languages = oldLanguages }()
+
+	// Only the English video is downloaded.
+	if err := os.MkdirAll(filepath.Join(dir, "jwb-E"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "jwb-E", "video_E.mp4"), []byte("video"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := &config.Settings{WorkDir: dir, APIURL: server.URL, IncludeCategories: []string{"VideoOnDemand"}, Quality: 720, Quiet: 2, Mode: "m3u"}
+	if err := run(s); err != nil {
+		t.Fatalf("run() returned error: %v", err)
+	}
+
+	var out strings.Builder
+	if err := runSearch(&searchOptions{workDir: dir, title: "title", mode: "table"}, nil, &out); err != nil {
+		t.Fatalf("runSearch() returned error: %v", err)
+	}
+	if !strings.Contains(out.String(), "Title E") || !strings.Contains(out.String(), "Title S") || !strings.Contains(out.String(), "2 results") {
+		t.Errorf("unexpected table:\n%s", out.String())
+	}
+
+	out.Reset()
+	downloaded := true
+	if err := runSearch(&searchOptions{workDir: dir, mode: "json"}, &downloaded, &out); err != nil {
+		t.Fatalf("runSearch() returned error: %v", err)
+	}
+	var entries []catalog.Entry
+	if err := json.Unmarshal([]byte(out.String()), &entries); err != nil {
+		t.Fatalf("invalid JSON output: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Lang != "E" {
+		t.Errorf("expected only the downloaded English video, got %+v", entries)
+	}
+
+	if err := runSearch(&searchOptions{workDir: dir, langs: []string{"E", "S"}, mode: "m3u", outputFilename: "found.m3u"}, nil, &out); err != nil {
+		t.Fatalf("runSearch() returned error: %v", err)
+	}
+	// #nosec G304 - path is inside t.TempDir()
+	playlist, err := os.ReadFile(filepath.Join(dir, "found.m3u"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{filepath.Join("jwb-E", "video_E.mp4"), server.URL + "/video_S.mp4"} {
+		if !strings.Contains(string(playlist), want) {
+			t.Errorf("playlist missing %q:\n%s", want, playlist)
+		}
+	}
+
+	if err := runSearch(&searchOptions{workDir: t.TempDir(), mode: "table"}, nil, &out); err == nil {
+		t.Error("expected an error without a catalog")
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	server := newMultiLanguageServer(t)
+	dir := t.TempDir()
+
+	oldLanguages := languages
+	languages = []string{"E"}
+	defer func() { languages = oldLanguages }()
+
+	s := &config.Settings{WorkDir: dir, APIURL: server.URL, IncludeCategories: []string{"VideoOnDemand"}, Quality: 720, Quiet: 2, Download: true}
+	if err := run(s); err != nil {
+		t.Fatalf("run() returned error: %v", err)
+	}
+	vtt := "WEBVTT\n\n00:01.000 --> 00:03.000\nHello\nworld.\n\n00:10.000 --> 00:12.000\n<i>Goodbye.</i>\n"
+	if err := os.WriteFile(filepath.Join(dir, "jwb-E", "video_E.vtt"), []byte(vtt), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := runTranscript(&transcriptOptions{workDir: dir, format: "txt", timestamps: 5}, &out); err != nil {
+		t.Fatalf("runTranscript() returned error: %v", err)
+	}
+	if out.String() != "wrote 1 transcripts and 1 category documents\n" {
+		t.Errorf("unexpected summary %q", out.String())
+	}
+
+	for name, want := range map[string]string{
+		"video_E.txt":                  "Title E\n=======\n\nPublished 2024-01-01\n\n[0:01] Hello world.\n\n[0:10] Goodbye.\n",
+		"transcript-VideoOnDemand.txt": "Videos E\n========\n\nTitle E\n-------\n\nPublished 2024-01-01\n\n[0:01] Hello world.\n\n[0:10] Goodbye.\n",
+	} {
+		// #nosec G304 - path is inside t.TempDir()
+		got, err := os.ReadFile(filepath.Join(dir, "jwb-E", name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q (%v), want %q", name, got, err, want)
+		}
+	}
+
+	// Subtitles moved into a library are read from there
+	libDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(libDir, "jwb-E"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "jwb-E", "video_E.vtt"), filepath.Join(libDir, "jwb-E", "video_E.vtt")); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := runTranscript(&transcriptOptions{workDir: dir, format: "md", storage: libDir}, &out); err != nil {
+		t.Fatalf("runTranscript() returned error: %v", err)
+	}
+	if out.String() != "wrote 1 transcripts and 1 category documents\n" {
+		t.Errorf("unexpected summary with --storage %q", out.String())
+	}
+	for _, name := range []string{"video_E.md", "transcript-VideoOnDemand.md"} {
+		if _, err := os.Stat(filepath.Join(libDir, "jwb-E", name)); err != nil {
+			t.Errorf("expected %s in the library: %v", name, err)
+		}
+	}
+
+	if err := runTranscript(&transcriptOptions{workDir: dir, format: "pdf"}, &out); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestRunUpgradesQuality(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".mp4") {
+			_, _ = w.Write(testutil.MP4("video " + path.Base(r.URL.Path)))
+			return
+		}
+		fmt.Fprintf(w, `{"category":{"key":"VideoOnDemand","name":"Videos","media":[{
+			"title":"Intro","type":"video","naturalKey":"pub-intro_1_VIDEO",
+			"files":[{"progressiveDownloadURL":"%[1]s/intro_480p.mp4","label":"480p"},
+			         {"progressiveDownloadURL":"%[1]s/intro_720p.mp4","label":"720p"}]}]}}`, server.URL)
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+
+	oldLanguages := languages
+	languages = []string{"E"}
+	defer func() { languages = oldLanguages }()
+
+	playlist := filepath.Join(dir, "playlist.txt")
+	index := func(quality int, download, upgrade bool) *catalog.Entry {
+		t.Helper()
+		_ = os.Remove(playlist)
+		s := &config.Settings{WorkDir: dir, APIURL: server.URL, IncludeCategories: []string{"VideoOnDemand"}, Quality: quality, Quiet: 2, Mode: "txt", Download: download, UpgradeQuality: upgrade}
+		if err := run(s); err != nil {
+			t.Fatalf("run() returned error: %v", err)
+		}
+		c, err := catalog.Load(catalog.Path(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Get("E:pub-intro_1_VIDEO")
+	}
+	readPlaylist := func() string {
+		t.Helper()
+		// #nosec G304 - path is inside t.TempDir()
+		data, err := os.ReadFile(playlist)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(data))
+	}
+	local := func(name string) string { return filepath.Join("jwb-E", name) }
+
+	if e := index(480, true, false); e.LocalLabel != "480p" || e.State != catalog.StateDownloaded {
+		t.Fatalf("unexpected entry after the first run: %+v", e)
+	}
+	// Indexing at a higher quality keeps pointing the playlist at the local
+	// 480p copy
+	if e := index(720, false, false); e.State != catalog.StateOutdated || readPlaylist() != local("intro_480p.mp4") {
+		t.Errorf("expected the 480p copy to be kept and reported as outdated, got %+v and playlist %q", e, readPlaylist())
+	}
+	if e := index(720, true, true); e.LocalLabel != "720p" || e.State != catalog.StateDownloaded || readPlaylist() != local("intro_720p.mp4") {
+		t.Errorf("expected the 720p variant after upgrading, got %+v and playlist %q", e, readPlaylist())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "jwb-E", "intro_480p.mp4")); !os.IsNotExist(err) {
+		t.Errorf("expected the upgraded 480p copy to be removed: %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/darkace1998/jw-scripts/internal/api"
+	"github.com/darkace1998/jw-scripts/internal/catalog"
+	"github.com/darkace1998/jw-scripts/internal/config"
+	"github.com/darkace1998/jw-scripts/internal/output"
+	"github.com/spf13/cobra"
+)
+
+// searchOptions holds the flags of the search subcommand.
+type searchOptions struct {
+	workDir        string
+	title          string
+	regex          string
+	categories     []string
+	langs          []string
+	since          string
+	until          string
+	minDuration    time.Duration
+	maxDuration    time.Duration
+	downloaded     bool
+	includeRemoved bool
+	mode           string
+	outputFilename string
+	command        []string
+}
+
+var search = &searchOptions{}
+
+var searchCmd = &cobra.Command{
+	Use:   "search [work dir]",
+	Short: "Search the catalog of indexed media offline",
+	Long: `search looks up media in the catalog (` + catalog.FileName + `) that jwb-index keeps in
+its work directory. It never touches the network.
+
+Results are printed as a table or as JSON, or written in any output mode
+(txt, m3u, html, stdout, run) so they can become playlists.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 {
+			search.workDir = args[0]
+		}
+		var downloaded *bool
+		if cmd.Flags().Changed("downloaded") {
+			downloaded = &search.downloaded
+		}
+		if err := runSearch(search, downloaded, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	searchCmd.Flags().StringSliceVarP(&search.categories, "category", "c", nil, "only media listed in one of these category keys (comma separated)")
+	searchCmd.Flags().StringSliceVar(&search.command, "command", []string{}, "command to execute in run mode")
+	searchCmd.Flags().BoolVar(&search.downloaded, "downloaded", false, "only media that are downloaded (--downloaded=false: only media that are not)")
+	searchCmd.Flags().BoolVar(&search.includeRemoved, "include-removed", false, "also search media that are no longer in the index")
+	searchCmd.Flags().StringSliceVarP(&search.langs, "lang", "l", nil, "only media in these languages (comma separated)")
+	searchCmd.Flags().DurationVar(&search.maxDuration, "max-duration", 0, "only media at most this long (e.g. 10m)")
+	searchCmd.Flags().DurationVar(&search.minDuration, "min-duration", 0, "only media at least this long (e.g. 1h30m)")
+	searchCmd.Flags().StringVarP(&search.mode, "mode", "m", "table", "output mode (table, json, html, m3u, run, stdout, txt)")
+	searchCmd.Flags().StringVarP(&search.outputFilename, "output", "o", "", "output filename for txt/m3u/html modes")
+	searchCmd.Flags().StringVar(&search.regex, "regex", "", "only media whose title matches this regular expression")
+	searchCmd.Flags().StringVar(&search.since, "since", "", "only media published on or after this date (YYYY-MM-DD)")
+	searchCmd.Flags().StringVarP(&search.title, "title", "t", "", "only media whose title contains this text (case-insensitive)")
+	searchCmd.Flags().StringVar(&search.until, "until", "", "only media published on or before this date (YYYY-MM-DD)")
+	rootCmd.AddCommand(searchCmd)
+}
+
+// runSearch runs a search with the given options and writes table and JSON
+// results to w.
+func runSearch(o *searchOptions, downloaded *bool, w io.Writer) error {
+	if o.workDir == "" {
+		o.workDir = "."
+	}
+	switch {
+	case o.mode == "filesystem":
+		return fmt.Errorf("filesystem mode is not supported by search")
+	case o.mode == "run" && len(o.command) == 0:
+		return fmt.Errorf("run mode requires a command to be specified")
+	}
+
+	q := catalog.Query{
+		Title:          o.title,
+		Categories:     o.categories,
+		Langs:          o.langs,
+		MinDuration:    o.minDuration,
+		MaxDuration:    o.maxDuration,
+		Downloaded:     downloaded,
+		IncludeRemoved: o.includeRemoved,
+	}
+	if o.regex != "" {
+		re, err := regexp.Compile(o.regex)
+		if err != nil {
+			return fmt.Errorf("invalid --regex: %w", err)
+		}
+		q.TitleRegexp = re
+	}
+	if o.since != "" {
+		t, err := time.ParseInLocation("2006-01-02", o.since, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid --since date %q (expected YYYY-MM-DD): %w", o.since, err)
+		}
+		q.Since = t
+	}
+	if o.until != "" {
+		t, err := time.ParseInLocation("2006-01-02", o.until, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid --until date %q (expected YYYY-MM-DD): %w", o.until, err)
+		}
+		// Include the whole day
+		q.Until = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+
+	path := catalog.Path(o.workDir)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no catalog found in %s; index the work directory with jwb-index first", o.workDir)
+	}
+	c, err := catalog.Load(path)
+	if err != nil {
+		return err
+	}
+	results := c.Search(q)
+
+	switch o.mode {
+	case "table":
+		return writeSearchTable(w, results)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if results == nil {
+			results = []*catalog.Entry{}
+		}
+		return enc.Encode(results)
+	default:
+		return writeSearchPlaylist(o, results)
+	}
+}
+
+// writeSearchTable prints results as an aligned table.
+func writeSearchTable(w io.Writer, results []*catalog.Entry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATE\tDURATION\tSTATE\tLANG\tCATEGORIES\tTITLE")
+	for _, e := range results {
+		date := "-"
+		if e.Date > 0 {
+			date = time.Unix(e.Date, 0).Format("2006-01-02")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", date, formatDuration(e.Duration), e.State, e.Lang, strings.Join(e.Categories, ","), e.Title)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d results\n", len(results))
+	return err
+}
+
+// writeSearchPlaylist writes results with the regular output writers.
+// Entries from several languages live in different subdirectories, so the
+// subdirectory is folded into the filename and the work directory is used
+// as the base of all local paths.
+func writeSearchPlaylist(o *searchOptions, results []*catalog.Entry) error {
+	cat := &api.Category{Key: "search", Name: "Search results", Home: true}
+	for _, e := range results {
+		media := &api.Media{Name: e.Title, URL: e.URL, Date: e.Date, Duration: e.Duration, Size: e.Size, MD5: e.MD5}
+		if e.Filename != "" {
+			media.Filename = filepath.Join(e.SubDir, e.Filename)
+		}
+		cat.Media = append(cat.Media, media)
+	}
+
+	s := &config.Settings{
+		WorkDir:        o.workDir,
+		Mode:           o.mode,
+		OutputFilename: o.outputFilename,
+		Command:        o.command,
+	}
+	return output.CreateOutput(s, []*api.Category{cat})
+}
+
+// formatDuration formats seconds as h:mm:ss or m:ss.
+func formatDuration(seconds float64) string {
+	if seconds <= 0 {
+		return "-"
+	}
+	d := time.Duration(seconds * float64(time.Second)).Round(time.Second)
+	h, m, sec := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, sec)
+	}
+	return fmt.Sprintf("%d:%02d", m, sec)
+}
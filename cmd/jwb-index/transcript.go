@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/darkace1998/jw-scripts/internal/catalog"
+	"github.com/darkace1998/jw-scripts/internal/config"
+	"github.com/darkace1998/jw-scripts/internal/downloader"
+	"github.com/darkace1998/jw-scripts/internal/storage"
+	"github.com/darkace1998/jw-scripts/internal/subtitle"
+	"github.com/darkace1998/jw-scripts/internal/transcript"
+	"github.com/spf13/cobra"
+)
+
+// transcriptOptions holds the flags of the transcript subcommand.
+type transcriptOptions struct {
+	workDir    string
+	format     string
+	timestamps int
+	categories []string
+	langs      []string
+	storage    string
+}
+
+var transcripts = &transcriptOptions{}
+
+var transcriptCmd = &cobra.Command{
+	Use:   "transcript [work dir]",
+	Short: "Write readable transcripts of downloaded subtitles",
+	Long: `transcript turns the WebVTT subtitles downloaded next to indexed videos into
+plain-text or Markdown transcripts. Titles, dates and categories come from the
+catalog (` + catalog.FileName + `), so the work directory must have been indexed
+with jwb-index --download-subtitles first. It never touches the network.
+
+A transcript is written next to every video that has subtitles, plus one
+combined document per category (transcript-<category>.md) in the same
+directory. With --storage, the subtitles are read from and the transcripts
+written to that library.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(_ *cobra.Command, args []string) {
+		if len(args) > 0 {
+			transcripts.workDir = args[0]
+		}
+		if err := runTranscript(transcripts, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	transcriptCmd.Flags().StringSliceVarP(&transcripts.categories, "category", "c", nil, "only media listed in one of these category keys (comma separated)")
+	transcriptCmd.Flags().StringVarP(&transcripts.format, "format", "f", "md", "transcript format (txt, md)")
+	transcriptCmd.Flags().StringSliceVarP(&transcripts.langs, "lang", "l", nil, "only media in these languages (comma separated)")
+	transcriptCmd.Flags().StringVar(&transcripts.storage, "storage", config.EnvDefault(config.EnvStorage, ""), "library the subtitles were moved to: a directory or s3://bucket/prefix (default: the work directory; env "+config.EnvStorage+")")
+	transcriptCmd.Flags().IntVar(&transcripts.timestamps, "timestamps", 0, "start a timestamped paragraph every N seconds (0: no timestamps)")
+	rootCmd.AddCommand(transcriptCmd)
+}
+
+// combinedTranscript collects the transcripts of one category in one
+// language subdirectory.
+type combinedTranscript struct {
+	lang, subDir, key string
+	items             []combinedItem
+}
+
+type combinedItem struct {
+	entry *catalog.Entry
+	doc   *transcript.Document
+}
+
+// runTranscript writes the transcripts of every catalog entry with
+// downloaded subtitles and prints a summary to w.
+func runTranscript(o *transcriptOptions, w io.Writer) error {
+	if o.workDir == "" {
+		o.workDir = "."
+	}
+	format, err := transcript.ParseFormat(o.format)
+	if err != nil {
+		return err
+	}
+	if o.timestamps < 0 {
+		return fmt.Errorf("--timestamps must not be negative")
+	}
+	every := time.Duration(o.timestamps) * time.Second
+	st, err := storage.New(o.storage, o.workDir)
+	if err != nil {
+		return fmt.Errorf("invalid --storage: %w", err)
+	}
+	s := &config.Settings{WorkDir: o.workDir, Storage: st}
+	ctx := context.Background()
+
+	path := catalog.Path(o.workDir)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no catalog found in %s; index the work directory with jwb-index first", o.workDir)
+	}
+	c, err := catalog.Load(path)
+	if err != nil {
+		return err
+	}
+
+	written := 0
+	combined := make(map[string]*combinedTranscript)
+	for _, e := range c.Search(catalog.Query{Categories: o.categories, Langs: o.langs}) {
+		dir := filepath.Join(o.workDir, e.SubDir)
+		vttPath := findSubtitle(ctx, s, dir, e)
+		if vttPath == "" {
+			continue
+		}
+		doc, err := readTranscript(ctx, s, vttPath, e, every)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s: %v\n", vttPath, err)
+			continue
+		}
+
+		base := strings.TrimSuffix(e.Filename, filepath.Ext(e.Filename))
+		if err := writeTranscript(ctx, s, storage.Join(e.SubDir, base+"."+string(format)), func(w io.Writer) error {
+			return transcript.Write(w, doc, format)
+		}); err != nil {
+			return err
+		}
+		written++
+
+		for _, key := range e.Categories {
+			if len(o.categories) > 0 && !slices.Contains(o.categories, key) {
+				continue
+			}
+			id := e.Lang + ":" + e.SubDir + ":" + key
+			if combined[id] == nil {
+				combined[id] = &combinedTranscript{lang: e.Lang, subDir: e.SubDir, key: key}
+			}
+			combined[id].items = append(combined[id].items, combinedItem{e, doc})
+		}
+	}
+
+	for _, ct := range combined {
+		title := ct.key
+		if cat := c.Category(ct.lang, ct.key); cat != nil && cat.Name != "" {
+			title = cat.Name
+		}
+		// Combined documents are read from start to end, so the oldest
+		// media come first.
+		sort.SliceStable(ct.items, func(i, j int) bool {
+			a, b := ct.items[i].entry, ct.items[j].entry
+			if a.Date != b.Date {
+				return a.Date < b.Date
+			}
+			return a.Title < b.Title
+		})
+		docs := make([]*transcript.Document, len(ct.items))
+		for i, item := range ct.items {
+			docs[i] = item.doc
+		}
+		combinedName := storage.Join(ct.subDir, "transcript-"+ct.key+"."+string(format))
+		if err := writeTranscript(ctx, s, combinedName, func(w io.Writer) error {
+			return transcript.WriteCombined(w, title, docs, format)
+		}); err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "wrote %d transcripts and %d category documents\n", written, len(combined))
+	return err
+}
+
+// findSubtitle returns the path of the subtitles of e in dir, in the work
+// directory or the library of s: the recorded subtitle file, or a WebVTT
+// file named after the video (optionally with the language suffix of
+// --subtitle-langs). It returns "" when there is none.
+func findSubtitle(ctx context.Context, s *config.Settings, dir string, e *catalog.Entry) string {
+	if e.Filename == "" {
+		return ""
+	}
+	base := strings.TrimSuffix(e.Filename, filepath.Ext(e.Filename))
+	candidates := []string{base + ".vtt", base + "." + e.Lang + ".vtt"}
+	if e.SubtitleFilename != "" {
+		candidates = append([]string{e.SubtitleFilename}, candidates...)
+	}
+	for _, name := range candidates {
+		path := filepath.Join(dir, name)
+		if _, err := downloader.Stat(ctx, s, path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// readTranscript parses the subtitles at vttPath into the transcript of e.
+func readTranscript(ctx context.Context, s *config.Settings, vttPath string, e *catalog.Entry, every time.Duration) (*transcript.Document, error) {
+	f, err := downloader.Open(ctx, s, vttPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	track, err := subtitle.Parse(f)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &transcript.Document{Title: e.Title, Timestamps: every > 0, Paragraphs: transcript.Paragraphs(track, every)}
+	if e.Date > 0 {
+		doc.Date = time.Unix(e.Date, 0).UTC()
+	}
+	return doc, nil
+}
+
+// writeTranscript writes a transcript as name in the library of s. The
+// library replaces the file only once it is complete, so an interrupted run
+// never leaves a truncated document behind.
+func writeTranscript(ctx context.Context, s *config.Settings, name string, write func(io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return fmt.Errorf("could not write %s: %w", name, err)
+	}
+	if err := s.Library().Put(ctx, name, &buf, int64(buf.Len())); err != nil {
+		return fmt.Errorf("could not write %s: %w", name, err)
+	}
+	return nil
+}
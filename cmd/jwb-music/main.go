@@ -10,15 +10,22 @@ import (
 	"time"
 
 	"github.com/darkace1998/jw-scripts/internal/api"
+	"github.com/darkace1998/jw-scripts/internal/catalog"
 	"github.com/darkace1998/jw-scripts/internal/config"
 	"github.com/darkace1998/jw-scripts/internal/downloader"
+	"github.com/darkace1998/jw-scripts/internal/httpclient"
 	"github.com/darkace1998/jw-scripts/internal/output"
+	"github.com/darkace1998/jw-scripts/internal/ratelimit"
+	"github.com/darkace1998/jw-scripts/internal/storage"
 	"github.com/spf13/cobra"
 )
 
 var settings = &config.Settings{}
 var sinceDate string
+var limitSchedule string
+var storageLocation string
 var noWarning bool
+var catalogOptions catalog.Options
 
 // musicCategories defines all the music-related categories available for download
 var musicCategories = []string{
@@ -31,7 +38,11 @@ var musicCategories = []string{
 }
 
 // JWBroadcastingCategory is the special category for JW Broadcasting MP3s
-const JWBroadcastingCategory = "JWBroadcasting"
+const JWBroadcastingCategory = api.JWBroadcastingKey
+
+// AudioDescriptionCategory is the special category for the audio
+// description versions of the JW Broadcasting programs
+const AudioDescriptionCategory = api.AudioDescriptionKey
 
 var rootCmd = &cobra.Command{
 	Use:   "jwb-music",
@@ -44,7 +55,8 @@ It downloads from all music-related categories including:
 - Children's Songs
 - Kingdom Melodies
 
-You can also download JW Broadcasting monthly programs as MP3 using:
+You can also download JW Broadcasting monthly programs as MP3 (or as MP4
+with --jwb-format mp4) using:
   jwb-music -c JWBroadcasting
 
 By default, it downloads all available music files. Use flags to customize the behavior.`,
@@ -60,10 +72,13 @@ By default, it downloads all available music files. Use flags to customize the b
 }
 
 func init() {
+	rootCmd.Flags().StringVar(&settings.APIURL, "api-url", config.EnvDefault(config.EnvAPIURL, config.DefaultAPIURL), "base URL of the JW Broadcasting mediator API (env "+config.EnvAPIURL+")")
 	rootCmd.Flags().BoolVar(&settings.Append, "append", false, "append to file instead of overwriting")
+	rootCmd.Flags().BoolVar(&settings.AudioDescriptions, "audio-descriptions", false, "also download the audio description versions of the JW Broadcasting programs, as category "+AudioDescriptionCategory)
 	rootCmd.Flags().BoolVar(&settings.AudioOnly, "audio-only", true, "download only audio (MP3) files, skip video-only content (enabled by default)")
 	rootCmd.Flags().StringSliceVarP(&settings.IncludeCategories, "category", "c", musicCategories, "comma separated list of music categories to include")
 	rootCmd.Flags().BoolVar(&settings.ListCategories, "list-categories", false, "list all available music categories")
+	rootCmd.Flags().DurationVar(&settings.CacheMaxAge, "cache-max-age", 7*24*time.Hour, "maximum age of cached API responses before they are fetched again in full (0 disables the cache)")
 	rootCmd.Flags().BoolVar(&settings.Checksums, "checksum", false, "validate MD5 checksums")
 	rootCmd.Flags().BoolVarP(&settings.Download, "download", "d", true, "download music files (enabled by default)")
 	rootCmd.Flags().StringSliceVar(&settings.ExcludeCategories, "exclude", []string{}, "comma separated list of categories to skip")
@@ -71,18 +86,36 @@ func init() {
 	rootCmd.Flags().Int64Var(&settings.KeepFree, "free", 0, "disk space in MiB to keep free")
 	rootCmd.Flags().BoolVarP(&settings.FriendlyFilenames, "friendly", "H", false, "save downloads with human readable names")
 	rootCmd.Flags().StringVar(&settings.ImportDir, "import", "", "import of music files from this directory (offline)")
+	rootCmd.Flags().IntVar(&settings.IndexWorkers, "index-workers", 4, "number of categories to fetch in parallel while indexing")
+	rootCmd.Flags().IntVarP(&settings.Jobs, "jobs", "j", 1, "number of files to download in parallel")
+	rootCmd.Flags().StringVar(&settings.JWBFormat, "jwb-format", "mp3", "format of the JW Broadcasting programs (mp3, mp4)")
+	rootCmd.Flags().StringVar(&settings.JWBFrom, "jwb-from", "", "first JW Broadcasting issue, as issue number or YYYY-MM (default: 36 issues before --jwb-to)")
+	rootCmd.Flags().StringVar(&settings.JWBTo, "jwb-to", "", "last JW Broadcasting issue, as issue number or YYYY-MM (default: the current month)")
 	rootCmd.Flags().StringVarP(&settings.Lang, "lang", "l", "E", "language code")
 	rootCmd.Flags().BoolVarP(&settings.ListLanguages, "languages", "L", false, "display a list of valid language codes")
 	rootCmd.Flags().BoolVar(&settings.WriteMetadata, "metadata", false, "embed metadata in downloaded files (ID3 for MP3, MP4 atoms for video); unsupported formats get a JSON sidecar file")
-	rootCmd.Flags().Float64VarP(&settings.RateLimit, "limit-rate", "R", 25.0, "maximum download rate, in megabytes/s")
+	rootCmd.Flags().Float64VarP(&settings.RateLimit, "limit-rate", "R", 25.0, "maximum download rate of all downloads together, in megabytes/s (0 for unlimited)")
+	rootCmd.Flags().StringVar(&limitSchedule, "limit-schedule", "", "daily download rates overriding --limit-rate, as HH:MM-HH:MM=RATE windows in local time (e.g. 22:00-06:00=0,06:00-22:00=2)")
 	rootCmd.Flags().StringVarP(&settings.Mode, "mode", "m", "", "output mode (filesystem, html, m3u, run, stdout, txt)")
 	rootCmd.Flags().StringVarP(&settings.OutputFilename, "output", "o", "", "output filename for txt/m3u/html modes")
+	rootCmd.Flags().BoolVar(&catalogOptions.Disabled, "no-catalog", false, "do not record indexed music in the catalog file ("+catalog.FileName+") in the work directory")
 	rootCmd.Flags().BoolVar(&noWarning, "no-warning", false, "do not warn when the disk space limit (--free) seems wrong")
+	rootCmd.Flags().StringVar(&settings.PubMediaURL, "pub-media-url", config.EnvDefault(config.EnvPubMediaURL, config.DefaultPubMediaURL), "URL of the publication media API (env "+config.EnvPubMediaURL+")")
+	rootCmd.Flags().StringVar(&catalogOptions.ReportFormat, "report", "", "print the changes since the previous run (text, json)")
+	rootCmd.Flags().StringVar(&catalogOptions.ReportFile, "report-file", "", "write the --report to this file instead of standard output")
+	rootCmd.Flags().IntVarP(&settings.Quality, "quality", "Q", 720, "maximum video quality of JW Broadcasting programs with --jwb-format mp4")
+	rootCmd.Flags().StringVar(&settings.QuarantineDir, "quarantine", "", "directory receiving downloads that fail verification (default: "+downloader.QuarantineDirName+" in the work directory)")
 	rootCmd.Flags().IntVarP(&settings.Quiet, "quiet", "q", 0, "less info, can be used multiple times")
+	rootCmd.Flags().BoolVar(&settings.Refresh, "refresh", false, "ignore cached API responses and fetch everything again")
+	rootCmd.Flags().IntVar(&settings.Retries, "retries", httpclient.DefaultMaxRetries, "number of times a failed request is retried (with exponential backoff)")
 	rootCmd.Flags().BoolVar(&settings.SafeFilenames, "safe-filenames", runtime.GOOS == "windows", "use filesystem-safe filenames (automatically enabled on Windows)")
+	rootCmd.Flags().IntVar(&settings.Segments, "segments", 1, "number of connections used to download each file of 32 MiB or more")
 	rootCmd.Flags().StringVar(&sinceDate, "since", "", "only index music newer than this date (YYYY-MM-DD)")
 	rootCmd.Flags().StringVar(&settings.Sort, "sort", "", "sort output (newest, oldest, name, random)")
+	rootCmd.Flags().StringVar(&storageLocation, "storage", config.EnvDefault(config.EnvStorage, ""), "where downloads, metadata and playlists are kept: a directory or s3://bucket/prefix (default: the work directory; env "+config.EnvStorage+")")
 	rootCmd.Flags().BoolVar(&settings.Update, "update", false, "update existing categories with the latest music")
+	rootCmd.Flags().StringVar(&settings.UserAgent, "user-agent", config.EnvDefault(config.EnvUserAgent, ""), "User-Agent header sent with every request (env "+config.EnvUserAgent+")")
+	rootCmd.Flags().IntVar(&settings.VerifyRetries, "verify-retries", 2, "number of times a download failing its size, checksum or structure check is downloaded again")
 }
 
 func main() {
@@ -95,6 +128,12 @@ func main() {
 func run(s *config.Settings) error {
 	s.Warning = !noWarning
 
+	// The work directory must be known before the client is created, since
+	// the API response cache lives inside it.
+	if s.WorkDir == "" {
+		s.WorkDir = "./music"
+	}
+
 	client := api.NewClient(s)
 
 	if s.ListLanguages {
@@ -124,7 +163,8 @@ func run(s *config.Settings) error {
 			}
 		}
 		// Also show the JW Broadcasting option
-		fmt.Printf("  JW Broadcasting - Audio (%s)\n", JWBroadcastingCategory)
+		fmt.Printf("  JW Broadcasting - Monthly programs, MP3 or MP4 with --jwb-format (%s)\n", JWBroadcastingCategory)
+		fmt.Printf("  JW Broadcasting - Audio description versions (%s)\n", AudioDescriptionCategory)
 		return nil
 	}
 
@@ -132,6 +172,10 @@ func run(s *config.Settings) error {
 		return fmt.Errorf("please use --mode or --download (download is enabled by default)")
 	}
 
+	if err := catalogOptions.Check(s); err != nil {
+		return err
+	}
+
 	if s.Update {
 		s.Append = true
 		if s.Sort == "" {
@@ -148,12 +192,32 @@ func run(s *config.Settings) error {
 		s.MinDate = t.Unix()
 	}
 
+	schedule, err := ratelimit.ParseSchedule(limitSchedule)
+	if err != nil {
+		return fmt.Errorf("invalid --limit-schedule: %w", err)
+	}
+	s.RateSchedule = schedule
+
+	st, err := storage.New(storageLocation, s.WorkDir)
+	if err != nil {
+		return fmt.Errorf("invalid --storage: %w", err)
+	}
+	s.Storage = st
+	if err := downloader.CheckDiskLimit(s); err != nil {
+		return err
+	}
+
+	jwbFormat, err := api.ParsePubMediaFormat(s.JWBFormat)
+	if err != nil {
+		return err
+	}
+	if _, _, err := client.IssueRange(); err != nil {
+		return err
+	}
+
 	// Convert MiB to bytes for disk space calculations
 	s.KeepFree *= 1024 * 1024
 
-	if s.WorkDir == "" {
-		s.WorkDir = "./music"
-	}
 	if !strings.HasPrefix(s.Mode, "stdout") {
 		s.SubDir = "jwb-music-" + s.Lang
 	}
@@ -162,22 +226,38 @@ func run(s *config.Settings) error {
 	var data []*api.Category
 
 	hasJWBroadcasting := false
+	jwbCategories := make(map[string]bool)
 	var otherCategories []string
 	for _, cat := range s.IncludeCategories {
-		if cat == JWBroadcastingCategory {
+		switch cat {
+		case JWBroadcastingCategory:
 			hasJWBroadcasting = true
-		} else {
+			jwbCategories[cat] = true
+			if s.AudioDescriptions {
+				jwbCategories[AudioDescriptionCategory] = true
+			}
+		case AudioDescriptionCategory:
+			// Asking for the audio descriptions alone implies
+			// --audio-descriptions
+			hasJWBroadcasting = true
+			jwbCategories[cat] = true
+			s.AudioDescriptions = true
+		default:
 			otherCategories = append(otherCategories, cat)
 		}
 	}
 
-	// Fetch JW Broadcasting MP3s if requested
+	// Fetch the JW Broadcasting programs if requested
 	if hasJWBroadcasting {
-		jwbData, err := client.GetBroadcastingMP3s()
+		jwbData, err := client.GetBroadcastingPrograms(jwbFormat)
 		if err != nil {
 			return fmt.Errorf("failed to fetch JW Broadcasting: %v", err)
 		}
-		data = append(data, jwbData...)
+		for _, cat := range jwbData {
+			if jwbCategories[cat.Key] {
+				data = append(data, cat)
+			}
+		}
 	}
 
 	// Fetch other categories using the standard API
@@ -205,6 +285,10 @@ func run(s *config.Settings) error {
 		}
 	}
 
+	if err := catalog.Update(s, []api.IndexedLanguage{{Settings: s, Data: data}}, catalogOptions); err != nil {
+		return err
+	}
+
 	if s.Mode != "" {
 		if err := output.CreateOutput(s, data); err != nil {
 			return err
@@ -268,15 +352,15 @@ func importOfflineMedia(s *config.Settings) ([]*api.Category, error) {
 		// must always be set, not only when --friendly is enabled.
 		media.FriendlyName = entry.Name()
 
-		cat.Contents = append(cat.Contents, media)
+		cat.Media = append(cat.Media, media)
 	}
 
-	if len(cat.Contents) == 0 {
+	if len(cat.Media) == 0 {
 		return nil, nil
 	}
 
 	if s.Quiet < 1 {
-		fmt.Fprintf(os.Stderr, "imported %d files from %s\n", len(cat.Contents), s.ImportDir)
+		fmt.Fprintf(os.Stderr, "imported %d files from %s\n", len(cat.Media), s.ImportDir)
 	}
 
 	return []*api.Category{cat}, nil
@@ -2,10 +2,8 @@
 package main
 
 import (
-	"encoding/json"
+	"flag"
 	"fmt"
-	"net/http"
-	"os"
 	"strings"
 
 	"github.com/darkace1998/jw-scripts/internal/api"
@@ -22,6 +20,8 @@ func main() {
 		ExcludeCategories: []string{},
 		Quiet:             0,
 	}
+	config.EndpointFlags(flag.CommandLine, settings)
+	flag.Parse()
 
 	client := api.NewClient(settings)
 
@@ -89,23 +89,11 @@ func main() {
 	// Also check if there are any hidden/special categories we missed
 	fmt.Printf("\n=== Checking All Root Categories for Publication Clues ===\n")
 
-	baseURL := "https://data.jw-api.org/mediator/v1"
-	resp, err := http.Get(fmt.Sprintf("%s/categories/E/?detailed=1", baseURL))
+	rootResp, err := client.FetchRootCategories("E")
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		return
 	}
-	defer func() {
-		if closeErr := resp.Body.Close(); closeErr != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to close response body: %v\n", closeErr)
-		}
-	}()
-
-	var rootResp api.RootCategoriesResponse
-	if err := json.NewDecoder(resp.Body).Decode(&rootResp); err != nil {
-		fmt.Printf("Error decoding response: %v\n", err)
-		return
-	}
 
 	fmt.Printf("All categories (including excluded ones):\n")
 	for _, cat := range rootResp.Categories {
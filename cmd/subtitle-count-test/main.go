@@ -2,6 +2,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"strings"
@@ -14,6 +15,10 @@ func main() {
 	fmt.Println("Simulating Windows vs Linux subtitle count difference...")
 	fmt.Println("=" + strings.Repeat("=", 60))
 
+	endpoints := &config.Settings{}
+	config.EndpointFlags(flag.CommandLine, endpoints)
+	flag.Parse()
+
 	// Test with both SafeFilenames=true (Windows-like) and false (Linux-like)
 	for _, safeFilenames := range []bool{false, true} {
 		platform := "Linux-like"
@@ -29,6 +34,9 @@ func main() {
 			ExcludeCategories: []string{"VODSJJMeetings"},
 			SafeFilenames:     safeFilenames,
 			Quiet:             1,
+			APIURL:            endpoints.APIURL,
+			PubMediaURL:       endpoints.PubMediaURL,
+			UserAgent:         endpoints.UserAgent,
 		}
 
 		client := api.NewClient(settings)
@@ -41,10 +49,8 @@ func main() {
 		// Simulate the download queue building process
 		var mediaList []*api.Media
 		for _, cat := range data {
-			for _, item := range cat.Contents {
-				if media, ok := item.(*api.Media); ok {
-					mediaList = append(mediaList, media)
-				}
+			for _, media := range cat.Media {
+				mediaList = append(mediaList, media)
 			}
 		}
 
@@ -3,6 +3,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -26,6 +27,8 @@ func main() {
 		ExcludeCategories: []string{"VODSJJMeetings"},
 		Quiet:             0,
 	}
+	config.EndpointFlags(flag.CommandLine, settings)
+	flag.Parse()
 
 	client := api.NewClient(settings)
 	data, err := client.ParseBroadcasting()
@@ -43,30 +46,28 @@ func main() {
 	fmt.Println("Analyzing media items...")
 
 	for _, cat := range data {
-		for _, item := range cat.Contents {
-			if media, ok := item.(*api.Media); ok {
-				totalMedia++
-
-				if media.SubtitleURL != "" {
-					mediaWithSubtitles++
-					subtitleURLs = append(subtitleURLs, media.SubtitleURL)
-
-					// Check for potentially problematic URLs
-					if !utf8.ValidString(media.SubtitleURL) {
-						problematicURLs = append(problematicURLs, fmt.Sprintf("INVALID_UTF8: %s", media.SubtitleURL))
-					}
-					if strings.Contains(media.SubtitleURL, " ") {
-						problematicURLs = append(problematicURLs, fmt.Sprintf("SPACE: %s", media.SubtitleURL))
-					}
-					if !strings.HasPrefix(media.SubtitleURL, "http") {
-						problematicURLs = append(problematicURLs, fmt.Sprintf("NO_HTTP: %s", media.SubtitleURL))
-					}
-					if !utf8.ValidString(media.Name) {
-						problematicURLs = append(problematicURLs, fmt.Sprintf("INVALID_UTF8_NAME: %s -> %s", media.Name, media.SubtitleURL))
-					}
-				} else {
-					emptySubtitleURLs = append(emptySubtitleURLs, fmt.Sprintf("No subtitle for: %s", media.Name))
+		for _, media := range cat.Media {
+			totalMedia++
+
+			if media.SubtitleURL != "" {
+				mediaWithSubtitles++
+				subtitleURLs = append(subtitleURLs, media.SubtitleURL)
+
+				// Check for potentially problematic URLs
+				if !utf8.ValidString(media.SubtitleURL) {
+					problematicURLs = append(problematicURLs, fmt.Sprintf("INVALID_UTF8: %s", media.SubtitleURL))
+				}
+				if strings.Contains(media.SubtitleURL, " ") {
+					problematicURLs = append(problematicURLs, fmt.Sprintf("SPACE: %s", media.SubtitleURL))
+				}
+				if !strings.HasPrefix(media.SubtitleURL, "http") {
+					problematicURLs = append(problematicURLs, fmt.Sprintf("NO_HTTP: %s", media.SubtitleURL))
+				}
+				if !utf8.ValidString(media.Name) {
+					problematicURLs = append(problematicURLs, fmt.Sprintf("INVALID_UTF8_NAME: %s -> %s", media.Name, media.SubtitleURL))
 				}
+			} else {
+				emptySubtitleURLs = append(emptySubtitleURLs, fmt.Sprintf("No subtitle for: %s", media.Name))
 			}
 		}
 	}
@@ -1,12 +1,24 @@
 // Package api provides client and data structures for interacting with the JW.org API.
 package api
 
+import (
+	"maps"
+	"slices"
+)
+
 // Category represents a category of media on JW Broadcasting.
+//
+// Categories form a tree through Subcategories. A category can be listed
+// under several parents, so the tree is really a graph; use Walk to
+// traverse it safely.
 type Category struct {
-	Key      string
-	Name     string
-	Home     bool
-	Contents []interface{} // Can contain either *Category or *Media
+	Key           string
+	Name          string
+	Home          bool
+	ImageURL      string
+	ImageFilename string
+	Subcategories []*Category
+	Media         []*Media
 }
 
 // Media represents a single media item, like a video or audio file.
@@ -22,6 +34,46 @@ type Media struct {
 	FriendlyName             string
 	SubtitleFilename         string
 	FriendlySubtitleFilename string
+	Description              string
+	GUID                     string
+	NaturalKey               string
+	Tags                     []string
+	Images                   Images
+	ImageURL                 string // best-sized image, downloaded with --download-images
+	ImageFilename            string
+	SubtitleLanguages        []string // languages in which subtitles are available
+	// LanguageAgnosticNaturalKey identifies the underlying media item
+	// independent of its language; it is shared by all translations.
+	LanguageAgnosticNaturalKey string
+	// Translations lists the same media item in the other languages of a
+	// multi-language run.
+	Translations []Translation
+	// Subtitles lists the subtitles requested with --subtitle-langs, one per
+	// language, filled in by Client.FetchSubtitles.
+	Subtitles []Subtitle
+	// Label is the resolution label of the selected file ("720p").
+	Label string
+	// LocalFilename and LocalLabel describe the local copy from an earlier
+	// run, as recorded in the catalog; the downloader updates them when it
+	// downloads the selected file.
+	LocalFilename string
+	LocalLabel    string
+}
+
+// Subtitle is a subtitle file of a media item in a particular language.
+type Subtitle struct {
+	Lang     string
+	URL      string
+	Filename string
+}
+
+// Translation refers to the same media item indexed in another language.
+type Translation struct {
+	Lang     string
+	Name     string
+	URL      string
+	SubDir   string // subdirectory of the work directory holding Filename
+	Filename string
 }
 
 // File represents a media file, like a video or audio file.
@@ -36,6 +88,33 @@ type File struct {
 	Mimetype               string    `json:"mimetype"`
 }
 
+// Images holds the image URLs of a media item or category, keyed by image type (e.g.
+// "lsr" landscape, "sqr" square, "wss" widescreen) and then by size (e.g.
+// "sm", "md", "lg", "xl").
+type Images map[string]map[string]string
+
+// Preferred image types and sizes, best first. Landscape artwork suits
+// media-center posters and thumbnails best.
+var (
+	imageTypePreference = []string{"lsr", "wss", "sqr", "pnr"}
+	imageSizePreference = []string{"xl", "lg", "md", "sm", "xs"}
+)
+
+// Best returns the URL of the best-sized image: the largest size of the
+// most preferred image type. Unknown types and sizes are only used when no
+// preferred one is available. It returns "" when there are no images.
+func (im Images) Best() string {
+	for _, typ := range slices.Concat(imageTypePreference, slices.Sorted(maps.Keys(im))) {
+		sizes := im[typ]
+		for _, size := range slices.Concat(imageSizePreference, slices.Sorted(maps.Keys(sizes))) {
+			if u := sizes[size]; u != "" {
+				return u
+			}
+		}
+	}
+	return ""
+}
+
 // Subtitles represents the subtitles for a media file.
 type Subtitles struct {
 	URL string `json:"url"`
@@ -57,20 +136,37 @@ type CategoryResponse struct {
 	Category struct {
 		Key           string `json:"key"`
 		Name          string `json:"name"`
+		Images        Images `json:"images"`
 		Subcategories []struct {
 			Key  string `json:"key"`
 			Name string `json:"name"`
 		} `json:"subcategories"`
-		Media []struct {
-			Title           string `json:"title"`
-			Type            string `json:"type"`
-			PrimaryCategory string `json:"primaryCategory"`
-			FirstPublished  string `json:"firstPublished"`
-			Files           []File `json:"files"`
-		} `json:"media"`
+		Media []MediaItem `json:"media"`
 	} `json:"category"`
 }
 
+// MediaItem is a media item as returned by the category and media-item API
+// endpoints.
+type MediaItem struct {
+	Title                      string   `json:"title"`
+	Description                string   `json:"description"`
+	Type                       string   `json:"type"`
+	PrimaryCategory            string   `json:"primaryCategory"`
+	FirstPublished             string   `json:"firstPublished"`
+	GUID                       string   `json:"guid"`
+	NaturalKey                 string   `json:"naturalKey"`
+	LanguageAgnosticNaturalKey string   `json:"languageAgnosticNaturalKey"`
+	Tags                       []string `json:"tags"`
+	Images                     Images   `json:"images"`
+	SubtitleLanguages          []string `json:"availableSubtitleLanguages"`
+	Files                      []File   `json:"files"`
+}
+
+// MediaItemResponse is the response from the media-item API endpoint.
+type MediaItemResponse struct {
+	Media []MediaItem `json:"media"`
+}
+
 // RootCategoriesResponse is the response from the root categories API endpoint.
 type RootCategoriesResponse struct {
 	Categories []struct {
@@ -81,29 +177,3 @@ type RootCategoriesResponse struct {
 		Tags        []string `json:"tags"`
 	} `json:"categories"`
 }
-
-// PubMediaFile represents a single file from the Publication Media API
-type PubMediaFile struct {
-	Title    string `json:"title"`
-	File     struct {
-		URL              string `json:"url"`
-		ModifiedDatetime string `json:"modifiedDatetime"`
-		Checksum         string `json:"checksum"`
-	} `json:"file"`
-	Filesize   int64   `json:"filesize"`
-	TrackImage struct {
-		URL string `json:"url"`
-	} `json:"trackImage"`
-	Track    int     `json:"track"`
-	Mimetype string  `json:"mimetype"`
-	Duration float64 `json:"duration"`
-}
-
-// PubMediaResponse is the response from the Publication Media API endpoint.
-type PubMediaResponse struct {
-	PubName string `json:"pubName"`
-	Pub     string `json:"pub"`
-	Files   map[string]struct {
-		MP3 []PubMediaFile `json:"MP3"`
-	} `json:"files"`
-}
@@ -0,0 +1,376 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/darkace1998/jw-scripts/internal/pubmedia"
+)
+
+const (
+	// jwbStartYear and jwbStartMonth mark when JW Broadcasting began (October 2014 = issue 1).
+	// Issue numbers are sequential months: issue = (year-2014)*12 + month - 10 + 1
+	jwbStartYear  = 2014
+	jwbStartMonth = 10
+
+	// defaultJWBIssues is how many issues before the last one are indexed
+	// when no first issue is given (3 years).
+	defaultJWBIssues = 36
+)
+
+// Keys of the categories of the JW Broadcasting monthly programs and of
+// their audio description versions.
+const (
+	JWBroadcastingKey   = "JWBroadcasting"
+	AudioDescriptionKey = "JWBroadcastingAudioDescription"
+)
+
+// PubMediaFormat is a file format of the Publication Media API.
+type PubMediaFormat string
+
+// Publication media formats of the JW Broadcasting monthly programs.
+const (
+	PubMediaMP3 PubMediaFormat = pubmedia.MP3
+	PubMediaMP4 PubMediaFormat = pubmedia.MP4
+)
+
+var (
+	// ErrUnknownPubMediaFormat is returned for formats other than MP3 and MP4.
+	ErrUnknownPubMediaFormat = errors.New("unknown publication media format")
+	// ErrInvalidIssue is returned for JW Broadcasting issues that are
+	// neither an issue number nor a YYYY-MM month since October 2014.
+	ErrInvalidIssue = errors.New("invalid JW Broadcasting issue")
+)
+
+// ParsePubMediaFormat returns the format named by s (case-insensitive); an
+// empty string is PubMediaMP3.
+func ParsePubMediaFormat(s string) (PubMediaFormat, error) {
+	switch f := PubMediaFormat(strings.ToUpper(s)); f {
+	case "":
+		return PubMediaMP3, nil
+	case PubMediaMP3, PubMediaMP4:
+		return f, nil
+	default:
+		return "", fmt.Errorf("%w %q (expected mp3 or mp4)", ErrUnknownPubMediaFormat, s)
+	}
+}
+
+// Issue returns the number of the JW Broadcasting issue of the month of t.
+func Issue(t time.Time) int {
+	return (t.Year()-jwbStartYear)*12 + int(t.Month()) - jwbStartMonth + 1
+}
+
+// ParseIssue returns the JW Broadcasting issue number given by s, either
+// as a number ("120") or as the year and month of the issue ("2024-09").
+func ParseIssue(s string) (int, error) {
+	var issue int
+	if year, month, ok := strings.Cut(s, "-"); ok {
+		t, err := time.Parse("2006-01", s)
+		if err != nil || len(year) != 4 || len(month) != 2 {
+			return 0, fmt.Errorf("%w %q (expected an issue number or YYYY-MM)", ErrInvalidIssue, s)
+		}
+		issue = Issue(t)
+	} else {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("%w %q (expected an issue number or YYYY-MM)", ErrInvalidIssue, s)
+		}
+		issue = n
+	}
+	if issue < 1 {
+		return 0, fmt.Errorf("%w %q (JW Broadcasting started with issue 1 in %d-%02d)", ErrInvalidIssue, s, jwbStartYear, jwbStartMonth)
+	}
+	return issue, nil
+}
+
+// IssueRange returns the first and last JW Broadcasting issue selected by
+// settings.JWBFrom and settings.JWBTo. Without JWBTo the range ends with
+// the issue of the current month; without JWBFrom it starts 36 issues
+// before its end.
+func (c *Client) IssueRange() (first, last int, err error) {
+	last = Issue(time.Now())
+	if c.settings.JWBTo != "" {
+		if last, err = ParseIssue(c.settings.JWBTo); err != nil {
+			return 0, 0, err
+		}
+	}
+	first = max(last-defaultJWBIssues, 1)
+	if c.settings.JWBFrom != "" {
+		if first, err = ParseIssue(c.settings.JWBFrom); err != nil {
+			return 0, 0, err
+		}
+	}
+	if first > last {
+		return 0, 0, fmt.Errorf("%w range: issue %d is after issue %d", ErrInvalidIssue, first, last)
+	}
+	return first, last, nil
+}
+
+// GetBroadcastingMP3s fetches the JW Broadcasting monthly programs as MP3
+// from the Publication Media API.
+func (c *Client) GetBroadcastingMP3s() ([]*Category, error) {
+	return c.GetBroadcastingPrograms(PubMediaMP3)
+}
+
+// pubMediaResult is the outcome of fetching one publication.
+type pubMediaResult struct {
+	files []pubmedia.File
+	err   error
+}
+
+// GetBroadcastingPrograms fetches the JW Broadcasting monthly programs of
+// the issues in IssueRange from the Publication Media API, in the given
+// format. Of the MP4 files of a program, the one chosen by the selection
+// policy at settings.Quality is used.
+//
+// Issues are fetched concurrently by at most settings.IndexWorkers
+// workers, but processed newest first, so the media order and the unique
+// filenames do not depend on the timing of the requests. Issues without
+// files are skipped quietly.
+//
+// The audio description versions of the programs are only included with
+// settings.AudioDescriptions, in a separate category (AudioDescriptionKey)
+// with "_AD" filenames.
+func (c *Client) GetBroadcastingPrograms(format PubMediaFormat) ([]*Category, error) {
+	first, last, err := c.IssueRange()
+	if err != nil {
+		return nil, err
+	}
+	pubCodes := make([]string, 0, last-first+1)
+	for issue := last; issue >= first; issue-- {
+		pubCodes = append(pubCodes, fmt.Sprintf("jwb-%d", issue))
+	}
+
+	cat := &Category{
+		Key:  JWBroadcastingKey,
+		Name: "JW Broadcasting (Audio)",
+		Home: true,
+	}
+	adCat := &Category{
+		Key:  AudioDescriptionKey,
+		Name: "JW Broadcasting (Audio Description)",
+		Home: true,
+	}
+	if format == PubMediaMP4 {
+		cat.Name = "JW Broadcasting (Video)"
+		adCat.Name = "JW Broadcasting (Video, Audio Description)"
+	}
+
+	usedFilenames := make(map[string]bool)
+	var missing []string
+	for i, res := range c.fetchPubMediaAll(pubCodes, format) {
+		if errors.Is(res.err, pubmedia.ErrNotFound) {
+			missing = append(missing, pubCodes[i])
+			continue
+		}
+		if res.err != nil {
+			if c.settings.Quiet < 2 {
+				fmt.Fprintf(os.Stderr, "could not fetch %s: %v\n", pubCodes[i], res.err)
+			}
+			continue
+		}
+		files := res.files
+		if format == PubMediaMP4 {
+			files = c.selectPrograms(files)
+		}
+
+		for _, f := range files {
+			// Audio description versions are only kept with
+			// --audio-descriptions, in a category of their own
+			ad := isAudioDescription(&f)
+			if ad && !c.settings.AudioDescriptions {
+				continue
+			}
+
+			media := &Media{
+				URL:      f.File.URL,
+				Name:     f.Title,
+				MD5:      f.File.Checksum,
+				Size:     f.Filesize,
+				Duration: f.Duration,
+				Label:    f.Label,
+			}
+
+			// Parse date from the modified datetime
+			if f.File.ModifiedDatetime != "" {
+				if date, err := parsePubMediaDate(f.File.ModifiedDatetime); err == nil {
+					if date.Unix() < c.settings.MinDate {
+						continue
+					}
+					if c.settings.MaxDate > 0 && date.Unix() > c.settings.MaxDate {
+						continue
+					}
+					media.Date = date.Unix()
+				}
+			}
+
+			media.Filename = getFilename(media.URL, c.settings.SafeFilenames)
+			if ad {
+				// The audio description version often has the same title
+				// as the program and is listed next to it in playlists
+				if !strings.Contains(strings.ToLower(media.Name), "audio description") {
+					media.Name += " (Audio Description)"
+				}
+				media.Filename = audioDescriptionFilename(media.Filename)
+			}
+			media.FriendlyName = getFriendlyFilename(media.Name, media.URL, c.settings.SafeFilenames)
+
+			// Ensure unique filenames
+			if c.settings.FriendlyFilenames {
+				media.Filename = makeUniqueFilename(media.FriendlyName, usedFilenames)
+			} else {
+				media.Filename = makeUniqueFilename(media.Filename, usedFilenames)
+			}
+
+			if ad {
+				adCat.Media = append(adCat.Media, media)
+			} else {
+				cat.Media = append(cat.Media, media)
+			}
+		}
+	}
+
+	if len(missing) > 0 && c.settings.Quiet < 1 {
+		fmt.Fprintf(os.Stderr, "no %s files for %d of %d issues: %s\n", format, len(missing), len(pubCodes), strings.Join(missing, ", "))
+	}
+
+	var result []*Category
+	for _, category := range []*Category{cat, adCat} {
+		if len(category.Media) > 0 {
+			result = append(result, category)
+		}
+	}
+	return result, nil
+}
+
+// isAudioDescription reports whether f is the audio description version
+// of a program: its track number is 100 or more, or its title says so.
+func isAudioDescription(f *pubmedia.File) bool {
+	return f.Track >= 100 || strings.Contains(strings.ToLower(f.Title), "audio description")
+}
+
+// audioDescriptionFilename marks the filename of an audio description
+// version with an "_AD" suffix, unless it already has one, so it never
+// collides with the program itself.
+func audioDescriptionFilename(name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if strings.HasSuffix(strings.ToUpper(base), "_AD") {
+		return name
+	}
+	return base + "_AD" + ext
+}
+
+// fetchPubMediaAll fetches the files of the given publications
+// concurrently, using at most settings.IndexWorkers simultaneous requests.
+// The results are returned in the same order as pubCodes.
+func (c *Client) fetchPubMediaAll(pubCodes []string, format PubMediaFormat) []pubMediaResult {
+	results := make([]pubMediaResult, len(pubCodes))
+
+	workers := c.settings.IndexWorkers
+	if workers < 1 {
+		workers = defaultIndexWorkers
+	}
+	if workers > len(pubCodes) {
+		workers = len(pubCodes)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if c.settings.Quiet < 1 {
+					fmt.Fprintf(os.Stderr, "indexing: %s\n", pubCodes[i])
+				}
+				files, err := c.fetchPubMedia(pubCodes[i], format)
+				results[i] = pubMediaResult{files: files, err: err}
+			}
+		}()
+	}
+	for i := range pubCodes {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// fetchPubMedia fetches the files of a publication in the given format
+// in the configured language. It returns an error matching
+// pubmedia.ErrNotFound when the API has no such files.
+func (c *Client) fetchPubMedia(pubCode string, format PubMediaFormat) ([]pubmedia.File, error) {
+	pub, err := c.pubMedia.Get(context.Background(), pubmedia.Query{
+		Pub:     pubCode,
+		Formats: []string{string(format)},
+		Langs:   []string{c.settings.Lang},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pub.FilesOf(c.settings.Lang, string(format))
+}
+
+// selectPrograms picks one file per track from the MP4 files of an issue,
+// which lists every program in several resolutions, using the configured
+// selection policy. Tracks keep the order in which they are first listed.
+func (c *Client) selectPrograms(files []pubmedia.File) []pubmedia.File {
+	var tracks []int
+	byTrack := make(map[int][]pubmedia.File)
+	for _, f := range files {
+		if _, ok := byTrack[f.Track]; !ok {
+			tracks = append(tracks, f.Track)
+		}
+		byTrack[f.Track] = append(byTrack[f.Track], f)
+	}
+
+	var selected []pubmedia.File
+	for _, track := range tracks {
+		group := byTrack[track]
+		candidates := make([]File, len(group))
+		for i, f := range group {
+			candidates[i] = File{
+				ProgressiveDownloadURL: f.File.URL,
+				Checksum:               f.File.Checksum,
+				Filesize:               f.Filesize,
+				Duration:               f.Duration,
+				Label:                  f.Label,
+				Subtitled:              f.Subtitled,
+				Mimetype:               f.Mimetype,
+			}
+		}
+		best := c.selectFile(group[0].Title, candidates)
+		if best == nil {
+			if c.settings.Quiet < 2 {
+				fmt.Fprintf(os.Stderr, "no file matches the selection policy for: %s\n", group[0].Title)
+			}
+			continue
+		}
+		for i := range candidates {
+			if &candidates[i] == best {
+				selected = append(selected, group[i])
+			}
+		}
+	}
+	return selected
+}
+
+// parsePubMediaDate parses dates from the Publication Media API format.
+func parsePubMediaDate(dateString string) (time.Time, error) {
+	// Format: "2026-01-18 19:25:59"
+	t, err := time.Parse("2006-01-02 15:04:05", dateString)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
@@ -7,34 +7,26 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/darkace1998/jw-scripts/internal/config"
+	"github.com/darkace1998/jw-scripts/internal/httpclient"
+	"github.com/darkace1998/jw-scripts/internal/pubmedia"
 	"github.com/darkace1998/jw-scripts/internal/util"
 )
 
 const (
-	baseURL     = "https://data.jw-api.org/mediator/v1"
-	pubMediaURL = "https://b.jw-cdn.org/apis/pub-media/GETPUBMEDIALINKS"
-	// jwbStartYear and jwbStartMonth mark when JW Broadcasting began (October 2014 = issue 1).
-	// Issue numbers are sequential months: issue = (year-2014)*12 + month - 10 + 1
-	jwbStartYear  = 2014
-	jwbStartMonth = 10
-
-	// qualityMatchBonus is the ranking bonus applied when a video resolution
-	// is within the requested quality limit.
-	qualityMatchBonus = 200
-
-	// subtitleMatchBonus is the ranking bonus applied when a video's subtitle
-	// state matches the requested preference.
-	subtitleMatchBonus = 100
-
 	// maxResponseSize is the maximum allowed API response body size (10 MiB).
 	maxResponseSize = 10 << 20
+
+	// defaultIndexWorkers is the number of categories fetched in parallel
+	// when settings.IndexWorkers is not set.
+	defaultIndexWorkers = 4
 )
 
 // parseDateMillisRegex strips milliseconds from date strings for fallback parsing.
@@ -42,26 +34,101 @@ var parseDateMillisRegex = regexp.MustCompile(`\.\d+Z$`)
 
 // Client is a client for the JW.ORG API.
 type Client struct {
-	baseURL    string
-	httpClient *http.Client
-	settings   *config.Settings
+	baseURL     string
+	pubMediaURL string
+	userAgent   string
+	httpClient  *http.Client
+	settings    *config.Settings
+
+	// pubMedia queries the publication media API at pubMediaURL.
+	pubMedia *pubmedia.Client
+
+	// explainMu keeps --explain-selection output of concurrently indexed
+	// categories from interleaving.
+	explainMu sync.Mutex
+}
+
+// Option configures optional behaviour of a Client.
+type Option func(*Client)
+
+// WithBaseURL sets the base URL of the mediator API.
+func WithBaseURL(u string) Option {
+	return func(c *Client) { c.baseURL = strings.TrimSuffix(u, "/") }
+}
+
+// WithPubMediaURL sets the URL of the publication media API.
+func WithPubMediaURL(u string) Option {
+	return func(c *Client) { c.pubMediaURL = u }
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
+}
+
+// WithHTTPClient makes the client send its requests through hc. The client
+// is used as-is: retries and caching configured in the settings are not
+// applied to it.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// NewClient creates a new API client. Failed requests are retried up to
+// settings.Retries times. When settings.CacheMaxAge is set, responses are
+// cached below the work directory and revalidated with conditional requests.
+// Endpoints and the User-Agent are taken from the settings unless they are
+// overridden by opts.
+func NewClient(s *config.Settings, opts ...Option) *Client {
+	var onRetry func(httpclient.Retry)
+	if s.Quiet < 2 {
+		onRetry = httpclient.LogRetries(os.Stderr)
+	}
+	httpClient := httpclient.New(30*time.Second, s.Retries, onRetry)
+	if s.CacheMaxAge > 0 && s.WorkDir != "" {
+		cache := &httpclient.Cache{
+			Dir:     filepath.Join(s.WorkDir, httpclient.CacheDirName),
+			MaxAge:  s.CacheMaxAge,
+			Refresh: s.Refresh,
+		}
+		httpClient = cache.Wrap(httpClient)
+	}
+	c := &Client{
+		baseURL:     config.DefaultAPIURL,
+		pubMediaURL: config.DefaultPubMediaURL,
+		userAgent:   s.UserAgent,
+		httpClient:  httpClient,
+		settings:    s,
+	}
+	if s.APIURL != "" {
+		c.baseURL = strings.TrimSuffix(s.APIURL, "/")
+	}
+	if s.PubMediaURL != "" {
+		c.pubMediaURL = s.PubMediaURL
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.pubMedia = pubmedia.NewClient(c.pubMediaURL, pubmedia.WithHTTPClient(c.httpClient), pubmedia.WithUserAgent(c.userAgent))
+	return c
 }
 
-// NewClient creates a new API client.
-func NewClient(s *config.Settings) *Client {
-	return &Client{
-		baseURL: baseURL,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		settings: s,
+// get sends a GET request for reqURL with the configured User-Agent.
+func (c *Client) get(reqURL string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, reqURL, http.NoBody)
+	if err != nil {
+		return nil, err
 	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	// #nosec G704 - URL is built from the configured API endpoint
+	return c.httpClient.Do(req)
 }
 
 // GetLanguages fetches the list of available languages.
 func (c *Client) GetLanguages() ([]Language, error) {
 	reqURL := fmt.Sprintf("%s/languages/E/web?clientType=www", c.baseURL)
-	resp, err := c.httpClient.Get(reqURL)
+	resp, err := c.get(reqURL)
 	if err != nil {
 		return nil, err
 	}
@@ -79,10 +146,10 @@ func (c *Client) GetLanguages() ([]Language, error) {
 	return langResp.Languages, nil
 }
 
-// GetRootCategories fetches all available root categories from the API.
-func (c *Client) GetRootCategories() ([]string, error) {
-	reqURL := fmt.Sprintf("%s/categories/%s/?detailed=1", c.baseURL, c.settings.Lang)
-	resp, err := c.httpClient.Get(reqURL)
+// FetchRootCategories fetches the unfiltered root category listing for lang.
+func (c *Client) FetchRootCategories(lang string) (*RootCategoriesResponse, error) {
+	reqURL := fmt.Sprintf("%s/categories/%s/?detailed=1", c.baseURL, lang)
+	resp, err := c.get(reqURL)
 	if err != nil {
 		return nil, err
 	}
@@ -97,6 +164,16 @@ func (c *Client) GetRootCategories() ([]string, error) {
 		return nil, err
 	}
 
+	return &rootResp, nil
+}
+
+// GetRootCategories fetches all available root categories from the API.
+func (c *Client) GetRootCategories() ([]string, error) {
+	rootResp, err := c.FetchRootCategories(c.settings.Lang)
+	if err != nil {
+		return nil, err
+	}
+
 	// Filter categories that are likely to be user-accessible root categories
 	// We'll use a more sophisticated approach: include categories that either
 	// 1. Don't have major exclude tags, OR
@@ -137,7 +214,7 @@ func (c *Client) GetRootCategories() ([]string, error) {
 // GetCategory fetches a category by its key.
 func (c *Client) GetCategory(lang, key string) (*CategoryResponse, error) {
 	reqURL := fmt.Sprintf("%s/categories/%s/%s?detailed=1", c.baseURL, lang, key)
-	resp, err := c.httpClient.Get(reqURL)
+	resp, err := c.get(reqURL)
 	if err != nil {
 		return nil, err
 	}
@@ -155,134 +232,34 @@ func (c *Client) GetCategory(lang, key string) (*CategoryResponse, error) {
 	return &catResp, nil
 }
 
-// GetBroadcastingMP3s fetches JW Broadcasting MP3s from the Publication Media API.
-// It searches through recent JWB publication issues to find available MP3 files.
-func (c *Client) GetBroadcastingMP3s() ([]*Category, error) {
-	var result []*Category
-	usedFilenames := make(map[string]bool)
-
-	cat := &Category{
-		Key:  "JWBroadcasting",
-		Name: "JW Broadcasting (Audio)",
-		Home: true,
-	}
-
-	// Compute the current JWB issue number. Issues are numbered sequentially by
-	// month starting from October 2014 (issue 1). Going back 36 issues = 3 years.
-	now := time.Now()
-	startIssue := (now.Year()-jwbStartYear)*12 + int(now.Month()) - jwbStartMonth + 1
-	endIssue := startIssue - 36 // Go back 3 years worth of monthly issues
-
-	for issue := startIssue; issue >= endIssue; issue-- {
-		pubCode := fmt.Sprintf("jwb-%d", issue)
-
-		if c.settings.Quiet < 1 {
-			fmt.Fprintf(os.Stderr, "indexing: %s\n", pubCode)
-		}
-
-		files, err := c.fetchPubMediaMP3s(pubCode)
-		if err != nil {
-			if c.settings.Quiet < 2 {
-				fmt.Fprintf(os.Stderr, "could not fetch %s: %v\n", pubCode, err)
-			}
-			continue
-		}
-
-		for _, f := range files {
-			// Skip audio description versions (track >= 100) unless specifically requested
-			// Also skip items with "audio description" in the title (case-insensitive)
-			titleLower := strings.ToLower(f.Title)
-			if f.Track >= 100 || strings.Contains(titleLower, "audio description") {
-				continue
-			}
-
-			media := &Media{
-				URL:      f.File.URL,
-				Name:     f.Title,
-				MD5:      f.File.Checksum,
-				Size:     f.Filesize,
-				Duration: f.Duration,
-			}
-
-			// Parse date from the modified datetime
-			if f.File.ModifiedDatetime != "" {
-				if date, err := parsePubMediaDate(f.File.ModifiedDatetime); err == nil {
-					if date.Unix() < c.settings.MinDate {
-						continue
-					}
-					if c.settings.MaxDate > 0 && date.Unix() > c.settings.MaxDate {
-						continue
-					}
-					media.Date = date.Unix()
-				}
-			}
-
-			media.Filename = getFilename(media.URL, c.settings.SafeFilenames)
-			media.FriendlyName = getFriendlyFilename(media.Name, media.URL, c.settings.SafeFilenames)
-
-			// Ensure unique filenames
-			if c.settings.FriendlyFilenames {
-				media.Filename = makeUniqueFilename(media.FriendlyName, usedFilenames)
-			} else {
-				media.Filename = makeUniqueFilename(media.Filename, usedFilenames)
-			}
-
-			cat.Contents = append(cat.Contents, media)
-		}
-	}
-
-	if len(cat.Contents) > 0 {
-		result = append(result, cat)
-	}
-
-	return result, nil
-}
-
-// fetchPubMediaMP3s fetches MP3 files for a specific publication from the Publication Media API.
-func (c *Client) fetchPubMediaMP3s(pubCode string) ([]PubMediaFile, error) {
-	params := url.Values{}
-	params.Set("output", "json")
-	params.Set("pub", pubCode)
-	params.Set("langwritten", c.settings.Lang)
-	params.Set("alllangs", "0")
-	params.Set("fileformat", "MP3")
-
-	reqURL := pubMediaURL + "?" + params.Encode()
-	resp, err := c.httpClient.Get(reqURL)
+// GetMediaItem fetches a single media item by its (language-agnostic)
+// natural key in the given language.
+func (c *Client) GetMediaItem(lang, key string) (*MediaItemResponse, error) {
+	reqURL := fmt.Sprintf("%s/media-items/%s/%s?clientType=www", c.baseURL, lang, url.PathEscape(key))
+	resp, err := c.get(reqURL)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get publication %s: %s", pubCode, resp.Status)
+		return nil, fmt.Errorf("failed to get media item %s: %s", key, resp.Status)
 	}
 
-	var pubResp PubMediaResponse
-	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&pubResp); err != nil {
+	var itemResp MediaItemResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&itemResp); err != nil {
 		return nil, err
 	}
 
-	// Get MP3 files for the requested language
-	langFiles, ok := pubResp.Files[c.settings.Lang]
-	if !ok {
-		return nil, fmt.Errorf("no files found for language %s", c.settings.Lang)
-	}
-
-	return langFiles.MP3, nil
-}
-
-// parsePubMediaDate parses dates from the Publication Media API format.
-func parsePubMediaDate(dateString string) (time.Time, error) {
-	// Format: "2026-01-18 19:25:59"
-	t, err := time.Parse("2006-01-02 15:04:05", dateString)
-	if err != nil {
-		return time.Time{}, err
-	}
-	return t.UTC(), nil
+	return &itemResp, nil
 }
 
 // ParseBroadcasting is the main function to parse the broadcasting data.
+//
+// The category tree is crawled breadth-first. All categories of one level
+// are fetched concurrently by a bounded pool of workers, but the responses
+// are processed strictly in queue order, so the resulting category order and
+// the unique filenames are the same as for a sequential crawl.
 func (c *Client) ParseBroadcasting() ([]*Category, error) {
 	queue := make([]string, len(c.settings.IncludeCategories))
 	copy(queue, c.settings.IncludeCategories)
@@ -290,144 +267,195 @@ func (c *Client) ParseBroadcasting() ([]*Category, error) {
 	var result []*Category
 
 	processed := make(map[string]bool)
+	// fetched holds every category that was fetched, so subcategory
+	// references can be linked to the full categories afterwards.
+	fetched := make(map[string]*Category)
 
 	// Track used filenames to prevent duplicates
 	usedFilenames := make(map[string]bool)
 	usedSubtitleFilenames := make(map[string]bool)
+	// Category and media images share a directory, so their names are
+	// made unique together
+	usedImageFilenames := make(map[string]bool)
 
 	for len(queue) > 0 {
-		key := queue[0]
-		queue = queue[1:]
-
-		if processed[key] {
-			continue
+		// Take the whole current level; subcategories found while
+		// processing it form the next level.
+		var level []string
+		for _, key := range queue {
+			if processed[key] {
+				continue
+			}
+			processed[key] = true
+			level = append(level, key)
 		}
-		processed[key] = true
+		queue = nil
 
 		if c.settings.Quiet < 1 {
-			fmt.Fprintf(os.Stderr, "indexing: %s\n", key)
-		}
-
-		catResp, err := c.GetCategory(c.settings.Lang, key)
-		if err != nil {
-			// In the Python code, a 404 is not a fatal error, so we just print a message.
-			if c.settings.Quiet < 2 {
-				fmt.Fprintf(os.Stderr, "could not get category %s: %v\n", key, err)
+			for _, key := range level {
+				fmt.Fprintf(os.Stderr, "indexing: %s\n", key)
 			}
-			continue
 		}
 
-		cat := &Category{
-			Key:  catResp.Category.Key,
-			Name: catResp.Category.Name,
-			Home: util.Contains(c.settings.IncludeCategories, catResp.Category.Key),
-		}
-		if !c.settings.Update {
-			result = append(result, cat)
-		}
+		responses := c.fetchCategories(level)
+
+		for i, key := range level {
+			catResp, err := responses[i].resp, responses[i].err
+			if err != nil {
+				// In the Python code, a 404 is not a fatal error, so we just print a message.
+				if c.settings.Quiet < 2 {
+					fmt.Fprintf(os.Stderr, "could not get category %s: %v\n", key, err)
+				}
+				continue
+			}
 
-		for _, sub := range catResp.Category.Subcategories {
-			subCat := &Category{
-				Key:  sub.Key,
-				Name: sub.Name,
+			cat := &Category{
+				Key:      catResp.Category.Key,
+				Name:     catResp.Category.Name,
+				Home:     util.Contains(c.settings.IncludeCategories, catResp.Category.Key),
+				ImageURL: catResp.Category.Images.Best(),
 			}
-			cat.Contents = append(cat.Contents, subCat)
-			if !util.Contains(c.settings.ExcludeCategories, sub.Key) {
-				queue = append(queue, sub.Key)
+			if c.settings.FriendlyFilenames {
+				cat.ImageFilename = getImageFilename(cat.Name, cat.ImageURL, c.settings.SafeFilenames)
+			} else {
+				cat.ImageFilename = getImageFilename(cat.Key, cat.ImageURL, c.settings.SafeFilenames)
+			}
+			cat.ImageFilename = makeUniqueFilename(cat.ImageFilename, usedImageFilenames)
+			fetched[key] = cat
+			if !c.settings.Update {
+				result = append(result, cat)
 			}
-		}
 
-		for _, m := range catResp.Category.Media {
-			if util.Contains(c.settings.FilterCategories, m.PrimaryCategory) {
-				continue
+			for _, sub := range catResp.Category.Subcategories {
+				subCat := &Category{
+					Key:  sub.Key,
+					Name: sub.Name,
+				}
+				cat.Subcategories = append(cat.Subcategories, subCat)
+				if !util.Contains(c.settings.ExcludeCategories, sub.Key) {
+					queue = append(queue, sub.Key)
+				}
 			}
 
-			var bestFile *File
+			for _, m := range catResp.Category.Media {
+				if util.Contains(c.settings.FilterCategories, m.PrimaryCategory) {
+					continue
+				}
+
+				var bestFile *File
 
-			switch {
-			case m.Type == "audio":
-				if len(m.Files) > 0 {
-					bestFile = &m.Files[0]
+				switch {
+				case m.Type == "audio":
+					if len(m.Files) > 0 {
+						bestFile = &m.Files[0]
+					}
+				case c.settings.AudioOnly:
+					// When audio-only mode is enabled, try to find an audio file
+					bestFile = getBestAudio(m.Files)
+					if bestFile == nil {
+						if c.settings.Quiet < 1 {
+							fmt.Fprintf(os.Stderr, "no audio files found for: %s (skipping video-only content)\n", m.Title)
+						}
+						continue
+					}
+				default:
+					bestFile = c.selectVideo(&m)
 				}
-			case c.settings.AudioOnly:
-				// When audio-only mode is enabled, try to find an audio file
-				bestFile = getBestAudio(m.Files)
+
 				if bestFile == nil {
 					if c.settings.Quiet < 1 {
-						fmt.Fprintf(os.Stderr, "no audio files found for: %s (skipping video-only content)\n", m.Title)
+						if len(m.Files) > 0 {
+							fmt.Fprintf(os.Stderr, "no file matches the selection policy for: %s\n", m.Title)
+						} else {
+							fmt.Fprintf(os.Stderr, "no media files found for: %s\n", m.Title)
+						}
 					}
 					continue
 				}
-			default:
-				bestFile = getBestVideo(m.Files, c.settings.Quality, c.settings.HardSubtitles)
-			}
 
-			if bestFile == nil {
-				if c.settings.Quiet < 1 {
-					fmt.Fprintf(os.Stderr, "no media files found for: %s\n", m.Title)
+				media := &Media{
+					URL:         bestFile.ProgressiveDownloadURL,
+					Name:        m.Title,
+					MD5:         bestFile.Checksum,
+					Size:        bestFile.Filesize,
+					Duration:    bestFile.Duration,
+					SubtitleURL: bestFile.Subtitles.URL,
+					Label:       bestFile.Label,
+					Description: m.Description,
+					GUID:        m.GUID,
+					NaturalKey:  m.NaturalKey,
+					Tags:        m.Tags,
+					Images:      m.Images,
+
+					SubtitleLanguages:          m.SubtitleLanguages,
+					LanguageAgnosticNaturalKey: m.LanguageAgnosticNaturalKey,
 				}
-				continue
-			}
 
-			media := &Media{
-				URL:         bestFile.ProgressiveDownloadURL,
-				Name:        m.Title,
-				MD5:         bestFile.Checksum,
-				Size:        bestFile.Filesize,
-				Duration:    bestFile.Duration,
-				SubtitleURL: bestFile.Subtitles.URL,
-			}
-
-			if m.FirstPublished != "" {
-				date, err := parseDate(m.FirstPublished)
-				if err != nil {
-					if c.settings.Quiet < 1 {
-						fmt.Fprintf(os.Stderr, "could not get timestamp on: %s\n", m.Title)
-					}
-				} else {
-					if date.Unix() < c.settings.MinDate {
-						continue
+				if m.FirstPublished != "" {
+					date, err := parseDate(m.FirstPublished)
+					if err != nil {
+						if c.settings.Quiet < 1 {
+							fmt.Fprintf(os.Stderr, "could not get timestamp on: %s\n", m.Title)
+						}
+					} else {
+						if date.Unix() < c.settings.MinDate {
+							continue
+						}
+						if c.settings.MaxDate > 0 && date.Unix() > c.settings.MaxDate {
+							continue
+						}
+						media.Date = date.Unix()
 					}
-					if c.settings.MaxDate > 0 && date.Unix() > c.settings.MaxDate {
-						continue
-					}
-					media.Date = date.Unix()
 				}
-			}
 
-			media.Filename = getFilename(media.URL, c.settings.SafeFilenames)
-			media.FriendlyName = getFriendlyFilename(media.Name, media.URL, c.settings.SafeFilenames)
-			media.SubtitleFilename = getSubtitleFilename(media.SubtitleURL, c.settings.SafeFilenames)
-			media.FriendlySubtitleFilename = getFriendlySubtitleFilename(media.Name, media.SubtitleURL, c.settings.SafeFilenames)
+				media.Filename = getFilename(media.URL, c.settings.SafeFilenames)
+				media.FriendlyName = getFriendlyFilename(media.Name, media.URL, c.settings.SafeFilenames)
+				media.SubtitleFilename = getSubtitleFilename(media.SubtitleURL, c.settings.SafeFilenames)
+				media.FriendlySubtitleFilename = getFriendlySubtitleFilename(media.Name, media.SubtitleURL, c.settings.SafeFilenames)
 
-			// Use friendly filenames if requested and ensure uniqueness
-			if c.settings.FriendlyFilenames {
-				media.Filename = makeUniqueFilename(media.FriendlyName, usedFilenames)
-				media.SubtitleFilename = makeUniqueFilename(media.FriendlySubtitleFilename, usedSubtitleFilenames)
-			} else {
-				// Even for non-friendly filenames, ensure uniqueness to prevent overwrites
-				media.Filename = makeUniqueFilename(media.Filename, usedFilenames)
-				media.SubtitleFilename = makeUniqueFilename(media.SubtitleFilename, usedSubtitleFilenames)
-			}
+				// Use friendly filenames if requested and ensure uniqueness
+				if c.settings.FriendlyFilenames {
+					media.Filename = makeUniqueFilename(media.FriendlyName, usedFilenames)
+					media.SubtitleFilename = makeUniqueFilename(media.FriendlySubtitleFilename, usedSubtitleFilenames)
+				} else {
+					// Even for non-friendly filenames, ensure uniqueness to prevent overwrites
+					media.Filename = makeUniqueFilename(media.Filename, usedFilenames)
+					media.SubtitleFilename = makeUniqueFilename(media.SubtitleFilename, usedSubtitleFilenames)
+				}
 
-			if c.settings.Update {
-				var pcat *Category
-				for _, r := range result {
-					if r.Key == m.PrimaryCategory {
-						pcat = r
-						break
+				// The image is named after the (unique) media filename
+				media.ImageURL = media.Images.Best()
+				media.ImageFilename = makeUniqueFilename(getImageFilename(strings.TrimSuffix(media.Filename, filepath.Ext(media.Filename)), media.ImageURL, false), usedImageFilenames)
+
+				if c.settings.Update {
+					var pcat *Category
+					for _, r := range result {
+						if r.Key == m.PrimaryCategory {
+							pcat = r
+							break
+						}
 					}
-				}
-				if pcat == nil {
-					pcat = &Category{
-						Key:  m.PrimaryCategory,
-						Home: false,
+					if pcat == nil {
+						pcat = &Category{
+							Key:  m.PrimaryCategory,
+							Home: false,
+						}
+						result = append(result, pcat)
 					}
-					result = append(result, pcat)
+					pcat.Media = append(pcat.Media, media)
+				} else {
+					cat.Media = append(cat.Media, media)
 				}
-				pcat.Contents = append(pcat.Contents, media)
-			} else {
-				cat.Contents = append(cat.Contents, media)
+			}
+		}
+	}
+
+	// Replace the subcategory stubs by the fetched categories; excluded or
+	// unavailable subcategories keep their key and name only.
+	for _, cat := range fetched {
+		for i, sub := range cat.Subcategories {
+			if full, ok := fetched[sub.Key]; ok {
+				cat.Subcategories[i] = full
 			}
 		}
 	}
@@ -435,33 +463,79 @@ func (c *Client) ParseBroadcasting() ([]*Category, error) {
 	return result, nil
 }
 
+// categoryResult is the outcome of fetching a single category.
+type categoryResult struct {
+	resp *CategoryResponse
+	err  error
+}
+
+// fetchCategories fetches the given categories concurrently, using at most
+// settings.IndexWorkers simultaneous requests. The results are returned in
+// the same order as keys.
+func (c *Client) fetchCategories(keys []string) []categoryResult {
+	results := make([]categoryResult, len(keys))
+
+	workers := c.settings.IndexWorkers
+	if workers < 1 {
+		workers = defaultIndexWorkers
+	}
+	if workers > len(keys) {
+		workers = len(keys)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				resp, err := c.GetCategory(c.settings.Lang, keys[i])
+				results[i] = categoryResult{resp: resp, err: err}
+			}
+		}()
+	}
+	for i := range keys {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// getBestVideo returns the file PolicyMax selects from files.
 func getBestVideo(files []File, quality int, subtitles bool) *File {
-	var bestFile *File
-	maxRank := -1
+	best, _ := Selection{Policy: PolicyMax, Quality: quality, HardSubtitles: subtitles}.Choose(files)
+	return best
+}
 
-	for i := range files {
-		file := &files[i]
-		rank := 0
-		res, err := strconv.Atoi(strings.TrimSuffix(file.Label, "p"))
-		if err != nil {
-			// Non-numeric label; treat resolution as 0 (lowest priority)
-			res = 0
-		}
-		rank += res / 10
-		if res > 0 && res <= quality {
-			rank += qualityMatchBonus
-		}
-		if file.Subtitled == subtitles {
-			rank += subtitleMatchBonus
-		}
+// selectVideo returns the video file of m chosen by the configured
+// selection policy, explaining the choice with --explain-selection.
+func (c *Client) selectVideo(m *MediaItem) *File {
+	return c.selectFile(m.Title, m.Files)
+}
 
-		if rank > maxRank {
-			maxRank = rank
-			bestFile = file
-		}
+// selectFile returns the file chosen by the configured selection policy
+// among the files of the media item title.
+func (c *Client) selectFile(title string, files []File) *File {
+	policy, err := ParsePolicy(c.settings.Selection)
+	if err != nil {
+		policy = PolicyMax
 	}
-
-	return bestFile
+	sel := Selection{
+		Policy:          policy,
+		Quality:         c.settings.Quality,
+		HardSubtitles:   c.settings.HardSubtitles,
+		NoHardSubtitles: c.settings.NoHardSubtitles,
+	}
+	best, candidates := sel.Choose(files)
+	if c.settings.ExplainSelection {
+		c.explainMu.Lock()
+		WriteExplanation(os.Stderr, title, candidates)
+		c.explainMu.Unlock()
+	}
+	return best
 }
 
 // getBestAudio returns the first audio file from a list of files.
@@ -579,6 +653,24 @@ func getFriendlySubtitleFilename(name, subtitleURL string, safe bool) string {
 	return formatFilename(name+ext, safe)
 }
 
+// imageExtensions lists the image file extensions kept from image URLs.
+var imageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".webp": true, ".gif": true}
+
+// getImageFilename returns the filename for an image named base, using the
+// extension of imageURL (".jpg" when it has no known image extension).
+func getImageFilename(base, imageURL string, safe bool) string {
+	if imageURL == "" || base == "" {
+		return ""
+	}
+	ext := ".jpg"
+	if u, err := url.Parse(imageURL); err == nil {
+		if e := strings.ToLower(path.Ext(u.Path)); imageExtensions[e] {
+			ext = e
+		}
+	}
+	return formatFilename(base+ext, safe)
+}
+
 // makeUniqueFilename ensures filename is unique by appending a number if needed
 func makeUniqueFilename(filename string, usedFilenames map[string]bool) string {
 	if filename == "" {
@@ -599,4 +691,3 @@ func makeUniqueFilename(filename string, usedFilenames map[string]bool) string {
 	usedFilenames[filename] = true
 	return filename
 }
-
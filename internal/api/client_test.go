@@ -1,9 +1,19 @@
 package api
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/darkace1998/jw-scripts/internal/config"
 )
 
 func TestGetBestVideo(t *testing.T) {
@@ -535,3 +545,695 @@ func TestGetBestAudio(t *testing.T) {
 		})
 	}
 }
+
+// newCategoryServer serves a small mediator category tree. Responses for
+// categories listed earlier are delayed longer, so concurrent fetches
+// complete in a different order than they were queued.
+func newCategoryServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	categories := map[string]string{
+		"Root": `{"category":{"key":"Root","name":"Root","subcategories":[{"key":"A","name":"Cat A"},{"key":"B","name":"Cat B"},{"key":"C","name":"Cat C"}],
+			"media":[{"title":"Intro","type":"video","firstPublished":"2024-01-01T00:00:00Z","files":[{"progressiveDownloadURL":"https://cdn.example/intro.mp4","label":"720p"}]}]}}`,
+		"A": `{"category":{"key":"A","name":"Cat A","subcategories":[{"key":"C","name":"Cat C"}],
+			"media":[{"title":"Same","type":"video","files":[{"progressiveDownloadURL":"https://cdn.example/a/same.mp4","label":"720p"}]}]}}`,
+		"B": `{"category":{"key":"B","name":"Cat B","subcategories":[{"key":"D","name":"Cat D"}],
+			"media":[{"title":"Same","type":"video","files":[{"progressiveDownloadURL":"https://cdn.example/b/same.mp4","label":"720p"}]}]}}`,
+		"C": `{"category":{"key":"C","name":"Cat C",
+			"media":[{"title":"Same","type":"video","files":[{"progressiveDownloadURL":"https://cdn.example/c/same.mp4","label":"720p"}]}]}}`,
+		"D": `{"category":{"key":"D","name":"Cat D",
+			"media":[{"title":"Same","type":"video","files":[{"progressiveDownloadURL":"https://cdn.example/d/same.mp4","label":"720p"}]}]}}`,
+	}
+	delays := map[string]time.Duration{"A": 30 * time.Millisecond, "B": 20 * time.Millisecond, "C": 10 * time.Millisecond}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := path.Base(r.URL.Path)
+		body, ok := categories[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		time.Sleep(delays[key])
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestParseBroadcastingConcurrentIsDeterministic(t *testing.T) {
+	server := newCategoryServer(t)
+
+	type entry struct{ category, filename string }
+	crawl := func(workers int) []entry {
+		s := &config.Settings{
+			Lang:              "E",
+			Quiet:             2,
+			Quality:           720,
+			FriendlyFilenames: true,
+			IncludeCategories: []string{"Root", "Missing"},
+			IndexWorkers:      workers,
+		}
+		c := NewClient(s, WithBaseURL(server.URL))
+
+		data, err := c.ParseBroadcasting()
+		if err != nil {
+			t.Fatalf("ParseBroadcasting() returned error: %v", err)
+		}
+		var got []entry
+		for _, cat := range data {
+			got = append(got, entry{category: cat.Key})
+			for _, m := range cat.Media {
+				got = append(got, entry{category: cat.Key, filename: m.Filename})
+			}
+		}
+		return got
+	}
+
+	want := []entry{
+		{category: "Root"},
+		{category: "Root", filename: "Intro.mp4"},
+		{category: "A"},
+		{category: "A", filename: "Same.mp4"},
+		{category: "B"},
+		{category: "B", filename: "Same (1).mp4"},
+		{category: "C"},
+		{category: "C", filename: "Same (2).mp4"},
+		{category: "D"},
+		{category: "D", filename: "Same (3).mp4"},
+	}
+
+	for _, workers := range []int{1, 2, 8} {
+		if got := crawl(workers); !reflect.DeepEqual(got, want) {
+			t.Errorf("workers=%d: got %v, want %v", workers, got, want)
+		}
+	}
+}
+
+// countingTransport counts the requests it passes on to http.DefaultTransport.
+type countingTransport struct{ n int }
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.n++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestClientEndpointConfiguration(t *testing.T) {
+	var userAgents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.Header.Get("User-Agent"))
+		switch {
+		case r.URL.Path == "/mediator/categories/E/Root":
+			_, _ = w.Write([]byte(`{"category":{"key":"Root","name":"Root","type":"container"}}`))
+		case r.URL.Path == "/pub-media" && r.URL.Query().Get("pub") == "iasn":
+			_, _ = w.Write([]byte(`{"pubName":"Songs","files":{"E":{"MP3":[]}}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// Endpoints and User-Agent from the settings
+	s := &config.Settings{
+		Lang:        "E",
+		Quiet:       2,
+		APIURL:      server.URL + "/mediator/",
+		PubMediaURL: server.URL + "/pub-media",
+		UserAgent:   "jwb-test/1.0",
+	}
+	c := NewClient(s)
+	if _, err := c.GetCategory("E", "Root"); err != nil {
+		t.Fatalf("GetCategory() returned error: %v", err)
+	}
+	if _, err := c.fetchPubMedia("iasn", PubMediaMP3); err != nil {
+		t.Fatalf("fetchPubMedia() returned error: %v", err)
+	}
+
+	// Options take precedence over the settings
+	transport := &countingTransport{}
+	c = NewClient(&config.Settings{Lang: "E", Quiet: 2, APIURL: "http://unused.invalid"},
+		WithBaseURL(server.URL+"/mediator"),
+		WithUserAgent("jwb-option/2.0"),
+		WithHTTPClient(&http.Client{Transport: transport}),
+	)
+	if _, err := c.GetCategory("E", "Root"); err != nil {
+		t.Fatalf("GetCategory() with options returned error: %v", err)
+	}
+
+	want := []string{"jwb-test/1.0", "jwb-test/1.0", "jwb-option/2.0"}
+	if !reflect.DeepEqual(userAgents, want) {
+		t.Errorf("got User-Agents %v, want %v", userAgents, want)
+	}
+	if transport.n != 1 {
+		t.Errorf("expected request through the injected client, got %d", transport.n)
+	}
+}
+
+func TestLinkTranslations(t *testing.T) {
+	e1 := &Media{Name: "Song", Filename: "song_E.mp4", LanguageAgnosticNaturalKey: "song"}
+	e2 := &Media{Name: "Song", Filename: "song_E.mp4", LanguageAgnosticNaturalKey: "song"}
+	s := &Media{Name: "Canción", Filename: "song_S.mp4", URL: "https://example.com/song_S.mp4", LanguageAgnosticNaturalKey: "song"}
+	only := &Media{Name: "English only", LanguageAgnosticNaturalKey: "other"}
+	noKey := &Media{Name: "No key"}
+
+	LinkTranslations([]IndexedLanguage{
+		{
+			Settings: &config.Settings{Lang: "E", SubDir: "jwb-E"},
+			Data: []*Category{
+				{Key: "A", Media: []*Media{e1, only, noKey}},
+				{Key: "B", Media: []*Media{e2}},
+			},
+		},
+		{
+			Settings: &config.Settings{Lang: "S", SubDir: "jwb-S"},
+			Data:     []*Category{{Key: "A", Media: []*Media{s}}},
+		},
+	})
+
+	want := []Translation{{Lang: "S", Name: "Canción", URL: "https://example.com/song_S.mp4", SubDir: "jwb-S", Filename: "song_S.mp4"}}
+	for _, m := range []*Media{e1, e2} {
+		if !reflect.DeepEqual(m.Translations, want) {
+			t.Errorf("got %+v, want %+v", m.Translations, want)
+		}
+	}
+	if len(s.Translations) != 1 || s.Translations[0].Lang != "E" || s.Translations[0].SubDir != "jwb-E" {
+		t.Errorf("expected a single reference to English, got %+v", s.Translations)
+	}
+	if only.Translations != nil || noKey.Translations != nil {
+		t.Error("expected media without a counterpart to have no translations")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	// root -> a -> c -> a (cycle), root -> b -> c (duplicate)
+	a := &Category{Key: "a"}
+	b := &Category{Key: "b"}
+	c := &Category{Key: "c", Subcategories: []*Category{a}}
+	a.Subcategories = []*Category{c}
+	b.Subcategories = []*Category{c}
+	root := &Category{Key: "root", Subcategories: []*Category{a, b}}
+
+	var got []string
+	err := Walk(Roots([]*Category{a, b, c, root}), func(v Visit) error {
+		entry := fmt.Sprintf("%d %s/%s", v.Depth, strings.Join(v.Path, "/"), v.Category.Key)
+		switch {
+		case v.Cycle:
+			entry += " cycle"
+		case v.Duplicate:
+			entry += " duplicate"
+		}
+		if v.Parent != nil && v.Parent.Key != v.Path[len(v.Path)-1] {
+			t.Errorf("parent %s does not match path %v", v.Parent.Key, v.Path)
+		}
+		got = append(got, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() returned error: %v", err)
+	}
+
+	want := []string{
+		"0 /root",
+		"1 root/a",
+		"2 root/a/c",
+		"3 root/a/c/a cycle",
+		"1 root/b",
+		"2 root/b/c duplicate",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWalkSkipChildrenAndErrors(t *testing.T) {
+	leaf := &Category{Key: "leaf"}
+	root := &Category{Key: "root", Subcategories: []*Category{{Key: "skipped", Subcategories: []*Category{leaf}}, leaf}}
+
+	var visited []string
+	err := Walk([]*Category{root}, func(v Visit) error {
+		visited = append(visited, v.Category.Key)
+		if v.Category.Key == "skipped" {
+			return ErrSkipChildren
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() returned error: %v", err)
+	}
+	if want := []string{"root", "skipped", "leaf"}; !reflect.DeepEqual(visited, want) {
+		t.Errorf("got %v, want %v", visited, want)
+	}
+
+	stop := errors.New("stop")
+	if err := Walk([]*Category{root}, func(Visit) error { return stop }); !errors.Is(err, stop) {
+		t.Errorf("expected walk to stop with the callback error, got %v", err)
+	}
+}
+
+func TestParseBroadcastingLinksSubcategories(t *testing.T) {
+	server := newCategoryServer(t)
+	s := &config.Settings{Lang: "E", Quiet: 2, Quality: 720, IncludeCategories: []string{"Root"}}
+	data, err := NewClient(s, WithBaseURL(server.URL)).ParseBroadcasting()
+	if err != nil {
+		t.Fatalf("ParseBroadcasting() returned error: %v", err)
+	}
+
+	var paths []string
+	err = Walk(Roots(data), func(v Visit) error {
+		if !v.Duplicate && len(v.Category.Media) == 0 {
+			t.Errorf("category %s was not linked to its fetched contents", v.Category.Key)
+		}
+		paths = append(paths, strings.Join(append(v.Path, v.Category.Key), "/"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Root", "Root/A", "Root/A/C", "Root/B", "Root/B/D", "Root/C"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got %v, want %v", paths, want)
+	}
+}
+
+func TestParseBroadcastingDecodesMediaDetails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"category":{"key":"Root","name":"Root","media":[{
+			"title":"Intro","type":"video","description":"An introduction",
+			"guid":"guid-1","naturalKey":"pub-intro_1_VIDEO","languageAgnosticNaturalKey":"pub-intro_1_VIDEO",
+			"tags":["Family","Children"],
+			"images":{"lsr":{"xl":"https://cdn.example/lsr_xl.jpg","sm":"https://cdn.example/lsr_sm.jpg"},"sqr":{"lg":"https://cdn.example/sqr_lg.jpg"}},
+			"availableSubtitleLanguages":["E","S"],
+			"files":[{"progressiveDownloadURL":"https://cdn.example/intro.mp4","label":"720p"}]}]}}`))
+	}))
+	defer server.Close()
+
+	s := &config.Settings{Lang: "E", Quiet: 2, Quality: 720, IncludeCategories: []string{"Root"}}
+	data, err := NewClient(s, WithBaseURL(server.URL)).ParseBroadcasting()
+	if err != nil {
+		t.Fatalf("ParseBroadcasting() returned error: %v", err)
+	}
+	if len(data) != 1 || len(data[0].Media) != 1 {
+		t.Fatalf("expected a single media item, got %+v", data)
+	}
+
+	m := data[0].Media[0]
+	if m.Description != "An introduction" || m.GUID != "guid-1" || m.NaturalKey != "pub-intro_1_VIDEO" {
+		t.Errorf("unexpected details: %+v", m)
+	}
+	if !reflect.DeepEqual(m.Tags, []string{"Family", "Children"}) || !reflect.DeepEqual(m.SubtitleLanguages, []string{"E", "S"}) {
+		t.Errorf("unexpected tags %v or subtitle languages %v", m.Tags, m.SubtitleLanguages)
+	}
+	if m.Images["lsr"]["xl"] != "https://cdn.example/lsr_xl.jpg" || m.Images["sqr"]["lg"] != "https://cdn.example/sqr_lg.jpg" {
+		t.Errorf("unexpected images: %v", m.Images)
+	}
+	if m.ImageURL != "https://cdn.example/lsr_xl.jpg" || m.ImageFilename != "intro.jpg" {
+		t.Errorf("unexpected best image %q named %q", m.ImageURL, m.ImageFilename)
+	}
+}
+
+func TestParseBroadcastingNamesImagesUniquely(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"category":{"key":"Root","name":"Intro","images":{"lsr":{"xl":"https://cdn.example/cat.jpg"}},"media":[{
+			"title":"Intro","type":"video",
+			"images":{"lsr":{"xl":"https://cdn.example/intro.jpg"}},
+			"files":[{"progressiveDownloadURL":"https://cdn.example/intro.mp4","label":"720p"}]}]}}`))
+	}))
+	defer server.Close()
+
+	s := &config.Settings{Lang: "E", Quiet: 2, Quality: 720, IncludeCategories: []string{"Root"}, FriendlyFilenames: true}
+	data, err := NewClient(s, WithBaseURL(server.URL)).ParseBroadcasting()
+	if err != nil {
+		t.Fatalf("ParseBroadcasting() returned error: %v", err)
+	}
+	if len(data) != 1 || len(data[0].Media) != 1 {
+		t.Fatalf("expected a single media item, got %+v", data)
+	}
+	// The category "Intro" and the media "Intro.mp4" would both be Intro.jpg
+	if cat, media := data[0].ImageFilename, data[0].Media[0].ImageFilename; cat != "Intro.jpg" || media != "Intro (1).jpg" {
+		t.Errorf("got category image %q and media image %q, want Intro.jpg and Intro (1).jpg", cat, media)
+	}
+}
+
+func TestImagesBest(t *testing.T) {
+	testCases := []struct {
+		name   string
+		images Images
+		want   string
+	}{
+		{"empty", nil, ""},
+		{"prefers wide images", Images{"sqr": {"xl": "sqr_xl"}, "lsr": {"sm": "lsr_sm"}}, "lsr_sm"},
+		{"prefers larger sizes", Images{"wss": {"sm": "wss_sm", "lg": "wss_lg", "md": "wss_md"}}, "wss_lg"},
+		{"unknown types and sizes", Images{"zzz": {"b": "zzz_b", "a": "zzz_a"}}, "zzz_a"},
+		{"skips empty URLs", Images{"lsr": {"xl": ""}, "pnr": {"lg": "pnr_lg"}}, "pnr_lg"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.images.Best(); got != tc.want {
+				t.Errorf("Best() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGetImageFilename(t *testing.T) {
+	testCases := []struct {
+		base, url string
+		safe      bool
+		want      string
+	}{
+		{"Intro", "https://cdn.example/a/intro_lsr_xl.jpg", false, "Intro.jpg"},
+		{"Intro", "https://cdn.example/a/intro.PNG?v=2", false, "Intro.png"},
+		{"Intro", "https://cdn.example/a/intro", false, "Intro.jpg"},
+		{"What: Why?", "https://cdn.example/a.webp", true, "What- Why.webp"},
+		{"Intro", "", false, ""},
+	}
+	for _, tc := range testCases {
+		if got := getImageFilename(tc.base, tc.url, tc.safe); got != tc.want {
+			t.Errorf("getImageFilename(%q, %q, %t) = %q, want %q", tc.base, tc.url, tc.safe, got, tc.want)
+		}
+	}
+}
+
+func TestFetchSubtitles(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path)
+		mu.Unlock()
+		// /media-items/<lang>/<key>
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(parts) != 3 || parts[0] != "media-items" {
+			http.NotFound(w, r)
+			return
+		}
+		lang, key := parts[1], parts[2]
+		subtitles := ""
+		if key == "pub-a_1_VIDEO" {
+			subtitles = fmt.Sprintf(`,"subtitles":{"url":"https://cdn.example/a_%s.vtt"}`, lang)
+		}
+		fmt.Fprintf(w, `{"media":[{"title":"A","files":[{"progressiveDownloadURL":"https://cdn.example/%s_%s.mp4","label":"720p"%s}]}]}`, key, lang, subtitles)
+	}))
+	defer server.Close()
+
+	a := &Media{Name: "A", Filename: "a_E.mp4", SubtitleURL: "https://cdn.example/a_E.vtt", LanguageAgnosticNaturalKey: "pub-a_1_VIDEO"}
+	b := &Media{Name: "B", Filename: "b_E.mp4", SubtitleURL: "https://cdn.example/b_E.vtt", NaturalKey: "pub-b_1_VIDEO", SubtitleLanguages: []string{"E", "S"}}
+	data := []*Category{{Key: "Root", Media: []*Media{a, b}}, {Key: "Other", Media: []*Media{a}}}
+
+	s := &config.Settings{Lang: "E", Quiet: 2, Quality: 720, SubtitleLangs: []string{"S", "F"}, SubtitleFallbackLang: "E"}
+	NewClient(s, WithBaseURL(server.URL)).FetchSubtitles(data)
+
+	wantA := []Subtitle{
+		{Lang: "S", URL: "https://cdn.example/a_S.vtt", Filename: "a_E.S.vtt"},
+		{Lang: "F", URL: "https://cdn.example/a_F.vtt", Filename: "a_E.F.vtt"},
+	}
+	if !reflect.DeepEqual(a.Subtitles, wantA) {
+		t.Errorf("got subtitles %+v, want %+v", a.Subtitles, wantA)
+	}
+	// B has no Spanish subtitles and no French ones at all: the English
+	// subtitles are used once instead.
+	wantB := []Subtitle{{Lang: "E", URL: "https://cdn.example/b_E.vtt", Filename: "b_E.E.vtt"}}
+	if !reflect.DeepEqual(b.Subtitles, wantB) {
+		t.Errorf("got subtitles %+v, want %+v", b.Subtitles, wantB)
+	}
+
+	sort.Strings(requests)
+	wantRequests := []string{"/media-items/F/pub-a_1_VIDEO", "/media-items/S/pub-a_1_VIDEO", "/media-items/S/pub-b_1_VIDEO"}
+	if !reflect.DeepEqual(requests, wantRequests) {
+		t.Errorf("got requests %v, want %v", requests, wantRequests)
+	}
+}
+
+func TestSelectionPolicies(t *testing.T) {
+	files := []File{
+		{ProgressiveDownloadURL: "240p.mp4", Label: "240p", Filesize: 100},
+		{ProgressiveDownloadURL: "480p.mp4", Label: "480p", Filesize: 300},
+		{ProgressiveDownloadURL: "480p_sub.mp4", Label: "480p", Filesize: 250, Subtitled: true},
+		{ProgressiveDownloadURL: "720p.mp4", Label: "720p", Filesize: 500},
+		{ProgressiveDownloadURL: "1080p.mp4", Label: "1080p", Filesize: 900},
+	}
+	testCases := []struct {
+		name string
+		sel  Selection
+		want string
+	}{
+		{"max", Selection{Policy: PolicyMax, Quality: 720}, "720p.mp4"},
+		{"max between labels", Selection{Policy: PolicyMax, Quality: 600}, "480p.mp4"},
+		{"max falls back to highest", Selection{Policy: PolicyMax, Quality: 144}, "1080p.mp4"},
+		{"exact", Selection{Policy: PolicyExact, Quality: 480}, "480p.mp4"},
+		{"exact with hard subtitles", Selection{Policy: PolicyExact, Quality: 480, HardSubtitles: true}, "480p_sub.mp4"},
+		{"exact or skip", Selection{Policy: PolicyExact, Quality: 360}, ""},
+		{"min", Selection{Policy: PolicyMin, Quality: 600}, "720p.mp4"},
+		{"min falls back to highest", Selection{Policy: PolicyMin, Quality: 2160}, "1080p.mp4"},
+		{"nearest below", Selection{Policy: PolicyNearest, Quality: 400}, "480p.mp4"},
+		{"nearest prefers higher on a tie", Selection{Policy: PolicyNearest, Quality: 600}, "720p.mp4"},
+		{"smallest", Selection{Policy: PolicySmallest, Quality: 1080}, "240p.mp4"},
+		{"smallest with hard subtitles", Selection{Policy: PolicySmallest, Quality: 720, HardSubtitles: true}, "480p_sub.mp4"},
+		{"largest", Selection{Policy: PolicyLargest, Quality: 720}, "720p.mp4"},
+		{"empty policy is max", Selection{Quality: 480}, "480p.mp4"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, candidates := tc.sel.Choose(files)
+			gotURL := ""
+			if got != nil {
+				gotURL = got.ProgressiveDownloadURL
+			}
+			if gotURL != tc.want {
+				t.Errorf("Choose() = %q, want %q", gotURL, tc.want)
+			}
+			if len(candidates) != len(files) {
+				t.Fatalf("expected a verdict for every file, got %d", len(candidates))
+			}
+			for _, c := range candidates {
+				if c.Reason == "" || c.Chosen != (c.File == got) {
+					t.Errorf("unexpected verdict %+v", c)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectionNeverHardSubtitles(t *testing.T) {
+	files := []File{
+		{ProgressiveDownloadURL: "720p_sub.mp4", Label: "720p", Subtitled: true},
+		{ProgressiveDownloadURL: "480p.mp4", Label: "480p"},
+	}
+	got, candidates := Selection{Policy: PolicyExact, Quality: 720, NoHardSubtitles: true}.Choose(files)
+	if got != nil {
+		t.Errorf("expected no file, got %+v", got)
+	}
+	if candidates[0].Reason != "has hard-coded subtitles" || candidates[1].Reason != "not 720p" {
+		t.Errorf("unexpected verdicts %+v", candidates)
+	}
+
+	got, _ = Selection{Policy: PolicyMax, Quality: 720, NoHardSubtitles: true}.Choose(files)
+	if got == nil || got.ProgressiveDownloadURL != "480p.mp4" {
+		t.Errorf("expected the file without subtitles, got %+v", got)
+	}
+}
+
+func TestWriteExplanation(t *testing.T) {
+	files := []File{
+		{ProgressiveDownloadURL: "https://cdn.example/a_1080p.mp4", Label: "1080p", Filesize: 3 << 20},
+		{ProgressiveDownloadURL: "https://cdn.example/a_720p.mp4", Label: "720p"},
+	}
+	_, candidates := Selection{Policy: PolicyMax, Quality: 720}.Choose(files)
+	var b strings.Builder
+	WriteExplanation(&b, "Intro", candidates)
+	want := "Intro:\n" +
+		"    1080p  3.0 MB     https://cdn.example/a_1080p.mp4: not at or under 720p\n" +
+		"  * 720p   -          https://cdn.example/a_720p.mp4: highest resolution at or under 720p\n"
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	if p, err := ParsePolicy("Nearest"); err != nil || p != PolicyNearest {
+		t.Errorf("ParsePolicy(Nearest) = %q, %v", p, err)
+	}
+	if _, err := ParsePolicy("best"); !errors.Is(err, ErrUnknownPolicy) {
+		t.Errorf("ParsePolicy(best) error = %v, want ErrUnknownPolicy", err)
+	}
+}
+
+func TestParseIssue(t *testing.T) {
+	testCases := []struct {
+		in   string
+		want int
+	}{
+		{"1", 1},
+		{"120", 120},
+		{"2014-10", 1},
+		{"2024-09", 120},
+	}
+	for _, tc := range testCases {
+		if got, err := ParseIssue(tc.in); err != nil || got != tc.want {
+			t.Errorf("ParseIssue(%q) = %d, %v, want %d", tc.in, got, err, tc.want)
+		}
+	}
+	for _, in := range []string{"", "0", "2014-09", "2024-9", "2024-13", "last"} {
+		if _, err := ParseIssue(in); !errors.Is(err, ErrInvalidIssue) {
+			t.Errorf("ParseIssue(%q) error = %v, want ErrInvalidIssue", in, err)
+		}
+	}
+	if got := Issue(time.Date(2026, time.January, 18, 0, 0, 0, 0, time.UTC)); got != 136 {
+		t.Errorf("Issue(2026-01) = %d, want 136", got)
+	}
+}
+
+func TestIssueRange(t *testing.T) {
+	current := Issue(time.Now())
+	testCases := []struct {
+		from, to    string
+		first, last int
+	}{
+		{"", "", current - 36, current},
+		{"", "40", 4, 40},
+		{"", "10", 1, 10},
+		{"2024-01", "2024-03", 112, 114},
+		{"130", "", 130, current},
+	}
+	for _, tc := range testCases {
+		c := NewClient(&config.Settings{JWBFrom: tc.from, JWBTo: tc.to})
+		first, last, err := c.IssueRange()
+		if err != nil || first != tc.first || last != tc.last {
+			t.Errorf("IssueRange(%q, %q) = %d, %d, %v, want %d, %d", tc.from, tc.to, first, last, err, tc.first, tc.last)
+		}
+	}
+	c := NewClient(&config.Settings{JWBFrom: "50", JWBTo: "40"})
+	if _, _, err := c.IssueRange(); !errors.Is(err, ErrInvalidIssue) {
+		t.Errorf("IssueRange(50, 40) error = %v, want ErrInvalidIssue", err)
+	}
+}
+
+// newPubMediaServer serves the JW Broadcasting issues 110 to 112 of the
+// Publication Media API; issue 111 is missing.
+func newPubMediaServer(t *testing.T, requests *[]string) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pub := r.URL.Query().Get("pub")
+		mu.Lock()
+		*requests = append(*requests, pub)
+		mu.Unlock()
+		if pub != "jwb-110" && pub != "jwb-112" {
+			http.NotFound(w, r)
+			return
+		}
+		file := func(track int, title, label string, subtitled bool) string {
+			return fmt.Sprintf(`{"title":%q,"track":%d,"label":%q,"subtitled":%t,"filesize":%d,
+				"file":{"url":"https://example.com/%s_%d_%s.mp4","modifiedDatetime":"2024-01-02 03:04:05","checksum":"abc"}}`,
+				title, track, label, subtitled, 1000+track, pub, track, label)
+		}
+		_, _ = fmt.Fprintf(w, `{"pubName":"JW Broadcasting","files":{"E":{
+			"MP3":[{"title":"Program","track":1,"filesize":10,"file":{"url":"https://example.com/%[1]s.mp3"}},
+			       {"title":"Program (Audio Description)","track":101,"file":{"url":"https://example.com/%[1]s_ad.mp3"}},
+			       {"title":"Song","track":102,"file":{"url":"https://example.com/%[1]s_song.mp3"}}],
+			"MP4":[%s,%s,%s,%s,%s]}}}`, pub,
+			file(1, "Program", "240p", false), file(1, "Program", "720p", false), file(1, "Program", "480p", false),
+			file(1, "Program", "480p", true), file(2, "Music Video", "360p", false))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGetBroadcastingPrograms(t *testing.T) {
+	var requests []string
+	server := newPubMediaServer(t, &requests)
+	s := &config.Settings{
+		Lang:         "E",
+		Quiet:        2,
+		Quality:      480,
+		JWBFrom:      "110",
+		JWBTo:        "2024-04",
+		IndexWorkers: 3,
+		PubMediaURL:  server.URL,
+	}
+	c := NewClient(s)
+
+	data, err := c.GetBroadcastingPrograms(PubMediaMP4)
+	if err != nil {
+		t.Fatalf("GetBroadcastingPrograms() returned error: %v", err)
+	}
+	sort.Strings(requests)
+	if want := []string{"jwb-110", "jwb-111", "jwb-112", "jwb-113", "jwb-114", "jwb-115"}; !reflect.DeepEqual(requests, want) {
+		t.Errorf("requested %v, want %v", requests, want)
+	}
+	if len(data) != 1 || data[0].Name != "JW Broadcasting (Video)" {
+		t.Fatalf("got categories %+v, want one video category", data)
+	}
+	var got []string
+	for _, m := range data[0].Media {
+		got = append(got, m.Filename+" "+m.Label)
+	}
+	want := []string{
+		"jwb-112_1_480p.mp4 480p",
+		"jwb-112_2_360p.mp4 360p",
+		"jwb-110_1_480p.mp4 480p",
+		"jwb-110_2_360p.mp4 360p",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got media %v, want %v", got, want)
+	}
+
+	data, err = c.GetBroadcastingMP3s()
+	if err != nil {
+		t.Fatalf("GetBroadcastingMP3s() returned error: %v", err)
+	}
+	got = nil
+	for _, m := range data[0].Media {
+		got = append(got, m.Filename)
+	}
+	if want := []string{"jwb-112.mp3", "jwb-110.mp3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got MP3s %v, want %v", got, want)
+	}
+}
+
+func TestParsePubMediaFormat(t *testing.T) {
+	if f, err := ParsePubMediaFormat("mp4"); err != nil || f != PubMediaMP4 {
+		t.Errorf("ParsePubMediaFormat(mp4) = %q, %v", f, err)
+	}
+	if f, err := ParsePubMediaFormat(""); err != nil || f != PubMediaMP3 {
+		t.Errorf("ParsePubMediaFormat(\"\") = %q, %v", f, err)
+	}
+	if _, err := ParsePubMediaFormat("ogg"); !errors.Is(err, ErrUnknownPubMediaFormat) {
+		t.Errorf("ParsePubMediaFormat(ogg) error = %v, want ErrUnknownPubMediaFormat", err)
+	}
+}
+
+func TestGetBroadcastingAudioDescriptions(t *testing.T) {
+	var requests []string
+	server := newPubMediaServer(t, &requests)
+	s := &config.Settings{Lang: "E", Quiet: 2, JWBFrom: "112", JWBTo: "112", PubMediaURL: server.URL}
+
+	data, err := NewClient(s).GetBroadcastingMP3s()
+	if err != nil {
+		t.Fatalf("GetBroadcastingMP3s() returned error: %v", err)
+	}
+	if len(data) != 1 || data[0].Key != JWBroadcastingKey || len(data[0].Media) != 1 {
+		t.Fatalf("without --audio-descriptions got %+v, want the program only", data)
+	}
+
+	s.AudioDescriptions = true
+	data, err = NewClient(s).GetBroadcastingMP3s()
+	if err != nil {
+		t.Fatalf("GetBroadcastingMP3s() returned error: %v", err)
+	}
+	if len(data) != 2 || data[1].Key != AudioDescriptionKey || !data[1].Home {
+		t.Fatalf("got categories %+v, want the program and audio description categories", data)
+	}
+	var got []string
+	for _, m := range data[1].Media {
+		got = append(got, m.Filename+" | "+m.Name)
+	}
+	want := []string{
+		"jwb-112_ad.mp3 | Program (Audio Description)",
+		"jwb-112_song_AD.mp3 | Song (Audio Description)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got audio descriptions %v, want %v", got, want)
+	}
+	if got := data[0].Media[0].Filename; got != "jwb-112.mp3" {
+		t.Errorf("got program filename %q, want jwb-112.mp3", got)
+	}
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Policy decides which video file of a media item is downloaded.
+type Policy string
+
+// Selection policies. Resolutions are compared with the --quality limit.
+const (
+	// PolicyMax picks the highest resolution at or under the limit, or the
+	// highest available when every file is above it.
+	PolicyMax Policy = "max"
+	// PolicyExact picks a file with exactly the limit's resolution and
+	// skips the media item when there is none.
+	PolicyExact Policy = "exact"
+	// PolicyMin picks the lowest resolution at or above the limit, or the
+	// highest available when every file is below it.
+	PolicyMin Policy = "min"
+	// PolicyNearest picks the resolution closest to the limit, preferring
+	// the higher one on a tie.
+	PolicyNearest Policy = "nearest"
+	// PolicySmallest and PolicyLargest pick the smallest or largest file
+	// at or under the limit (or of all files when every one is above it).
+	PolicySmallest Policy = "smallest"
+	PolicyLargest  Policy = "largest"
+)
+
+// ErrUnknownPolicy is returned for selection policies other than the ones
+// defined above.
+var ErrUnknownPolicy = errors.New("unknown selection policy")
+
+// ParsePolicy returns the policy named by s; an empty string is PolicyMax.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(strings.ToLower(s)); p {
+	case "":
+		return PolicyMax, nil
+	case PolicyMax, PolicyExact, PolicyMin, PolicyNearest, PolicySmallest, PolicyLargest:
+		return p, nil
+	default:
+		return "", fmt.Errorf("%w %q (expected max, exact, min, nearest, smallest or largest)", ErrUnknownPolicy, s)
+	}
+}
+
+// Selection chooses one video file among the files of a media item.
+//
+// Files are narrowed down in stages: files with hard-coded subtitles are
+// dropped when NoHardSubtitles is set, then the policy keeps the files
+// within its resolution range, then the files whose subtitle state matches
+// HardSubtitles are preferred, and the policy finally ranks what is left.
+// Ties go to the file listed first by the API.
+type Selection struct {
+	Policy  Policy
+	Quality int
+	// HardSubtitles prefers files with hard-coded subtitles; without it
+	// files without them are preferred.
+	HardSubtitles bool
+	// NoHardSubtitles never selects files with hard-coded subtitles.
+	NoHardSubtitles bool
+}
+
+// Candidate is the verdict of a Selection on one file.
+type Candidate struct {
+	File   *File
+	Chosen bool
+	// Reason explains why the file was chosen or passed over.
+	Reason string
+}
+
+// Choose returns the selected file, or nil when no file qualifies, and a
+// verdict for every file in the order given.
+func (s Selection) Choose(files []File) (*File, []Candidate) {
+	candidates := make([]Candidate, len(files))
+	remaining := make([]int, 0, len(files))
+	for i := range files {
+		candidates[i].File = &files[i]
+		remaining = append(remaining, i)
+	}
+	// narrow keeps the remaining files accepted by keep and records reason
+	// for the others. With fallback, nothing is dropped when no file is
+	// accepted.
+	narrow := func(keep func(f *File) bool, reason string, fallback bool) {
+		var kept []int
+		for _, i := range remaining {
+			if keep(&files[i]) {
+				kept = append(kept, i)
+			}
+		}
+		if len(kept) == 0 && fallback {
+			return
+		}
+		for _, i := range remaining {
+			if !keep(&files[i]) {
+				candidates[i].Reason = reason
+			}
+		}
+		remaining = kept
+	}
+
+	if s.NoHardSubtitles {
+		narrow(func(f *File) bool { return !f.Subtitled }, "has hard-coded subtitles", false)
+	}
+
+	policy := s.Policy
+	if policy == "" {
+		policy = PolicyMax
+	}
+	q := s.Quality
+	switch policy {
+	case PolicyExact:
+		narrow(func(f *File) bool { return resolution(f) == q }, fmt.Sprintf("not %dp", q), false)
+	case PolicyMax, PolicySmallest, PolicyLargest:
+		narrow(func(f *File) bool { r := resolution(f); return r > 0 && r <= q }, fmt.Sprintf("not at or under %dp", q), true)
+	case PolicyMin:
+		narrow(func(f *File) bool { return resolution(f) >= q }, fmt.Sprintf("below %dp", q), true)
+	}
+
+	if s.HardSubtitles {
+		narrow(func(f *File) bool { return f.Subtitled }, "no hard-coded subtitles", true)
+	} else if !s.NoHardSubtitles {
+		narrow(func(f *File) bool { return !f.Subtitled }, "has hard-coded subtitles", true)
+	}
+
+	if len(remaining) == 0 {
+		return nil, candidates
+	}
+
+	// better reports whether a ranks before b, and why b lost.
+	better := func(a, b *File) (bool, string) {
+		ra, rb := resolution(a), resolution(b)
+		switch policy {
+		case PolicyNearest:
+			da, db := abs(ra-q), abs(rb-q)
+			if da != db {
+				return da < db, fmt.Sprintf("farther from %dp", q)
+			}
+		case PolicySmallest:
+			if a.Filesize != b.Filesize && a.Filesize > 0 && b.Filesize > 0 {
+				return a.Filesize < b.Filesize, "larger file"
+			}
+			if ra != rb {
+				return ra < rb, "higher resolution"
+			}
+			return false, ""
+		case PolicyLargest:
+			if a.Filesize != b.Filesize {
+				return a.Filesize > b.Filesize, "smaller file"
+			}
+		case PolicyMin:
+			if ra >= q && rb >= q && ra != rb {
+				return ra < rb, "higher resolution"
+			}
+		}
+		if ra != rb {
+			return ra > rb, "lower resolution"
+		}
+		return false, ""
+	}
+
+	best := remaining[0]
+	for _, i := range remaining[1:] {
+		if ok, _ := better(&files[i], &files[best]); ok {
+			best = i
+		}
+	}
+	for _, i := range remaining {
+		if i == best {
+			continue
+		}
+		if _, reason := better(&files[best], &files[i]); reason != "" {
+			candidates[i].Reason = reason
+		} else {
+			candidates[i].Reason = "ranked equal, listed later"
+		}
+	}
+	candidates[best].Chosen = true
+	candidates[best].Reason = s.describe(policy, &files[best])
+	return &files[best], candidates
+}
+
+// describe explains why f was chosen under policy.
+func (s Selection) describe(policy Policy, f *File) string {
+	q, r := s.Quality, resolution(f)
+	var reason string
+	switch policy {
+	case PolicyExact:
+		reason = fmt.Sprintf("exactly %dp", q)
+	case PolicyMax:
+		if r > 0 && r <= q {
+			reason = fmt.Sprintf("highest resolution at or under %dp", q)
+		} else {
+			reason = fmt.Sprintf("highest resolution, none at or under %dp", q)
+		}
+	case PolicyMin:
+		if r >= q {
+			reason = fmt.Sprintf("lowest resolution at or above %dp", q)
+		} else {
+			reason = fmt.Sprintf("highest resolution, none at or above %dp", q)
+		}
+	case PolicyNearest:
+		reason = fmt.Sprintf("nearest resolution to %dp", q)
+	case PolicySmallest:
+		reason = "smallest file"
+	case PolicyLargest:
+		reason = "largest file"
+	}
+	if f.Subtitled {
+		reason += ", hard-coded subtitles"
+	}
+	return reason
+}
+
+// WriteExplanation writes the verdicts of a selection for the media item
+// title to w, one file per line with the chosen file marked.
+func WriteExplanation(w io.Writer, title string, candidates []Candidate) {
+	fmt.Fprintf(w, "%s:\n", title)
+	if len(candidates) == 0 {
+		fmt.Fprintln(w, "    no files")
+	}
+	for _, c := range candidates {
+		marker := " "
+		if c.Chosen {
+			marker = "*"
+		}
+		label := c.File.Label
+		if label == "" {
+			label = "?"
+		}
+		fmt.Fprintf(w, "  %s %-6s %-10s %s: %s\n", marker, label, formatSize(c.File.Filesize), c.File.ProgressiveDownloadURL, c.Reason)
+	}
+}
+
+// resolution returns the vertical resolution of f.
+func resolution(f *File) int {
+	return Resolution(f.Label)
+}
+
+// Resolution returns the vertical resolution of a file label ("720p"), or
+// 0 when the label is not numeric.
+func Resolution(label string) int {
+	res, err := strconv.Atoi(strings.TrimSuffix(label, "p"))
+	if err != nil {
+		return 0
+	}
+	return res
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// formatSize formats a file size in MB, or "-" when it is unknown.
+func formatSize(size int64) string {
+	if size <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+}
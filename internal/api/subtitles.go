@@ -0,0 +1,187 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// subtitleLookup is a request for the subtitles of one media item in one
+// language.
+type subtitleLookup struct {
+	media *Media
+	lang  string
+	url   string
+}
+
+// FetchSubtitles looks up the subtitles of every media item in data in each
+// language of settings.SubtitleLangs and stores them in Media.Subtitles,
+// named after the media file with a language suffix ("video.S.vtt").
+//
+// Subtitles in the indexing language come with the indexed file; other
+// languages are looked up through the media-item endpoint by the item's
+// natural key, with at most settings.IndexWorkers requests at a time.
+// Languages the API lists as unavailable are not requested. When a
+// language has no subtitles and settings.SubtitleFallbackLang is set, the
+// subtitles in the fallback language are added instead.
+func (c *Client) FetchSubtitles(data []*Category) {
+	var mediaList []*Media
+	seen := make(map[*Media]bool)
+	for _, cat := range data {
+		for _, m := range cat.Media {
+			if !seen[m] && m.Filename != "" {
+				seen[m] = true
+				mediaList = append(mediaList, m)
+			}
+		}
+	}
+
+	var lookups []*subtitleLookup
+	for _, m := range mediaList {
+		for _, lang := range c.settings.SubtitleLangs {
+			lookups = append(lookups, &subtitleLookup{media: m, lang: lang})
+		}
+	}
+	c.lookupSubtitles(lookups)
+
+	found := make(map[*Media]map[string]string)
+	for _, l := range lookups {
+		if l.url != "" {
+			if found[l.media] == nil {
+				found[l.media] = make(map[string]string)
+			}
+			found[l.media][l.lang] = l.url
+		}
+	}
+
+	// Fall back for media missing any of the requested languages.
+	fallback := c.settings.SubtitleFallbackLang
+	var fallbacks []*subtitleLookup
+	if fallback != "" {
+		for _, m := range mediaList {
+			if _, ok := found[m][fallback]; ok {
+				continue
+			}
+			if slices.ContainsFunc(c.settings.SubtitleLangs, func(lang string) bool { return found[m][lang] == "" }) {
+				fallbacks = append(fallbacks, &subtitleLookup{media: m, lang: fallback})
+			}
+		}
+		c.lookupSubtitles(fallbacks)
+	}
+	fallbackURL := make(map[*Media]string)
+	for _, l := range fallbacks {
+		fallbackURL[l.media] = l.url
+	}
+
+	for _, m := range mediaList {
+		m.Subtitles = nil
+		usedFallback := false
+		for _, lang := range c.settings.SubtitleLangs {
+			if u := found[m][lang]; u != "" {
+				m.addSubtitle(lang, u)
+				continue
+			}
+			if u := fallbackURL[m]; u != "" {
+				if !usedFallback {
+					m.addSubtitle(fallback, u)
+					usedFallback = true
+				}
+				if c.settings.Quiet < 1 {
+					fmt.Fprintf(os.Stderr, "no %s subtitles for: %s (using %s)\n", lang, m.Name, fallback)
+				}
+			} else if c.settings.Quiet < 1 {
+				fmt.Fprintf(os.Stderr, "no %s subtitles for: %s\n", lang, m.Name)
+			}
+		}
+	}
+}
+
+// addSubtitle adds the subtitle in lang unless it is already present.
+func (m *Media) addSubtitle(lang, subtitleURL string) {
+	if slices.ContainsFunc(m.Subtitles, func(s Subtitle) bool { return s.Lang == lang }) {
+		return
+	}
+	base := strings.TrimSuffix(m.Filename, filepath.Ext(m.Filename))
+	m.Subtitles = append(m.Subtitles, Subtitle{
+		Lang:     lang,
+		URL:      subtitleURL,
+		Filename: formatFilename(base+"."+lang+".vtt", false),
+	})
+}
+
+// lookupSubtitles fills in the URL of every lookup that has subtitles,
+// fetching media items concurrently.
+func (c *Client) lookupSubtitles(lookups []*subtitleLookup) {
+	var pending []*subtitleLookup
+	for _, l := range lookups {
+		switch {
+		case l.lang == c.settings.Lang:
+			l.url = l.media.SubtitleURL
+		case l.media.LanguageAgnosticNaturalKey == "" && l.media.NaturalKey == "":
+			// Without a key the item cannot be looked up in other languages
+		case len(l.media.SubtitleLanguages) > 0 && !slices.Contains(l.media.SubtitleLanguages, l.lang):
+			// The API says there are no subtitles in this language
+		default:
+			pending = append(pending, l)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	workers := c.settings.IndexWorkers
+	if workers < 1 {
+		workers = defaultIndexWorkers
+	}
+	workers = min(workers, len(pending))
+
+	jobs := make(chan *subtitleLookup)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for l := range jobs {
+				l.url = c.subtitleURL(l.media, l.lang)
+			}
+		}()
+	}
+	for _, l := range pending {
+		jobs <- l
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// subtitleURL returns the URL of the subtitles of media in lang, or "" when
+// the item or its subtitles are not available in that language.
+func (c *Client) subtitleURL(media *Media, lang string) string {
+	key := media.LanguageAgnosticNaturalKey
+	if key == "" {
+		key = media.NaturalKey
+	}
+	resp, err := c.GetMediaItem(lang, key)
+	if err != nil {
+		if c.settings.Quiet < 1 {
+			fmt.Fprintf(os.Stderr, "could not look up %s in language %s: %v\n", media.Name, lang, err)
+		}
+		return ""
+	}
+	if len(resp.Media) == 0 {
+		return ""
+	}
+
+	files := resp.Media[0].Files
+	if best := getBestVideo(files, c.settings.Quality, c.settings.HardSubtitles); best != nil && best.Subtitles.URL != "" {
+		return best.Subtitles.URL
+	}
+	for _, f := range files {
+		if f.Subtitles.URL != "" {
+			return f.Subtitles.URL
+		}
+	}
+	return ""
+}
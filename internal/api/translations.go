@@ -0,0 +1,64 @@
+package api
+
+import "github.com/darkace1998/jw-scripts/internal/config"
+
+// IndexedLanguage is the indexed data of one language in a multi-language
+// run. Settings carries the language code and its subdirectory.
+type IndexedLanguage struct {
+	Settings *config.Settings
+	Data     []*Category
+}
+
+// LinkTranslations cross-references media that share the same
+// language-agnostic natural key across the given languages: every media item
+// gets a Translation entry for each other language in which the item was
+// indexed. Media without a natural key are left alone.
+func LinkTranslations(langs []IndexedLanguage) {
+	type occurrence struct {
+		lang   string
+		subDir string
+		media  *Media
+	}
+	byKey := make(map[string][]occurrence)
+	var keys []string
+
+	for _, l := range langs {
+		seen := make(map[*Media]bool)
+		for _, cat := range l.Data {
+			for _, m := range cat.Media {
+				if m.LanguageAgnosticNaturalKey == "" || seen[m] {
+					continue
+				}
+				seen[m] = true
+				if _, ok := byKey[m.LanguageAgnosticNaturalKey]; !ok {
+					keys = append(keys, m.LanguageAgnosticNaturalKey)
+				}
+				byKey[m.LanguageAgnosticNaturalKey] = append(byKey[m.LanguageAgnosticNaturalKey],
+					occurrence{lang: l.Settings.Lang, subDir: l.Settings.SubDir, media: m})
+			}
+		}
+	}
+
+	for _, key := range keys {
+		occurrences := byKey[key]
+		for _, o := range occurrences {
+			o.media.Translations = nil
+			linked := make(map[string]bool)
+			for _, other := range occurrences {
+				// The same item can appear in several categories of one
+				// language; only the first occurrence is referenced.
+				if other.lang == o.lang || linked[other.lang] {
+					continue
+				}
+				linked[other.lang] = true
+				o.media.Translations = append(o.media.Translations, Translation{
+					Lang:     other.lang,
+					Name:     other.media.Name,
+					URL:      other.media.URL,
+					SubDir:   other.subDir,
+					Filename: other.media.Filename,
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import "errors"
+
+// ErrSkipChildren can be returned by a WalkFunc to skip the subcategories
+// of the visited category. It is not returned as an error by Walk.
+var ErrSkipChildren = errors.New("skip children")
+
+// Visit describes a category reached by Walk.
+type Visit struct {
+	Category *Category
+	// Parent is the category through which this one was reached; nil for
+	// roots.
+	Parent *Category
+	// Depth is 0 for roots, 1 for their subcategories and so on.
+	Depth int
+	// Path holds the keys of the ancestors, root first. It does not include
+	// the visited category itself.
+	Path []string
+	// Duplicate is set when the category was already visited through
+	// another parent. Its subcategories are not visited again.
+	Duplicate bool
+	// Cycle is set when the category is one of its own ancestors. Walk
+	// never descends into it.
+	Cycle bool
+}
+
+// WalkFunc is called by Walk for every category reached. Returning
+// ErrSkipChildren skips the subcategories of the visited category; any other
+// error stops the walk and is returned by Walk.
+type WalkFunc func(v Visit) error
+
+// Walk traverses the category trees below roots depth-first, in order,
+// calling fn for every category. Categories are identified by their key
+// (or by identity when the key is empty). A category reachable through
+// several parents is reported once per parent but only descended into on
+// its first visit; later visits have Duplicate set. A subcategory that is
+// also one of its ancestors is reported with Cycle set and not followed, so
+// Walk terminates on any input.
+func Walk(roots []*Category, fn WalkFunc) error {
+	w := &walker{fn: fn, visited: make(map[any]bool), onPath: make(map[any]bool)}
+	for _, root := range roots {
+		if err := w.walk(root, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Roots returns the categories of data that are not a subcategory of any
+// other category in data, in their original order. When every category is
+// some other category's child (the data is one big cycle), the first
+// category is returned so a walk still reaches everything.
+func Roots(data []*Category) []*Category {
+	children := make(map[any]bool)
+	for _, cat := range data {
+		for _, sub := range cat.Subcategories {
+			children[categoryID(sub)] = true
+		}
+	}
+
+	var roots []*Category
+	for _, cat := range data {
+		if !children[categoryID(cat)] {
+			roots = append(roots, cat)
+		}
+	}
+	if len(roots) == 0 && len(data) > 0 {
+		roots = data[:1]
+	}
+	return roots
+}
+
+// walker holds the state of a single Walk.
+type walker struct {
+	fn      WalkFunc
+	visited map[any]bool
+	onPath  map[any]bool
+}
+
+func (w *walker) walk(cat, parent *Category, path []string) error {
+	id := categoryID(cat)
+	v := Visit{
+		Category:  cat,
+		Parent:    parent,
+		Depth:     len(path),
+		Path:      path,
+		Cycle:     w.onPath[id],
+		Duplicate: !w.onPath[id] && w.visited[id],
+	}
+	err := w.fn(v)
+	if err != nil && !errors.Is(err, ErrSkipChildren) {
+		return err
+	}
+	if v.Cycle || v.Duplicate {
+		return nil
+	}
+	w.visited[id] = true
+	if err != nil {
+		return nil
+	}
+
+	w.onPath[id] = true
+	defer delete(w.onPath, id)
+
+	// Children get their own copy of the path, so callers may keep it.
+	childPath := make([]string, len(path)+1)
+	copy(childPath, path)
+	childPath[len(path)] = cat.Key
+	for _, sub := range cat.Subcategories {
+		if err := w.walk(sub, cat, childPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// categoryID returns the identity used to detect duplicates and cycles.
+func categoryID(cat *Category) any {
+	if cat.Key != "" {
+		return cat.Key
+	}
+	return cat
+}
@@ -1,11 +1,17 @@
 package books
 
 import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/darkace1998/jw-scripts/internal/config"
+	"github.com/darkace1998/jw-scripts/internal/pubmedia"
 )
 
 func TestBookFormats(t *testing.T) {
@@ -291,3 +297,78 @@ func TestChecksumValidation(t *testing.T) {
 		t.Error("ValidateChecksum with non-existent file should fail")
 	}
 }
+
+func TestClientOptions(t *testing.T) {
+	var userAgent, pub string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		pub = r.URL.Query().Get("pub")
+		_, _ = w.Write([]byte(`{"pubName":"Test","pub":"tp","files":{}}`))
+	}))
+	defer server.Close()
+
+	settings := &config.Settings{Quiet: 2, PubMediaURL: "http://unused.invalid", UserAgent: "from-settings"}
+	client := NewClient(settings, WithBaseURL(server.URL), WithUserAgent("jwb-test/1.0"), WithHTTPClient(server.Client()))
+
+	if _, err := client.GetBook("E", "tp"); !errors.Is(err, pubmedia.ErrNotFound) {
+		t.Fatalf("GetBook() error = %v, want no files for the language", err)
+	}
+	if userAgent != "jwb-test/1.0" || pub != "tp" {
+		t.Errorf("expected request for tp with option User-Agent, got pub %q and User-Agent %q", pub, userAgent)
+	}
+	if !client.IsBookAPIAvailable() {
+		t.Error("expected injected endpoint to be reported as available")
+	}
+}
+
+func TestClientEndpointFromSettings(t *testing.T) {
+	client := NewClient(&config.Settings{PubMediaURL: "http://mirror.example/pub-media"})
+	if client.baseURL != "http://mirror.example/pub-media" {
+		t.Errorf("expected endpoint from settings, got %q", client.baseURL)
+	}
+	if client := NewClient(&config.Settings{}); client.baseURL != config.DefaultPubMediaURL {
+		t.Errorf("expected default endpoint, got %q", client.baseURL)
+	}
+}
+
+func TestDownloadBookResumesSafely(t *testing.T) {
+	content := []byte("%PDF-1.7 book content %%EOF")
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "tp.pdf", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	book := &Book{Title: "Test Book", Files: []BookFile{{Format: FormatPDF, URL: server.URL + "/tp.pdf", Filename: "tp.pdf"}}}
+	part := filepath.Join(dir, "tp.pdf.part")
+
+	// A partial download of an older version of the file is not resumed
+	if err := os.WriteFile(part, []byte("stale"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	validator := `{"url":"` + server.URL + `/tp.pdf","etag":"\"v1\""}`
+	if err := os.WriteFile(part+".validator", []byte(validator), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDownloader(&config.Settings{Quiet: 2})
+	if err := d.DownloadBook(book, FormatPDF, dir); err != nil {
+		t.Fatalf("DownloadBook() returned error: %v", err)
+	}
+	// #nosec G304 - path is constrained to t.TempDir() in this test
+	got, err := os.ReadFile(filepath.Join(dir, "tp.pdf"))
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("got %q (%v), want %q", got, err, content)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=5-" {
+		t.Errorf("got range requests %q, want one If-Range request", ranges)
+	}
+	for _, name := range []string{part, part + ".validator"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("did not expect %s to remain", filepath.Base(name))
+		}
+	}
+}
@@ -1,57 +1,98 @@
 package books
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
+	"maps"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/darkace1998/jw-scripts/internal/config"
+	"github.com/darkace1998/jw-scripts/internal/httpclient"
+	"github.com/darkace1998/jw-scripts/internal/pubmedia"
 )
 
 // Client implements the BookAPI interface for JW.org book operations
 type Client struct {
 	baseURL    string
+	userAgent  string
 	httpClient *http.Client
 	settings   *config.Settings
+	pubMedia   *pubmedia.Client
 }
 
-// PublicationResponse represents the API response from the JW.org publication media API
-type PublicationResponse struct {
-	PubName       string                           `json:"pubName"`
-	ParentPubName string                           `json:"parentPubName"`
-	Pub           string                           `json:"pub"`
-	Issue         string                           `json:"issue"`
-	FormattedDate string                           `json:"formattedDate"`
-	FileFormat    []string                         `json:"fileformat"`
-	Files         map[string]map[string][]FileInfo `json:"files"`
+// Option configures optional behaviour of a Client
+type Option func(*Client)
+
+// WithBaseURL sets the URL of the publication media API
+func WithBaseURL(u string) Option {
+	return func(c *Client) { c.baseURL = u }
 }
 
-// FileInfo represents a downloadable file from the API
-type FileInfo struct {
-	Title string `json:"title"`
-	File  struct {
-		URL              string `json:"url"`
-		ModifiedDatetime string `json:"modifiedDatetime"`
-		Checksum         string `json:"checksum"`
-	} `json:"file"`
-	FileSize int64  `json:"filesize"`
-	MimeType string `json:"mimetype"`
+// WithUserAgent sets the User-Agent header sent with every request
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
 }
 
-// NewClient creates a new book API client
-func NewClient(s *config.Settings) *Client {
-	return &Client{
-		baseURL: "https://b.jw-cdn.org/apis/pub-media/GETPUBMEDIALINKS",
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		settings: s,
+// WithHTTPClient makes the client send its requests through hc. The client
+// is used as-is: retries and caching configured in the settings are not
+// applied to it.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// NewClient creates a new book API client. Failed requests are retried up to
+// settings.Retries times. When settings.CacheMaxAge is set, publication data
+// is cached below the work directory and revalidated with conditional
+// requests. The endpoint and User-Agent are taken from the settings unless
+// they are overridden by opts.
+func NewClient(s *config.Settings, opts ...Option) *Client {
+	var onRetry func(httpclient.Retry)
+	if s.Quiet < 2 {
+		onRetry = httpclient.LogRetries(os.Stderr)
+	}
+	httpClient := httpclient.New(30*time.Second, s.Retries, onRetry)
+	if s.CacheMaxAge > 0 && s.WorkDir != "" {
+		cache := &httpclient.Cache{
+			Dir:     filepath.Join(s.WorkDir, httpclient.CacheDirName),
+			MaxAge:  s.CacheMaxAge,
+			Refresh: s.Refresh,
+		}
+		httpClient = cache.Wrap(httpClient)
 	}
+	c := &Client{
+		baseURL:    config.DefaultPubMediaURL,
+		userAgent:  s.UserAgent,
+		httpClient: httpClient,
+		settings:   s,
+	}
+	if s.PubMediaURL != "" {
+		c.baseURL = s.PubMediaURL
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.pubMedia = pubmedia.NewClient(c.baseURL, pubmedia.WithHTTPClient(c.httpClient), pubmedia.WithUserAgent(c.userAgent))
+	return c
+}
+
+// get sends a GET request for requestURL with the configured User-Agent
+func (c *Client) get(ctx context.Context, requestURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	// #nosec G704 - URL is built from the configured publication media endpoint
+	return c.httpClient.Do(req)
 }
 
 // GetSupportedLanguages returns all supported languages
@@ -163,43 +204,45 @@ func (c *Client) GetCategory(lang, categoryKey string) (*BookCategory, error) {
 // GetBook returns details for a specific book
 func (c *Client) GetBook(lang, bookID string) (*Book, error) {
 	// Make request to the publication API
-	pubResp, err := c.getPublicationDataForLanguage(bookID, "", lang)
+	pub, err := c.pubMedia.Get(context.Background(), pubmedia.Query{Pub: bookID, Langs: []string{lang}})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get publication data for '%s': %w", bookID, err)
 	}
+	byFormat, err := pub.ByFormat(lang)
+	if err != nil {
+		return nil, err
+	}
 
 	// Convert to our Book format
 	book := &Book{
-		ID:          pubResp.Pub,
-		Title:       pubResp.PubName,
-		Description: pubResp.ParentPubName,
+		ID:          pub.Pub,
+		Title:       pub.PubName,
+		Description: pub.ParentPubName,
 		Language:    lang,
-		Issue:       pubResp.Issue,
+		Issue:       pub.Issue,
 		Files:       make([]BookFile, 0),
 	}
 
 	// Convert files
-	if langFiles, exists := pubResp.Files[strings.ToUpper(lang)]; exists {
-		for formatName, fileList := range langFiles {
-			format := c.parseFormat(formatName)
-			if format == FormatUnknown {
-				continue // Skip unsupported formats
-			}
+	for _, formatName := range slices.Sorted(maps.Keys(byFormat)) {
+		format := c.parseFormat(formatName)
+		if format == FormatUnknown {
+			continue // Skip unsupported formats
+		}
 
-			for _, fileInfo := range fileList {
-				bookFile := BookFile{
-					Format:   format,
-					URL:      fileInfo.File.URL,
-					Size:     fileInfo.FileSize,
-					Checksum: fileInfo.File.Checksum,
-					Title:    fileInfo.Title,
-				}
-				// Extract filename from URL since the API doesn't provide one directly
-				if u, err := url.Parse(fileInfo.File.URL); err == nil {
-					bookFile.Filename = path.Base(u.Path)
-				}
-				book.Files = append(book.Files, bookFile)
+		for _, fileInfo := range byFormat[formatName] {
+			bookFile := BookFile{
+				Format:   format,
+				URL:      fileInfo.File.URL,
+				Size:     fileInfo.Filesize,
+				Checksum: fileInfo.File.Checksum,
+				Title:    fileInfo.Title,
+			}
+			// Extract filename from URL since the API doesn't provide one directly
+			if u, err := url.Parse(fileInfo.File.URL); err == nil {
+				bookFile.Filename = path.Base(u.Path)
 			}
+			book.Files = append(book.Files, bookFile)
 		}
 	}
 
@@ -249,13 +292,7 @@ func (c *Client) GetSupportedFormats() []BookFormat {
 // IsBookAPIAvailable checks if the book API is currently available
 func (c *Client) IsBookAPIAvailable() bool {
 	// Test with a known publication to verify the API endpoint is reachable
-	params := url.Values{}
-	params.Set("output", "json")
-	params.Set("pub", "nwtsty")
-	params.Set("fileformat", "PDF")
-	params.Set("alllangs", "0")
-	params.Set("langwritten", "E")
-	requestURL := c.baseURL + "?" + params.Encode()
+	requestURL := c.pubMedia.URL(pubmedia.Query{Pub: "nwtsty", Formats: []string{pubmedia.PDF}, Langs: []string{"E"}})
 
 	parsedURL, err := url.Parse(requestURL)
 	if err != nil {
@@ -265,12 +302,9 @@ func (c *Client) IsBookAPIAvailable() bool {
 		return false
 	}
 
-	req, err := http.NewRequest("GET", parsedURL.String(), http.NoBody)
-	if err != nil {
-		return false
-	}
-	// #nosec G704 - URL scheme is validated above to only allow http/https; base URL is a fixed JW.org endpoint
-	resp, err := c.httpClient.Do(req)
+	// An availability probe should answer quickly, so it is never retried.
+	ctx := httpclient.WithMaxRetries(context.Background(), 0)
+	resp, err := c.get(ctx, parsedURL.String())
 	if err != nil {
 		return false
 	}
@@ -299,51 +333,11 @@ Supported Languages:
 - Slovak, Welsh, Hungarian, Norwegian, Arabic, Czech
 - Croatian, Danish, Greek, Lithuanian, Romanian, Dutch
 
-API Endpoint: https://b.jw-cdn.org/apis/pub-media/GETPUBMEDIALINKS
+API Endpoint: ` + c.baseURL + `
 
 The framework fully supports book downloads with real data from JW.org.`
 }
 
-// getPublicationDataForLanguage fetches publication data for a specific language
-func (c *Client) getPublicationDataForLanguage(pubCode, issue, lang string) (*PublicationResponse, error) {
-	params := url.Values{}
-	params.Set("output", "json")
-	params.Set("pub", pubCode)
-	params.Set("fileformat", "PDF,EPUB,MP3,MP4,RTF,BRL")
-	params.Set("alllangs", "0")
-	params.Set("langwritten", lang)
-	params.Set("txtCMSLang", lang)
-
-	if issue != "" {
-		params.Set("issue", issue)
-	}
-
-	requestURL := c.baseURL + "?" + params.Encode()
-
-	resp, err := c.httpClient.Get(requestURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned status %d for publication '%s'", resp.StatusCode, pubCode)
-	}
-
-	// Limit response body to 10 MiB to prevent excessive memory use
-	body, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	var pubResp PublicationResponse
-	if err := json.Unmarshal(body, &pubResp); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON: %w", err)
-	}
-
-	return &pubResp, nil
-}
-
 // parseFormat converts API format strings to our BookFormat enum
 func (c *Client) parseFormat(formatString string) BookFormat {
 	switch strings.ToUpper(formatString) {
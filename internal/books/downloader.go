@@ -18,6 +18,9 @@ import (
 // Downloader implements the BookDownloader interface
 type Downloader struct {
 	settings *config.Settings
+	// broken lists the files of the current category that failed
+	// verification on every attempt
+	broken []string
 }
 
 // NewDownloader creates a new book downloader
@@ -65,8 +68,10 @@ func (d *Downloader) DownloadBook(book *Book, format BookFormat, outputDir strin
 	return errors.Join(errs...)
 }
 
-// downloadBookFile downloads a single file of a book, validates its checksum
-// when available and optionally writes a metadata sidecar file.
+// downloadBookFile downloads a single file of a book, verifies it,
+// optionally writes a metadata sidecar file and moves both into the
+// library. A file failing verification is quarantined and downloaded again,
+// up to settings.VerifyRetries times.
 func (d *Downloader) downloadBookFile(book *Book, targetFile *BookFile, format BookFormat, outputDir string, index int) error {
 	filename := targetFile.Filename
 	if filename == "" {
@@ -81,39 +86,86 @@ func (d *Downloader) downloadBookFile(book *Book, targetFile *BookFile, format B
 		filename = fmt.Sprintf("%s.%s", safeTitle, d.getFileExtension(format))
 	}
 	outputPath := filepath.Join(outputDir, filename)
+	ctx := downloader.RequestContext(d.settings)
 
 	// Skip files that are already fully downloaded. With embedded metadata
 	// enabled, files grow beyond the size reported by the API, so anything
 	// at least as large as the original download counts as complete.
-	if fi, err := os.Stat(outputPath); err == nil && targetFile.Size > 0 {
-		complete := fi.Size() == targetFile.Size ||
-			(d.settings.WriteMetadata && fi.Size() > targetFile.Size)
+	if fi, err := downloader.Stat(ctx, d.settings, outputPath); err == nil && targetFile.Size > 0 {
+		complete := fi.Size == targetFile.Size ||
+			(d.settings.WriteMetadata && fi.Size > targetFile.Size)
 		if complete {
 			if d.settings.Quiet < 1 {
 				fmt.Printf("Already downloaded: %s\n", outputPath)
 			}
-			return d.writeMetadataIfEnabled(book, targetFile, outputDir, filename)
+			if _, err := os.Stat(outputPath); err != nil {
+				// Only in the library, which already has its metadata
+				return nil
+			}
+			if err := d.writeMetadataIfEnabled(book, targetFile, outputDir, filename); err != nil {
+				return err
+			}
+			return d.publish(outputDir, filename)
 		}
 	}
 
-	if d.settings.Quiet < 1 {
-		fmt.Printf("Downloading: %s -> %s\n", book.Title, outputPath)
+	// Download through a ".part" file, which an interrupted run resumes
+	// only while the file on the server is unchanged
+	tmpPath := outputPath + ".part"
+	for attempt := 0; ; attempt++ {
+		if d.settings.Quiet < 1 {
+			if attempt == 0 {
+				fmt.Printf("Downloading: %s -> %s\n", book.Title, outputPath)
+			} else {
+				fmt.Printf("Retry %d/%d: %s -> %s\n", attempt, d.settings.VerifyRetries, book.Title, outputPath)
+			}
+		}
+		if err := downloader.DownloadFileContext(ctx, targetFile.URL, tmpPath, true, 0); err != nil {
+			return err
+		}
+		err := downloader.VerifyFile(tmpPath, filename, targetFile.Size, targetFile.Checksum)
+		var verr *downloader.VerificationError
+		if !errors.As(err, &verr) {
+			if err != nil {
+				return err
+			}
+			break
+		}
+		dest, qerr := downloader.Quarantine(downloader.QuarantineDir(d.settings), tmpPath, filename, targetFile.URL, verr.Reason)
+		if qerr != nil {
+			return errors.Join(err, qerr)
+		}
+		if d.settings.Quiet < 2 {
+			fmt.Printf("%s failed verification (%s), moved to %s\n", filename, verr.Reason, dest)
+		}
+		if attempt >= d.settings.VerifyRetries {
+			d.broken = append(d.broken, fmt.Sprintf("%s (%s)", outputPath, verr.Reason))
+			return err
+		}
+	}
+	if err := os.Rename(tmpPath, outputPath); err != nil {
+		return fmt.Errorf("could not finalize download: %w", err)
 	}
 
-	if err := downloader.DownloadFile(targetFile.URL, outputPath, false, d.settings.RateLimit); err != nil {
+	if err := d.writeMetadataIfEnabled(book, targetFile, outputDir, filename); err != nil {
 		return err
 	}
+	return d.publish(outputDir, filename)
+}
 
-	if targetFile.Checksum != "" {
-		if err := d.ValidateChecksum(outputPath, targetFile.Checksum); err != nil {
-			if removeErr := os.Remove(outputPath); removeErr != nil && d.settings.Quiet < 2 {
-				fmt.Printf("Failed to remove corrupt file %s: %v\n", outputPath, removeErr)
-			}
-			return err
+// publish moves a finished book file and its metadata sidecar from the
+// work directory into the library.
+func (d *Downloader) publish(outputDir, filename string) error {
+	ctx := downloader.RequestContext(d.settings)
+	for _, path := range []string{filepath.Join(outputDir, filename), metadata.SidecarPath(outputDir, filename)} {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := downloader.Publish(ctx, d.settings, path); err != nil {
+			return fmt.Errorf("could not move %s to %s: %w", filepath.Base(path), d.settings.Library(), err)
 		}
 	}
-
-	return d.writeMetadataIfEnabled(book, targetFile, outputDir, filename)
+	return nil
 }
 
 // writeMetadataIfEnabled embeds metadata into a downloaded book file when
@@ -144,7 +196,7 @@ func (d *Downloader) writeMetadataIfEnabled(book *Book, targetFile *BookFile, ou
 	if err == nil {
 		// Remove any sidecar left over from earlier versions that wrote
 		// JSON files instead of embedding.
-		_ = os.Remove(metadata.SidecarPath(outputDir, filename))
+		_ = downloader.Remove(downloader.RequestContext(d.settings), d.settings, metadata.SidecarPath(outputDir, filename))
 		return nil
 	}
 	if !errors.Is(err, metadata.ErrUnsupportedFormat) && d.settings.Quiet < 2 {
@@ -177,6 +229,7 @@ func (d *Downloader) DownloadCategory(category *BookCategory, format BookFormat,
 
 	successCount := 0
 	errorCount := 0
+	d.broken = nil
 
 	for i := range category.Books {
 		book := &category.Books[i]
@@ -199,6 +252,12 @@ func (d *Downloader) DownloadCategory(category *BookCategory, format BookFormat,
 		fmt.Printf("Category '%s' download complete: %d successful, %d failed\n",
 			category.Name, successCount, errorCount)
 	}
+	if len(d.broken) > 0 && d.settings.Quiet < 2 {
+		fmt.Println("Permanently broken (failed verification on every attempt):")
+		for _, item := range d.broken {
+			fmt.Printf("  %s\n", item)
+		}
+	}
 
 	return nil
 }
@@ -5,14 +5,19 @@ import (
 	"crypto/md5" // #nosec G501 - MD5 used for test checksums matching the API format
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/darkace1998/jw-scripts/internal/config"
+	"github.com/darkace1998/jw-scripts/internal/downloader"
+	"github.com/darkace1998/jw-scripts/internal/storage"
 )
 
 func newTestServer(t *testing.T, files map[string]string) *httptest.Server {
@@ -34,10 +39,18 @@ func md5Of(s string) string {
 	return hex.EncodeToString(sum[:])
 }
 
+// Test files start with an MPEG frame header or the PDF markers, so they
+// pass the structure check of downloader.VerifyFile.
+const (
+	audioOne = "\xff\xfb\x90\x00audio-one"
+	audioTwo = "\xff\xfb\x90\x00audio-two"
+	pdfBytes = "%PDF-1.7 pdf-bytes %%EOF"
+)
+
 func TestDownloadBookFetchesAllFilesOfFormat(t *testing.T) {
 	server := newTestServer(t, map[string]string{
-		"/track1.mp3": "audio-one",
-		"/track2.mp3": "audio-two",
+		"/track1.mp3": audioOne,
+		"/track2.mp3": audioTwo,
 	})
 
 	book := &Book{
@@ -45,8 +58,8 @@ func TestDownloadBookFetchesAllFilesOfFormat(t *testing.T) {
 		Title:    "Test Publication",
 		Language: "E",
 		Files: []BookFile{
-			{Format: FormatMP3, URL: server.URL + "/track1.mp3", Filename: "track1.mp3", Checksum: md5Of("audio-one"), Size: int64(len("audio-one"))},
-			{Format: FormatMP3, URL: server.URL + "/track2.mp3", Filename: "track2.mp3", Checksum: md5Of("audio-two"), Size: int64(len("audio-two"))},
+			{Format: FormatMP3, URL: server.URL + "/track1.mp3", Filename: "track1.mp3", Checksum: md5Of(audioOne), Size: int64(len(audioOne))},
+			{Format: FormatMP3, URL: server.URL + "/track2.mp3", Filename: "track2.mp3", Checksum: md5Of(audioTwo), Size: int64(len(audioTwo))},
 			{Format: FormatPDF, URL: server.URL + "/book.pdf", Filename: "book.pdf"},
 		},
 	}
@@ -68,9 +81,9 @@ func TestDownloadBookFetchesAllFilesOfFormat(t *testing.T) {
 	}
 }
 
-func TestDownloadBookChecksumMismatchRemovesFile(t *testing.T) {
+func TestDownloadBookChecksumMismatchQuarantinesFile(t *testing.T) {
 	server := newTestServer(t, map[string]string{
-		"/bad.mp3": "actual-content",
+		"/bad.mp3": audioOne,
 	})
 
 	book := &Book{
@@ -78,25 +91,45 @@ func TestDownloadBookChecksumMismatchRemovesFile(t *testing.T) {
 		Title:    "Test Publication",
 		Language: "E",
 		Files: []BookFile{
-			{Format: FormatMP3, URL: server.URL + "/bad.mp3", Filename: "bad.mp3", Checksum: md5Of("expected-content")},
+			{Format: FormatMP3, URL: server.URL + "/bad.mp3", Filename: "bad.mp3", Checksum: md5Of(audioTwo)},
 		},
 	}
 
 	dir := t.TempDir()
-	d := NewDownloader(&config.Settings{Quiet: 2})
+	quarantine := t.TempDir()
+	d := NewDownloader(&config.Settings{Quiet: 2, VerifyRetries: 1, QuarantineDir: quarantine})
+
+	if err := d.DownloadBook(book, FormatMP3, dir); !errors.Is(err, downloader.ErrVerificationFailed) {
+		t.Fatalf("DownloadBook() error = %v, want verification failure", err)
+	}
+	for _, name := range []string{"bad.mp3", "bad.mp3.part"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("expected corrupt %s to be moved away", name)
+		}
+	}
+	if len(d.broken) != 1 {
+		t.Errorf("got broken files %q, want bad.mp3", d.broken)
+	}
 
-	if err := d.DownloadBook(book, FormatMP3, dir); err == nil {
-		t.Fatal("expected checksum mismatch error")
+	// The first download and its retry are both quarantined
+	// #nosec G304 - path is constrained to t.TempDir() in this test
+	log, err := os.ReadFile(filepath.Join(quarantine, downloader.QuarantineLogName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(log)), "\n"); len(lines) != 2 || !strings.Contains(lines[0], "MD5 checksum mismatch") {
+		t.Errorf("unexpected quarantine log:\n%s", log)
 	}
-	if _, err := os.Stat(filepath.Join(dir, "bad.mp3")); !os.IsNotExist(err) {
-		t.Error("expected corrupt file to be removed")
+	entries, err := os.ReadDir(quarantine)
+	if err != nil || len(entries) < 2 {
+		t.Errorf("expected quarantined files in %s, got %d (%v)", quarantine, len(entries), err)
 	}
 }
 
 // PDF cannot carry embedded tags, so metadata falls back to a JSON sidecar.
 func TestDownloadBookWritesSidecarForUnsupportedFormat(t *testing.T) {
 	server := newTestServer(t, map[string]string{
-		"/pub.pdf": "pdf-bytes",
+		"/pub.pdf": pdfBytes,
 	})
 
 	book := &Book{
@@ -105,7 +138,7 @@ func TestDownloadBookWritesSidecarForUnsupportedFormat(t *testing.T) {
 		Language: "E",
 		Issue:    "202601",
 		Files: []BookFile{
-			{Format: FormatPDF, URL: server.URL + "/pub.pdf", Filename: "pub.pdf", Title: "Daily Text 2026", Size: int64(len("pdf-bytes"))},
+			{Format: FormatPDF, URL: server.URL + "/pub.pdf", Filename: "pub.pdf", Title: "Daily Text 2026", Size: int64(len(pdfBytes))},
 		},
 	}
 
@@ -210,3 +243,39 @@ func TestDownloadBookSkipsCompleteFiles(t *testing.T) {
 		t.Errorf("expected already-complete file to be skipped, but %d requests were made", requests)
 	}
 }
+
+func TestDownloadBookMovesFilesIntoLibrary(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		_, _ = io.WriteString(w, pdfBytes)
+	}))
+	t.Cleanup(server.Close)
+
+	book := &Book{
+		ID:    "es25",
+		Title: "Daily Text",
+		Files: []BookFile{
+			{Format: FormatPDF, URL: server.URL + "/pub.pdf", Filename: "pub.pdf", Size: int64(len(pdfBytes))},
+		},
+	}
+
+	dir, libDir := t.TempDir(), t.TempDir()
+	d := NewDownloader(&config.Settings{Quiet: 2, WriteMetadata: true, WorkDir: dir, Storage: storage.NewLocal(libDir)})
+	for range 2 {
+		if err := d.DownloadBook(book, FormatPDF, dir); err != nil {
+			t.Fatalf("DownloadBook() returned error: %v", err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("got %d requests, want 1: the file in the library is not downloaded again", requests)
+	}
+	for _, name := range []string{"pub.pdf", "pub.pdf.json"} {
+		if _, err := os.Stat(filepath.Join(libDir, name)); err != nil {
+			t.Errorf("expected %s in the library: %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("did not expect %s to remain in the output directory", name)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+// Package catalog keeps a persistent record of the media indexed by
+// jwb-index. The catalog is a versioned JSON file in the work directory that
+// remembers every media item ever seen, when it was first and last seen, its
+// categories, the chosen file and the state of the local copy, so other
+// commands can answer questions about the collection without touching the
+// network.
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/darkace1998/jw-scripts/internal/api"
+	"github.com/darkace1998/jw-scripts/internal/config"
+	"github.com/darkace1998/jw-scripts/internal/downloader"
+)
+
+// FileName is the name of the catalog file inside the work directory.
+const FileName = ".jwb-catalog.json"
+
+// Version is the catalog format written by this package. Catalogs with a
+// newer version are rejected rather than silently downgraded.
+const Version = 1
+
+// ErrUnsupportedVersion is returned by Load for catalogs written by a newer
+// version of the tools.
+var ErrUnsupportedVersion = errors.New("unsupported catalog version")
+
+// State is the state of the local copy of a media item.
+type State string
+
+// Download states recorded in the catalog.
+const (
+	// StateMissing means there is no local copy.
+	StateMissing State = "missing"
+	// StatePartial means an interrupted download (".part" file) exists.
+	StatePartial State = "partial"
+	// StateDownloaded means the local file exists and has the expected size.
+	StateDownloaded State = "downloaded"
+	// StateCorrupt means the local file has the wrong size or checksum.
+	StateCorrupt State = "corrupt"
+	// StateOutdated means the local file is another variant (resolution)
+	// than the file selected now.
+	StateOutdated State = "outdated"
+)
+
+// Catalog is the persistent index of all media seen so far.
+type Catalog struct {
+	Version    int         `json:"version"`
+	Updated    time.Time   `json:"updated,omitzero"`
+	Categories []*Category `json:"categories"`
+	Media      []*Entry    `json:"media"`
+
+	categories map[string]*Category
+	media      map[string]*Entry
+}
+
+// Category records a category seen while indexing.
+type Category struct {
+	Lang      string    `json:"lang"`
+	Key       string    `json:"key"`
+	Name      string    `json:"name"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// Entry records a single media item in one language.
+type Entry struct {
+	// ID identifies the entry; see ID.
+	ID    string `json:"id"`
+	Lang  string `json:"lang"`
+	Title string `json:"title"`
+
+	GUID                       string `json:"guid,omitempty"`
+	NaturalKey                 string `json:"naturalKey,omitempty"`
+	LanguageAgnosticNaturalKey string `json:"languageAgnosticNaturalKey,omitempty"`
+
+	// Categories holds the keys of the categories listing the media item
+	// when it was last seen.
+	Categories []string `json:"categories"`
+	Date       int64    `json:"date,omitempty"`
+	Duration   float64  `json:"duration,omitempty"`
+
+	// URL, Size, MD5 and Label describe the file chosen for download.
+	URL   string `json:"url"`
+	Size  int64  `json:"size,omitempty"`
+	MD5   string `json:"md5,omitempty"`
+	Label string `json:"label,omitempty"`
+
+	// SubDir and Filename locate the local copy inside the work directory.
+	SubDir   string `json:"subDir,omitempty"`
+	Filename string `json:"filename"`
+	State    State  `json:"state"`
+	// SubtitleFilename is the name of the subtitle file in the indexing
+	// language, next to the local copy.
+	SubtitleFilename string `json:"subtitleFilename,omitempty"`
+	// LocalLabel is the resolution label of the local copy, recorded when
+	// it is downloaded; empty when unknown.
+	LocalLabel string `json:"localLabel,omitempty"`
+
+	// VerifiedMD5 is the checksum the local file was last verified
+	// against. It is cleared when the file or the expected checksum
+	// changes.
+	VerifiedMD5     string    `json:"verifiedMd5,omitempty"`
+	VerifiedSize    int64     `json:"verifiedSize,omitempty"`
+	VerifiedModTime time.Time `json:"verifiedModTime,omitzero"`
+
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	// Removed is set when the entry was missing from a run that indexed
+	// its categories; it is cleared when the media item reappears.
+	Removed time.Time `json:"removed,omitzero"`
+}
+
+// Path returns the location of the catalog file in workDir.
+func Path(workDir string) string {
+	return filepath.Join(workDir, FileName)
+}
+
+// New returns an empty catalog.
+func New() *Catalog {
+	c := &Catalog{Version: Version, Categories: []*Category{}, Media: []*Entry{}}
+	c.reindex()
+	return c
+}
+
+// Load reads the catalog stored at path. A missing file yields an empty
+// catalog.
+func Load(path string) (*Catalog, error) {
+	// #nosec G304 - path is the catalog file in the user-chosen work directory
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return New(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Catalog{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("could not parse catalog %s: %w", path, err)
+	}
+	if c.Version > Version {
+		return nil, fmt.Errorf("%w %d in %s (this version supports up to %d)", ErrUnsupportedVersion, c.Version, path, Version)
+	}
+	c.Version = Version
+	c.reindex()
+	return c, nil
+}
+
+// Save writes the catalog to path. The file is replaced atomically, so an
+// interrupted run never leaves a truncated catalog behind.
+func (c *Catalog) Save(path string) error {
+	sort.Slice(c.Categories, func(i, j int) bool {
+		if c.Categories[i].Lang != c.Categories[j].Lang {
+			return c.Categories[i].Lang < c.Categories[j].Lang
+		}
+		return c.Categories[i].Key < c.Categories[j].Key
+	})
+	sort.Slice(c.Media, func(i, j int) bool { return c.Media[i].ID < c.Media[j].ID })
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Record loads the catalog in the work directory of s, updates it with the
+// indexed languages and saves it. It returns the changes since the
+// previous run.
+func Record(s *config.Settings, langs []api.IndexedLanguage, now time.Time) (*Report, error) {
+	path := Path(s.WorkDir)
+	c, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	r := newReport(c.Updated, now)
+	for _, l := range langs {
+		c.update(l.Settings, l.Data, now, r)
+	}
+	if err := c.Save(path); err != nil {
+		return nil, fmt.Errorf("could not save catalog: %w", err)
+	}
+	return r, nil
+}
+
+// ID returns the catalog identifier of media in language lang. Media are
+// identified by their natural key, falling back to the GUID, the download
+// URL and finally the filename.
+func ID(lang string, media *api.Media) string {
+	key := media.NaturalKey
+	for _, alt := range []string{media.GUID, media.URL, media.Filename} {
+		if key == "" {
+			key = alt
+		}
+	}
+	return lang + ":" + key
+}
+
+// Annotate sets LocalFilename and LocalLabel of every media item in data,
+// indexed with the settings s, from the local copy recorded in the catalog,
+// so the downloader can tell which variant is already downloaded.
+func (c *Catalog) Annotate(s *config.Settings, data []*api.Category) {
+	for _, cat := range data {
+		for _, media := range cat.Media {
+			e := c.media[ID(s.Lang, media)]
+			if e == nil || e.SubDir != s.SubDir || e.LocalLabel == "" {
+				continue
+			}
+			media.LocalFilename = e.Filename
+			media.LocalLabel = e.LocalLabel
+		}
+	}
+}
+
+// Get returns the entry with the given ID, or nil.
+func (c *Catalog) Get(id string) *Entry {
+	return c.media[id]
+}
+
+// Category returns the category with the given key in language lang, or
+// nil.
+func (c *Catalog) Category(lang, key string) *Category {
+	return c.categories[lang+":"+key]
+}
+
+// Update records the categories and media of data, indexed with the
+// settings s, as seen at now, and returns what changed since the previous
+// update. The state of every local copy is refreshed from the files in the
+// work directory; with s.Checksums set, files that have not been verified
+// yet are checked against their MD5 checksum. Entries not present in data
+// are kept; see Report for when they are reported as removed.
+func (c *Catalog) Update(s *config.Settings, data []*api.Category, now time.Time) *Report {
+	r := newReport(c.Updated, now)
+	c.update(s, data, now, r)
+	return r
+}
+
+func (c *Catalog) update(s *config.Settings, data []*api.Category, now time.Time, r *Report) {
+	now = now.UTC()
+	c.Updated = now
+	r.Updated = now
+	ctx := context.Background()
+
+	// Additions are only listed individually when the language has been
+	// indexed into this subdirectory before.
+	firstRun := !slices.ContainsFunc(c.Media, func(e *Entry) bool {
+		return e.Lang == s.Lang && e.SubDir == s.SubDir
+	})
+
+	// Category membership is rebuilt for every entry seen in this run.
+	touched := make(map[*Entry]bool)
+	indexed := make(map[string]bool)
+	var added, changed []*Entry
+	fields := make(map[*Entry][]FieldChange)
+	for _, cat := range data {
+		indexed[cat.Key] = true
+		c.updateCategory(s.Lang, cat, now, r)
+		for _, media := range cat.Media {
+			e, isNew, diff := c.updateMedia(ctx, s, media, now)
+			if !touched[e] {
+				touched[e] = true
+				e.Categories = nil
+			}
+			if !slices.Contains(e.Categories, cat.Key) {
+				e.Categories = append(e.Categories, cat.Key)
+			}
+			switch {
+			case isNew:
+				added = append(added, e)
+			case len(diff) > 0:
+				changed = append(changed, e)
+				fields[e] = diff
+			}
+		}
+	}
+
+	for e := range touched {
+		e.refreshState(ctx, s)
+	}
+	for _, e := range added {
+		if !firstRun {
+			r.Added = append(r.Added, newChange(e))
+		}
+	}
+	for _, e := range changed {
+		change := newChange(e)
+		change.Fields = fields[e]
+		r.Changed = append(r.Changed, change)
+	}
+
+	if firstRun {
+		if len(touched) > 0 {
+			r.FirstRun = append(r.FirstRun, FirstRun{Lang: s.Lang, SubDir: s.SubDir, Media: len(touched)})
+		}
+		return
+	}
+	for _, e := range c.Media {
+		if touched[e] || !e.Removed.IsZero() || e.Lang != s.Lang || e.SubDir != s.SubDir || !c.inScope(s, e, indexed) {
+			continue
+		}
+		e.Removed = now
+		r.Removed = append(r.Removed, newChange(e))
+	}
+}
+
+// inScope reports whether e should have been seen by a run indexing the
+// categories in indexed with the date filters of s. Entries outside the
+// indexed categories or the date window were simply not looked at and are
+// not reported as removed.
+func (c *Catalog) inScope(s *config.Settings, e *Entry, indexed map[string]bool) bool {
+	if !slices.ContainsFunc(e.Categories, func(key string) bool { return indexed[key] }) {
+		return false
+	}
+	if (s.MinDate > 0 || s.MaxDate > 0) && e.Date == 0 {
+		return false
+	}
+	return (s.MinDate == 0 || e.Date >= s.MinDate) && (s.MaxDate == 0 || e.Date <= s.MaxDate)
+}
+
+func (c *Catalog) updateCategory(lang string, cat *api.Category, now time.Time, r *Report) {
+	id := lang + ":" + cat.Key
+	entry := c.categories[id]
+	if entry == nil {
+		entry = &Category{Lang: lang, Key: cat.Key, FirstSeen: now}
+		c.categories[id] = entry
+		c.Categories = append(c.Categories, entry)
+	} else if entry.Name != cat.Name && entry.Name != "" && cat.Name != "" && entry.LastSeen.Before(now) {
+		r.RenamedCategories = append(r.RenamedCategories, Rename{Lang: lang, Key: cat.Key, OldName: entry.Name, NewName: cat.Name})
+	}
+	entry.Name = cat.Name
+	entry.LastSeen = now
+}
+
+// updateMedia records media as seen at now. It returns the entry, whether
+// it is new (never seen before, or seen again after being removed) and, for
+// entries seen in an earlier run, how the chosen file changed.
+func (c *Catalog) updateMedia(ctx context.Context, s *config.Settings, media *api.Media, now time.Time) (e *Entry, isNew bool, diff []FieldChange) {
+	id := ID(s.Lang, media)
+	e = c.media[id]
+	switch {
+	case e == nil:
+		e = &Entry{ID: id, Lang: s.Lang, FirstSeen: now}
+		c.media[id] = e
+		c.Media = append(c.Media, e)
+		isNew = true
+	case !e.Removed.IsZero():
+		e.Removed = time.Time{}
+		isNew = true
+	case e.LastSeen.Before(now):
+		// Only compare against the previous run, not against another
+		// category of this one.
+		diff = diffFields(e, media)
+	}
+	e.Title = media.Name
+	e.GUID = media.GUID
+	e.NaturalKey = media.NaturalKey
+	e.LanguageAgnosticNaturalKey = media.LanguageAgnosticNaturalKey
+	e.Date = media.Date
+	e.Duration = media.Duration
+	e.URL = media.URL
+	e.Size = media.Size
+	e.MD5 = media.MD5
+	e.Label = media.Label
+	filename := media.Filename
+	switch {
+	case media.LocalLabel != "" && media.LocalFilename == media.Filename:
+		e.LocalLabel = media.LocalLabel
+	case media.LocalLabel != "" && media.LocalFilename != "" && e.SubDir == s.SubDir && keptVariant(ctx, s, media):
+		// Another variant under its own name is kept until it is
+		// upgraded, so it stays the local copy
+		e.LocalLabel = media.LocalLabel
+		filename = media.LocalFilename
+	case e.Filename != media.Filename || e.SubDir != s.SubDir:
+		// A different local file whose variant is not known
+		e.LocalLabel = ""
+	}
+	e.SubDir = s.SubDir
+	e.Filename = filename
+	e.SubtitleFilename = media.SubtitleFilename
+	e.LastSeen = now
+	return e, isNew, diff
+}
+
+// keptVariant reports whether the local copy of media, a variant other than
+// the selected one, is still in the work directory or the library.
+func keptVariant(ctx context.Context, s *config.Settings, media *api.Media) bool {
+	_, err := downloader.Stat(ctx, s, filepath.Join(s.WorkDir, s.SubDir, media.LocalFilename))
+	return err == nil
+}
+
+// refreshState updates the download state and checksum verification of e
+// from the file in the work directory or the library.
+func (e *Entry) refreshState(ctx context.Context, s *config.Settings) {
+	path := filepath.Join(s.WorkDir, e.SubDir, e.Filename)
+	fi, err := downloader.Stat(ctx, s, path)
+	if err != nil || e.Filename == "" {
+		e.clearVerification()
+		if _, err := os.Stat(path + ".part"); err == nil && e.Filename != "" {
+			e.State = StatePartial
+		} else {
+			e.State = StateMissing
+		}
+		return
+	}
+
+	if e.LocalLabel != "" && e.Label != "" && e.LocalLabel != e.Label {
+		e.clearVerification()
+		e.State = StateOutdated
+		return
+	}
+
+	// Embedded metadata grows files beyond the size reported by the API
+	// and changes their contents, just like in the downloader's checks.
+	sizeOK := e.Size == 0 || fi.Size == e.Size || (s.WriteMetadata && fi.Size > e.Size)
+	if !sizeOK {
+		e.clearVerification()
+		e.State = StateCorrupt
+		return
+	}
+	e.State = StateDownloaded
+
+	if e.VerifiedMD5 != "" && (e.VerifiedMD5 != e.MD5 || e.VerifiedSize != fi.Size || !e.VerifiedModTime.Equal(fi.ModTime)) {
+		e.clearVerification()
+	}
+	if !s.Checksums || s.WriteMetadata || e.MD5 == "" || e.VerifiedMD5 != "" {
+		return
+	}
+	ok, err := downloader.CheckStoredMD5(ctx, s, path, e.MD5)
+	switch {
+	case err != nil:
+		if s.Quiet < 2 {
+			fmt.Fprintf(os.Stderr, "could not verify %s: %v\n", path, err)
+		}
+	case ok:
+		e.VerifiedMD5 = e.MD5
+		e.VerifiedSize = fi.Size
+		e.VerifiedModTime = fi.ModTime
+	default:
+		e.State = StateCorrupt
+	}
+}
+
+func (e *Entry) clearVerification() {
+	e.VerifiedMD5 = ""
+	e.VerifiedSize = 0
+	e.VerifiedModTime = time.Time{}
+}
+
+// reindex rebuilds the lookup maps after loading.
+func (c *Catalog) reindex() {
+	c.categories = make(map[string]*Category, len(c.Categories))
+	for _, cat := range c.Categories {
+		c.categories[cat.Lang+":"+cat.Key] = cat
+	}
+	c.media = make(map[string]*Entry, len(c.Media))
+	for _, e := range c.Media {
+		c.media[e.ID] = e
+	}
+}
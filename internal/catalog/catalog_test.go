@@ -0,0 +1,364 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darkace1998/jw-scripts/internal/api"
+	"github.com/darkace1998/jw-scripts/internal/config"
+)
+
+// md5 of "video data"
+const videoDataMD5 = "a1cbf54e11273fb97da300ec3dc57a87"
+
+func testData() []*api.Category {
+	intro := &api.Media{Name: "Intro", NaturalKey: "pub-intro_VIDEO", URL: "https://cdn.example/intro.mp4", Filename: "intro.mp4", Size: 10}
+	song := &api.Media{Name: "Song", GUID: "guid-song", URL: "https://cdn.example/song.mp3", Filename: "song.mp3"}
+	return []*api.Category{
+		{Key: "Featured", Name: "Featured", Media: []*api.Media{intro}},
+		{Key: "Music", Name: "Music", Media: []*api.Media{song, intro}},
+	}
+}
+
+func TestUpdateRecordsMediaAndState(t *testing.T) {
+	dir := t.TempDir()
+	wd := filepath.Join(dir, "jwb-E")
+	if err := os.MkdirAll(wd, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(wd, "intro.mp4"), []byte("video data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(wd, "song.mp3.part"), []byte("so"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &config.Settings{WorkDir: dir, SubDir: "jwb-E", Lang: "E", Quiet: 2}
+	first := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := New()
+	c.Update(s, testData(), first)
+
+	intro := c.Get("E:pub-intro_VIDEO")
+	if intro == nil {
+		t.Fatal("intro not recorded")
+	}
+	if intro.State != StateDownloaded || !reflect.DeepEqual(intro.Categories, []string{"Featured", "Music"}) {
+		t.Errorf("unexpected intro entry %+v", intro)
+	}
+	if song := c.Get("E:guid-song"); song == nil || song.State != StatePartial {
+		t.Errorf("expected partial song entry, got %+v", song)
+	}
+
+	// A second run keeps the first-seen time and drops stale categories.
+	second := first.Add(24 * time.Hour)
+	data := testData()
+	data[1].Media = data[1].Media[:1]
+	c.Update(s, data, second)
+	if !intro.FirstSeen.Equal(first) || !intro.LastSeen.Equal(second) {
+		t.Errorf("unexpected timestamps %v / %v", intro.FirstSeen, intro.LastSeen)
+	}
+	if !reflect.DeepEqual(intro.Categories, []string{"Featured"}) {
+		t.Errorf("expected categories to be rebuilt, got %v", intro.Categories)
+	}
+}
+
+func TestUpdateKeepsLocalVariant(t *testing.T) {
+	dir := t.TempDir()
+	wd := filepath.Join(dir, "jwb-E")
+	if err := os.MkdirAll(wd, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(wd, "intro_480p.mp4"), []byte("video data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &config.Settings{WorkDir: dir, SubDir: "jwb-E", Lang: "E", Quiet: 2}
+	media := &api.Media{Name: "Intro", NaturalKey: "pub-intro_VIDEO", Filename: "intro_480p.mp4", Label: "480p", LocalFilename: "intro_480p.mp4", LocalLabel: "480p"}
+	c := New()
+	c.Update(s, []*api.Category{{Key: "Featured", Media: []*api.Media{media}}}, time.Now())
+
+	// The 720p variant is selected, but the 480p copy is not replaced
+	media = &api.Media{Name: "Intro", NaturalKey: "pub-intro_VIDEO", Filename: "intro_720p.mp4", Label: "720p"}
+	c.Annotate(s, []*api.Category{{Key: "Featured", Media: []*api.Media{media}}})
+	c.Update(s, []*api.Category{{Key: "Featured", Media: []*api.Media{media}}}, time.Now())
+	e := c.Get("E:pub-intro_VIDEO")
+	if e.Filename != "intro_480p.mp4" || e.LocalLabel != "480p" || e.State != StateOutdated {
+		t.Errorf("expected the 480p copy to stay the outdated local copy, got %+v", e)
+	}
+
+	// Once it is gone, the selected file is recorded
+	if err := os.Remove(filepath.Join(wd, "intro_480p.mp4")); err != nil {
+		t.Fatal(err)
+	}
+	media = &api.Media{Name: "Intro", NaturalKey: "pub-intro_VIDEO", Filename: "intro_720p.mp4", Label: "720p"}
+	c.Annotate(s, []*api.Category{{Key: "Featured", Media: []*api.Media{media}}})
+	c.Update(s, []*api.Category{{Key: "Featured", Media: []*api.Media{media}}}, time.Now())
+	if e.Filename != "intro_720p.mp4" || e.LocalLabel != "" || e.State != StateMissing {
+		t.Errorf("expected the selected file to be recorded as missing, got %+v", e)
+	}
+}
+
+func TestUpdateVerifiesChecksums(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "intro.mp4"), []byte("video data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &config.Settings{WorkDir: dir, Lang: "E", Quiet: 2, Checksums: true}
+	data := testData()
+	intro := data[0].Media[0]
+
+	intro.MD5 = "0123456789abcdef0123456789abcdef"
+	c := New()
+	c.Update(s, data, time.Now())
+	if e := c.Get(ID("E", intro)); e.State != StateCorrupt || e.VerifiedMD5 != "" {
+		t.Errorf("expected checksum mismatch, got %+v", e)
+	}
+
+	intro.MD5 = videoDataMD5
+	c.Update(s, data, time.Now())
+	if e := c.Get(ID("E", intro)); e.State != StateDownloaded || e.VerifiedMD5 != videoDataMD5 {
+		t.Errorf("expected verified checksum, got %+v", e)
+	}
+
+	// Changing the file invalidates the verification.
+	if err := os.WriteFile(filepath.Join(dir, "intro.mp4"), []byte("other data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s.Checksums = false
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "intro.mp4"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	c.Update(s, data, time.Now())
+	if e := c.Get(ID("E", intro)); e.VerifiedMD5 != "" {
+		t.Errorf("expected verification to be cleared, got %+v", e)
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := Path(dir)
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() of a missing catalog returned error: %v", err)
+	}
+	s := &config.Settings{WorkDir: dir, Lang: "E", Quiet: 2}
+	c.Update(s, testData(), time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err := c.Save(path); err != nil {
+		t.Fatalf("Save() returned error: %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	if len(loaded.Media) != 2 || len(loaded.Categories) != 2 {
+		t.Fatalf("unexpected catalog contents: %+v", loaded)
+	}
+	if e := loaded.Get("E:pub-intro_VIDEO"); e == nil || e.Title != "Intro" || e.State != StateMissing {
+		t.Errorf("unexpected entry after reload: %+v", e)
+	}
+	if cat := loaded.Category("E", "Music"); cat == nil || cat.Name != "Music" {
+		t.Errorf("unexpected category after reload: %+v", cat)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary file was left behind")
+	}
+}
+
+func TestLoadRejectsNewerVersion(t *testing.T) {
+	path := Path(t.TempDir())
+	if err := os.WriteFile(path, []byte(`{"version":99,"media":[]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestUpdateReportsChanges(t *testing.T) {
+	s := &config.Settings{WorkDir: t.TempDir(), SubDir: "jwb-E", Lang: "E", Quiet: 2}
+	first := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := New()
+
+	data := testData()
+	other := &api.Category{Key: "Other", Name: "Other", Media: []*api.Media{{Name: "Elsewhere", URL: "https://cdn.example/other.mp4"}}}
+	r := c.Update(s, append(data, other), first)
+	if len(r.Added) != 0 || !reflect.DeepEqual(r.FirstRun, []FirstRun{{Lang: "E", SubDir: "jwb-E", Media: 3}}) {
+		t.Fatalf("expected only a first-run summary, got %+v", r)
+	}
+
+	// Second run without the "Other" category: the song is gone, the intro
+	// got a new file, a new media item appeared and a category was renamed.
+	data = testData()
+	intro := data[0].Media[0]
+	intro.URL = "https://cdn.example/intro_v2.mp4"
+	intro.Size = 12
+	data[0].Name = "Highlights"
+	data[1].Media = []*api.Media{intro, {Name: "New Song", GUID: "guid-new", URL: "https://cdn.example/new.mp3"}}
+
+	second := first.Add(24 * time.Hour)
+	r = c.Update(s, data, second)
+	if !r.Previous.Equal(first) || !r.Updated.Equal(second) {
+		t.Errorf("unexpected report times %v / %v", r.Previous, r.Updated)
+	}
+	if len(r.Added) != 1 || r.Added[0].Title != "New Song" {
+		t.Errorf("unexpected added media %+v", r.Added)
+	}
+	if len(r.Removed) != 1 || r.Removed[0].Title != "Song" {
+		t.Errorf("unexpected removed media %+v", r.Removed)
+	}
+	wantFields := []FieldChange{
+		{Field: "url", Old: "https://cdn.example/intro.mp4", New: "https://cdn.example/intro_v2.mp4"},
+		{Field: "size", Old: "10", New: "12"},
+	}
+	if len(r.Changed) != 1 || !reflect.DeepEqual(r.Changed[0].Fields, wantFields) {
+		t.Errorf("unexpected changed media %+v", r.Changed)
+	}
+	wantRename := []Rename{{Lang: "E", Key: "Featured", OldName: "Featured", NewName: "Highlights"}}
+	if !reflect.DeepEqual(r.RenamedCategories, wantRename) {
+		t.Errorf("unexpected renames %+v", r.RenamedCategories)
+	}
+
+	// The removal is reported once; a media item that comes back is added.
+	r = c.Update(s, data, second.Add(time.Hour))
+	if !r.Empty() {
+		t.Errorf("expected no changes, got %+v", r)
+	}
+	data[1].Media = append(data[1].Media, testData()[1].Media[0])
+	r = c.Update(s, data, second.Add(2*time.Hour))
+	if len(r.Added) != 1 || r.Added[0].Title != "Song" || !c.Get("E:guid-song").Removed.IsZero() {
+		t.Errorf("expected the song to be added again, got %+v", r.Added)
+	}
+}
+
+func TestUpdateIgnoresMediaOutsideDateWindow(t *testing.T) {
+	s := &config.Settings{WorkDir: t.TempDir(), Lang: "E", Quiet: 2}
+	data := testData()
+	data[0].Media[0].Date = 1000
+	c := New()
+	c.Update(s, data, time.Now())
+
+	// A --since run no longer lists the old intro; that is not a removal.
+	s.MinDate = 2000
+	data[0].Media = nil
+	data[1].Media = data[1].Media[:1]
+	if r := c.Update(s, data, time.Now().Add(time.Hour)); len(r.Removed) != 0 {
+		t.Errorf("expected no removals, got %+v", r.Removed)
+	}
+}
+
+func TestReportWrite(t *testing.T) {
+	r := newReport(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 1, 8, 0, 0, 0, 0, time.UTC))
+	r.Added = append(r.Added, Change{ID: "E:b", Lang: "E", Title: "B", Categories: []string{"Music"}}, Change{ID: "E:a", Lang: "E", Title: "A"})
+	r.Changed = append(r.Changed, Change{ID: "E:c", Lang: "E", Title: "C", Fields: []FieldChange{{Field: "md5", Old: "aa", New: "bb"}}})
+	r.RenamedCategories = append(r.RenamedCategories, Rename{Lang: "E", Key: "Music", OldName: "Songs", NewName: "Music"})
+
+	var text strings.Builder
+	if err := r.Write(&text, "text"); err != nil {
+		t.Fatal(err)
+	}
+	want := `Changes since 2026-01-01 00:00 UTC
+
+Added (2):
+  + [E] A
+  + [E] B (Music)
+
+Changed (1):
+  * [E] C
+      md5: aa -> bb
+
+Renamed categories (1):
+  ~ [E] Music: Songs -> Music
+`
+	if text.String() != want {
+		t.Errorf("unexpected text report:\n%s\nwant:\n%s", text.String(), want)
+	}
+
+	var out bytes.Buffer
+	if err := r.Write(&out, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("report is not valid JSON: %v", err)
+	}
+	if len(decoded.Added) != 2 || decoded.Removed == nil || decoded.Changed[0].Fields[0].New != "bb" {
+		t.Errorf("unexpected JSON report %s", out.String())
+	}
+
+	if err := r.Write(&out, "xml"); !errors.Is(err, ErrUnknownReportFormat) {
+		t.Errorf("expected ErrUnknownReportFormat, got %v", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	day := func(d int) int64 { return time.Date(2026, 1, d, 12, 0, 0, 0, time.UTC).Unix() }
+	yes, no := true, false
+	c := New()
+	c.Media = []*Entry{
+		{ID: "E:1", Lang: "E", Title: "Morning Worship: Patience", Categories: []string{"Worship"}, Date: day(1), Duration: 900, State: StateDownloaded},
+		{ID: "E:2", Lang: "E", Title: "Song 12", Categories: []string{"Music"}, Date: day(5), Duration: 180, State: StateMissing},
+		{ID: "S:2", Lang: "S", Title: "Canción 12", Categories: []string{"Music"}, Date: day(5), Duration: 180, State: StateDownloaded},
+		{ID: "E:3", Lang: "E", Title: "Old Song", Categories: []string{"Music"}, Date: day(3), Removed: time.Now()},
+	}
+
+	testCases := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"everything newest first", Query{}, []string{"S:2", "E:2", "E:1"}},
+		{"title substring", Query{Title: "worship"}, []string{"E:1"}},
+		{"title regexp", Query{TitleRegexp: regexp.MustCompile(`\b12$`)}, []string{"S:2", "E:2"}},
+		{"category and language", Query{Categories: []string{"Music"}, Langs: []string{"S"}}, []string{"S:2"}},
+		{"date range", Query{Since: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Until: time.Date(2026, 1, 4, 0, 0, 0, 0, time.UTC)}, []string{"E:1"}},
+		{"duration range", Query{MinDuration: 2 * time.Minute, MaxDuration: 5 * time.Minute}, []string{"S:2", "E:2"}},
+		{"downloaded", Query{Downloaded: &yes}, []string{"S:2", "E:1"}},
+		{"not downloaded", Query{Downloaded: &no}, []string{"E:2"}},
+		{"include removed", Query{Title: "song", IncludeRemoved: true}, []string{"E:2", "E:3"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, e := range c.Search(tc.query) {
+				got = append(got, e.ID)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOptions(t *testing.T) {
+	listing := &config.Settings{Mode: "stdout"}
+	download := &config.Settings{Mode: "stdout", Download: true}
+	if (Options{}).Enabled(listing) || !(Options{}).Enabled(download) || (Options{Disabled: true}).Enabled(download) {
+		t.Error("the catalog should only be kept for runs with a work directory of their own")
+	}
+	for _, tc := range []struct {
+		o  Options
+		ok bool
+	}{
+		{Options{}, true},
+		{Options{ReportFormat: "json", ReportFile: "report.json"}, true},
+		{Options{ReportFile: "report.json"}, false},
+		{Options{ReportFormat: "xml"}, false},
+		{Options{ReportFormat: "text", Disabled: true}, false},
+	} {
+		if err := tc.o.Check(download); (err == nil) != tc.ok {
+			t.Errorf("Check(%+v) = %v", tc.o, err)
+		}
+	}
+}
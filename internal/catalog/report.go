@@ -0,0 +1,194 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darkace1998/jw-scripts/internal/api"
+)
+
+// ErrUnknownReportFormat is returned for report formats other than "text"
+// and "json".
+var ErrUnknownReportFormat = errors.New("unknown report format")
+
+// Report lists what changed in the catalog during an update.
+//
+// Media are reported as removed when they are missing from a run that
+// indexed one of their categories and whose date filters (--since,
+// --latest) include them; media outside the indexed scope are left alone.
+// The first run for a language and subdirectory only records the media and
+// summarizes them in FirstRun instead of listing every one as added.
+type Report struct {
+	// Previous is the time of the previous update; zero for a new catalog.
+	Previous          time.Time  `json:"previous,omitzero"`
+	Updated           time.Time  `json:"updated"`
+	FirstRun          []FirstRun `json:"firstRun,omitempty"`
+	Added             []Change   `json:"added"`
+	Removed           []Change   `json:"removed"`
+	Changed           []Change   `json:"changed"`
+	RenamedCategories []Rename   `json:"renamedCategories"`
+}
+
+// FirstRun summarizes a language indexed into a subdirectory for the first
+// time.
+type FirstRun struct {
+	Lang   string `json:"lang"`
+	SubDir string `json:"subDir,omitempty"`
+	Media  int    `json:"media"`
+}
+
+// Change describes an added, removed or changed media item.
+type Change struct {
+	ID         string        `json:"id"`
+	Lang       string        `json:"lang"`
+	Title      string        `json:"title"`
+	Categories []string      `json:"categories,omitempty"`
+	URL        string        `json:"url,omitempty"`
+	Fields     []FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange is a single changed property of the chosen file.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Rename describes a category whose name changed.
+type Rename struct {
+	Lang    string `json:"lang"`
+	Key     string `json:"key"`
+	OldName string `json:"oldName"`
+	NewName string `json:"newName"`
+}
+
+func newReport(previous, now time.Time) *Report {
+	return &Report{
+		Previous:          previous,
+		Updated:           now.UTC(),
+		Added:             []Change{},
+		Removed:           []Change{},
+		Changed:           []Change{},
+		RenamedCategories: []Rename{},
+	}
+}
+
+func newChange(e *Entry) Change {
+	return Change{ID: e.ID, Lang: e.Lang, Title: e.Title, Categories: append([]string(nil), e.Categories...), URL: e.URL}
+}
+
+// diffFields compares the file recorded in e with the one chosen for media.
+// Unknown sizes and checksums are not reported as changes.
+func diffFields(e *Entry, media *api.Media) []FieldChange {
+	var fields []FieldChange
+	if e.URL != media.URL {
+		fields = append(fields, FieldChange{Field: "url", Old: e.URL, New: media.URL})
+	}
+	if e.Size != media.Size && e.Size != 0 && media.Size != 0 {
+		fields = append(fields, FieldChange{Field: "size", Old: strconv.FormatInt(e.Size, 10), New: strconv.FormatInt(media.Size, 10)})
+	}
+	if !strings.EqualFold(e.MD5, media.MD5) && e.MD5 != "" && media.MD5 != "" {
+		fields = append(fields, FieldChange{Field: "md5", Old: e.MD5, New: media.MD5})
+	}
+	return fields
+}
+
+// Empty reports whether nothing changed.
+func (r *Report) Empty() bool {
+	return len(r.FirstRun) == 0 && len(r.Added) == 0 && len(r.Removed) == 0 &&
+		len(r.Changed) == 0 && len(r.RenamedCategories) == 0
+}
+
+// CheckReportFormat returns an error unless format is a valid report
+// format for Write.
+func CheckReportFormat(format string) error {
+	switch format {
+	case "text", "json":
+		return nil
+	default:
+		return fmt.Errorf("%w %q (expected text or json)", ErrUnknownReportFormat, format)
+	}
+}
+
+// Write writes the report to w in the given format ("text" or "json").
+func (r *Report) Write(w io.Writer, format string) error {
+	if err := CheckReportFormat(format); err != nil {
+		return err
+	}
+	r.sort()
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	return r.writeText(w)
+}
+
+func (r *Report) writeText(w io.Writer) error {
+	var b strings.Builder
+	if r.Previous.IsZero() {
+		fmt.Fprintf(&b, "Changes on %s\n", r.Updated.Format("2006-01-02 15:04 MST"))
+	} else {
+		fmt.Fprintf(&b, "Changes since %s\n", r.Previous.Format("2006-01-02 15:04 MST"))
+	}
+	if r.Empty() {
+		b.WriteString("No changes.\n")
+	}
+	for _, f := range r.FirstRun {
+		fmt.Fprintf(&b, "First run for language %s: %d media recorded\n", f.Lang, f.Media)
+	}
+
+	section := func(title, marker string, changes []Change) {
+		if len(changes) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "\n%s (%d):\n", title, len(changes))
+		for _, c := range changes {
+			fmt.Fprintf(&b, "  %s [%s] %s", marker, c.Lang, c.Title)
+			if len(c.Categories) > 0 {
+				fmt.Fprintf(&b, " (%s)", strings.Join(c.Categories, ", "))
+			}
+			b.WriteString("\n")
+			for _, f := range c.Fields {
+				fmt.Fprintf(&b, "      %s: %s -> %s\n", f.Field, f.Old, f.New)
+			}
+		}
+	}
+	section("Added", "+", r.Added)
+	section("Removed", "-", r.Removed)
+	section("Changed", "*", r.Changed)
+
+	if len(r.RenamedCategories) > 0 {
+		fmt.Fprintf(&b, "\nRenamed categories (%d):\n", len(r.RenamedCategories))
+		for _, rn := range r.RenamedCategories {
+			fmt.Fprintf(&b, "  ~ [%s] %s: %s -> %s\n", rn.Lang, rn.Key, rn.OldName, rn.NewName)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// sort orders every list by language and title so reports are stable.
+func (r *Report) sort() {
+	for _, changes := range [][]Change{r.Added, r.Removed, r.Changed} {
+		sort.SliceStable(changes, func(i, j int) bool {
+			if changes[i].Lang != changes[j].Lang {
+				return changes[i].Lang < changes[j].Lang
+			}
+			return changes[i].Title < changes[j].Title
+		})
+	}
+	sort.SliceStable(r.RenamedCategories, func(i, j int) bool {
+		if r.RenamedCategories[i].Lang != r.RenamedCategories[j].Lang {
+			return r.RenamedCategories[i].Lang < r.RenamedCategories[j].Lang
+		}
+		return r.RenamedCategories[i].Key < r.RenamedCategories[j].Key
+	})
+}
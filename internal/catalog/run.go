@@ -0,0 +1,73 @@
+package catalog
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/darkace1998/jw-scripts/internal/api"
+	"github.com/darkace1998/jw-scripts/internal/config"
+)
+
+// Options are the catalog flags shared by jwb-index and jwb-music.
+type Options struct {
+	Disabled     bool   // --no-catalog
+	ReportFormat string // --report: "", "text" or "json"
+	ReportFile   string // --report-file: "" for standard output
+}
+
+// Enabled reports whether a run with the settings s keeps the catalog.
+// Stdout-only listings have no work directory of their own and leave it
+// alone.
+func (o Options) Enabled(s *config.Settings) bool {
+	return !o.Disabled && (!strings.HasPrefix(s.Mode, "stdout") || s.Download)
+}
+
+// Check validates the report options before anything is fetched.
+func (o Options) Check(s *config.Settings) error {
+	if o.ReportFormat == "" {
+		if o.ReportFile != "" {
+			return fmt.Errorf("--report-file requires --report")
+		}
+		return nil
+	}
+	if !o.Enabled(s) {
+		return fmt.Errorf("--report compares against the catalog, which is not kept with --no-catalog or for stdout listings")
+	}
+	return CheckReportFormat(o.ReportFormat)
+}
+
+// Update records the indexed languages in the catalog file of the work
+// directory of s, unless the options disable the catalog, and writes the
+// report of what changed since the previous run.
+func Update(s *config.Settings, langs []api.IndexedLanguage, o Options) error {
+	if !o.Enabled(s) {
+		return nil
+	}
+
+	report, err := Record(s, langs, time.Now())
+	if err != nil {
+		return fmt.Errorf("%w (use --no-catalog to skip the catalog)", err)
+	}
+	if s.Quiet < 1 {
+		fmt.Fprintf(os.Stderr, "catalog updated: %s\n", Path(s.WorkDir))
+	}
+	if o.ReportFormat == "" {
+		return nil
+	}
+
+	if o.ReportFile == "" {
+		return report.Write(os.Stdout, o.ReportFormat)
+	}
+	// #nosec G304 - the report file is chosen by the user
+	f, err := os.Create(o.ReportFile)
+	if err != nil {
+		return fmt.Errorf("could not create report file: %w", err)
+	}
+	if err := report.Write(f, o.ReportFormat); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
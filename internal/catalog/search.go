@@ -0,0 +1,86 @@
+package catalog
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Query selects catalog entries. Zero fields do not filter.
+type Query struct {
+	// Title matches entries whose title contains it, ignoring case.
+	Title string
+	// TitleRegexp matches entries whose title it matches.
+	TitleRegexp *regexp.Regexp
+	// Categories matches entries listed in any of these category keys.
+	Categories []string
+	// Langs matches entries in any of these languages.
+	Langs []string
+	// Since and Until bound the publication date (inclusive).
+	Since time.Time
+	Until time.Time
+	// MinDuration and MaxDuration bound the duration (inclusive).
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	// Downloaded, when set, matches entries whose local copy is (or is
+	// not) downloaded.
+	Downloaded *bool
+	// IncludeRemoved also matches entries that are no longer in the index.
+	IncludeRemoved bool
+}
+
+// Match reports whether e satisfies every filter of q.
+func (q *Query) Match(e *Entry) bool {
+	switch {
+	case !q.IncludeRemoved && !e.Removed.IsZero():
+		return false
+	case q.Title != "" && !strings.Contains(strings.ToLower(e.Title), strings.ToLower(q.Title)):
+		return false
+	case q.TitleRegexp != nil && !q.TitleRegexp.MatchString(e.Title):
+		return false
+	case len(q.Langs) > 0 && !slices.Contains(q.Langs, e.Lang):
+		return false
+	case len(q.Categories) > 0 && !slices.ContainsFunc(e.Categories, func(key string) bool { return slices.Contains(q.Categories, key) }):
+		return false
+	case q.Downloaded != nil && *q.Downloaded != (e.State == StateDownloaded):
+		return false
+	}
+
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		if e.Date == 0 {
+			return false
+		}
+		published := time.Unix(e.Date, 0)
+		if (!q.Since.IsZero() && published.Before(q.Since)) || (!q.Until.IsZero() && published.After(q.Until)) {
+			return false
+		}
+	}
+
+	duration := time.Duration(e.Duration * float64(time.Second))
+	if q.MinDuration > 0 && duration < q.MinDuration {
+		return false
+	}
+	if q.MaxDuration > 0 && (e.Duration == 0 || duration > q.MaxDuration) {
+		return false
+	}
+	return true
+}
+
+// Search returns the entries matching q, newest first.
+func (c *Catalog) Search(q Query) []*Entry {
+	var results []*Entry
+	for _, e := range c.Media {
+		if q.Match(e) {
+			results = append(results, e)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Date != results[j].Date {
+			return results[i].Date > results[j].Date
+		}
+		return results[i].Title < results[j].Title
+	})
+	return results
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"flag"
+	"os"
+)
+
+// Default service endpoints used when no override is configured.
+const (
+	DefaultAPIURL      = "https://data.jw-api.org/mediator/v1"
+	DefaultPubMediaURL = "https://b.jw-cdn.org/apis/pub-media/GETPUBMEDIALINKS"
+)
+
+// Environment variables that override the service endpoints and the
+// User-Agent header. Command-line flags take precedence over them.
+const (
+	EnvAPIURL      = "JWB_API_URL"
+	EnvPubMediaURL = "JWB_PUB_MEDIA_URL"
+	EnvUserAgent   = "JWB_USER_AGENT"
+)
+
+// EnvStorage is the environment variable holding the default of --storage,
+// the location of the media library.
+const EnvStorage = "JWB_STORAGE"
+
+// EnvDefault returns the value of the environment variable key, or fallback
+// when the variable is unset or empty. It is meant for flag defaults.
+func EnvDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// EndpointFlags registers the --api-url, --pub-media-url and --user-agent
+// flags on fs, storing their values in s. The defaults come from the JWB_*
+// environment variables. It is used by the tools built on the standard flag
+// package; the cobra commands register the same flags themselves.
+func EndpointFlags(fs *flag.FlagSet, s *Settings) {
+	fs.StringVar(&s.APIURL, "api-url", EnvDefault(EnvAPIURL, DefaultAPIURL), "base URL of the JW Broadcasting mediator API (env "+EnvAPIURL+")")
+	fs.StringVar(&s.PubMediaURL, "pub-media-url", EnvDefault(EnvPubMediaURL, DefaultPubMediaURL), "URL of the publication media API (env "+EnvPubMediaURL+")")
+	fs.StringVar(&s.UserAgent, "user-agent", EnvDefault(EnvUserAgent, ""), "User-Agent header sent with every request (env "+EnvUserAgent+")")
+}
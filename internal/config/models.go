@@ -1,40 +1,81 @@
 package config
 
+import (
+	"time"
+
+	"github.com/darkace1998/jw-scripts/internal/ratelimit"
+	"github.com/darkace1998/jw-scripts/internal/storage"
+)
+
 // Settings holds all the application settings, primarily from command-line flags.
 type Settings struct {
-	Quiet             int
-	ListLanguages     bool
-	PositionalArgs    []string
-	WorkDir           string
-	SubDir            string
-	OutputFilename    string
-	Command           []string
-	Lang              string
-	Quality           int
-	HardSubtitles     bool
-	MinDate           int64
-	MaxDate           int64
-	IncludeCategories []string
-	ExcludeCategories []string
-	FilterCategories  []string
-	PrintCategory     string
-	ListCategories    bool // flag to indicate --category with no args should list categories
-	Latest            bool
-	KeepFree          int64
-	Warning           bool
-	ImportDir         string
-	Download          bool
-	DownloadSubtitles bool
-	FriendlyFilenames bool
-	RateLimit         float64
-	Checksums         bool
-	OverwriteBad      bool
-	Append            bool
-	CleanAllSymlinks  bool
-	Update            bool
-	Mode              string
-	SafeFilenames     bool
-	Sort              string
-	AudioOnly         bool // prefer audio (MP3) files over video (MP4) files
-	WriteMetadata     bool // write JSON metadata sidecar files for downloaded files
+	Quiet                int
+	ListLanguages        bool
+	PositionalArgs       []string
+	WorkDir              string
+	SubDir               string
+	OutputFilename       string
+	Command              []string
+	Lang                 string
+	Quality              int
+	HardSubtitles        bool
+	NoHardSubtitles      bool   // never select videos with hard-coded subtitles
+	Selection            string // video selection policy (max, exact, min, nearest, smallest, largest)
+	ExplainSelection     bool   // print why each video file was selected
+	MinDate              int64
+	MaxDate              int64
+	JWBFrom              string // first JW Broadcasting issue to index (number or YYYY-MM); empty is 36 issues before JWBTo
+	JWBTo                string // last JW Broadcasting issue to index (number or YYYY-MM); empty is the current month
+	JWBFormat            string // format of the JW Broadcasting programs (mp3, mp4)
+	AudioDescriptions    bool   // keep the audio description versions of JW Broadcasting programs, in their own category
+	IncludeCategories    []string
+	ExcludeCategories    []string
+	FilterCategories     []string
+	PrintCategory        string
+	ListCategories       bool // flag to indicate --category with no args should list categories
+	Latest               bool
+	KeepFree             int64
+	Warning              bool
+	ImportDir            string
+	Download             bool
+	DownloadSubtitles    bool
+	DownloadImages       bool     // download the best-sized image of every media item and category
+	SubtitleLangs        []string // additional subtitle languages, saved as <name>.<lang>.vtt
+	SubtitleFallbackLang string   // subtitle language used when one of SubtitleLangs is unavailable
+	SubtitleFormat       string   // format downloaded subtitles are converted to (srt, ass); WebVTT only when empty
+	FriendlyFilenames    bool
+	RateLimit            float64            // megabytes/s shared by all downloads; 0 is unlimited
+	RateSchedule         ratelimit.Schedule // daily windows with their own rate, overriding RateLimit
+	Jobs                 int                // number of files downloaded in parallel
+	Segments             int                // connections used for each large download; 1 or less downloads in a single stream
+	Checksums            bool
+	OverwriteBad         bool
+	VerifyRetries        int    // times a download failing verification is quarantined and downloaded again
+	QuarantineDir        string // directory receiving downloads that fail verification; empty uses .quarantine in WorkDir
+	UpgradeQuality       bool   // replace local copies of a lower resolution than the selected file
+	Append               bool
+	CleanAllSymlinks     bool
+	Update               bool
+	Mode                 string
+	SafeFilenames        bool
+	Sort                 string
+	AudioOnly            bool            // prefer audio (MP3) files over video (MP4) files
+	WriteMetadata        bool            // write JSON metadata sidecar files for downloaded files
+	IndexWorkers         int             // number of categories fetched in parallel while indexing
+	Retries              int             // number of times a failed HTTP request is retried
+	CacheMaxAge          time.Duration   // maximum age of cached API responses; 0 disables the cache
+	Refresh              bool            // bypass the API response cache
+	APIURL               string          // base URL of the mediator API; empty uses DefaultAPIURL
+	PubMediaURL          string          // URL of the publication media API; empty uses DefaultPubMediaURL
+	UserAgent            string          // User-Agent header sent with every request; empty keeps Go's default
+	Storage              storage.Storage // where downloads, sidecars and playlists are kept; nil keeps them in WorkDir
+}
+
+// Library returns the storage of the media library: s.Storage, or the work
+// directory when it is not set.
+func (s *Settings) Library() storage.Storage {
+	if s.Storage != nil {
+		return s.Storage
+	}
+	return storage.NewLocal(s.WorkDir)
 }
@@ -2,7 +2,7 @@
 package downloader
 
 import (
-	"crypto/md5" // #nosec G501 - MD5 used for file integrity verification, not cryptographic security
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -15,7 +15,10 @@ import (
 
 	"github.com/darkace1998/jw-scripts/internal/api"
 	"github.com/darkace1998/jw-scripts/internal/config"
+	"github.com/darkace1998/jw-scripts/internal/httpclient"
 	"github.com/darkace1998/jw-scripts/internal/metadata"
+	"github.com/darkace1998/jw-scripts/internal/ratelimit"
+	"github.com/darkace1998/jw-scripts/internal/subtitle"
 	"github.com/schollz/progressbar/v3"
 )
 
@@ -26,23 +29,65 @@ var (
 	ErrMissingTimestamp = errors.New("missing timestamp")
 	// ErrCannotFreeDiskSpace is returned when disk space cannot be freed
 	ErrCannotFreeDiskSpace = errors.New("cannot free more disk space")
+	// ErrDiskLimitNeedsWorkDir is returned when a disk space limit is set
+	// for a library outside the work directory
+	ErrDiskLimitNeedsWorkDir = errors.New("the disk space limit (--free) needs the library in the work directory")
 )
 
-// DownloadAll downloads all media files.
+// httpClient is used for all file downloads. Downloads have no overall
+// timeout; the retry limit can be set per call with RequestContext.
+var httpClient = httpclient.New(0, httpclient.DefaultMaxRetries, nil)
+
+// bandwidth is the process-wide limiter every download made with a
+// RequestContext draws from, so --limit-rate caps all transfers together.
+var bandwidth = ratelimit.New(0, nil)
+
+type limiterKey struct{}
+
+// withLimiter makes DownloadFileContext draw the bytes it reads from l.
+func withLimiter(ctx context.Context, l *ratelimit.Limiter) context.Context {
+	return context.WithValue(ctx, limiterKey{}, l)
+}
+
+func limiterFrom(ctx context.Context) *ratelimit.Limiter {
+	l, _ := ctx.Value(limiterKey{}).(*ratelimit.Limiter)
+	return l
+}
+
+// RequestContext returns the context to use for downloads made on behalf of
+// s: it carries the configured retry limit and User-Agent, reports retried
+// requests unless output is suppressed, and shares the process-wide
+// bandwidth limit, which is set to the rate and schedule of s.
+func RequestContext(s *config.Settings) context.Context {
+	bandwidth.Set(s.RateLimit*ratelimit.MB, s.RateSchedule)
+	ctx := withLimiter(context.Background(), bandwidth)
+	ctx = httpclient.WithMaxRetries(ctx, s.Retries)
+	ctx = httpclient.WithUserAgent(ctx, s.UserAgent)
+	if s.Quiet < 2 {
+		ctx = httpclient.WithOnRetry(ctx, httpclient.LogRetries(os.Stderr))
+	}
+	return ctx
+}
+
+// DownloadAll downloads all media files. Files are downloaded into the
+// work directory and moved into the library at the end of the run, once
+// their metadata is written.
 func DownloadAll(s *config.Settings, data []*api.Category) error {
+	if err := CheckDiskLimit(s); err != nil {
+		return err
+	}
 	wd := filepath.Join(s.WorkDir, s.SubDir)
 	if err := os.MkdirAll(wd, 0o750); err != nil {
 		return err
 	}
+	ctx := RequestContext(s)
 
 	var mediaList []*api.Media
 	categoryOf := make(map[*api.Media]*api.Category)
 	for _, cat := range data {
-		for _, item := range cat.Contents {
-			if media, ok := item.(*api.Media); ok {
-				mediaList = append(mediaList, media)
-				categoryOf[media] = cat
-			}
+		for _, media := range cat.Media {
+			mediaList = append(mediaList, media)
+			categoryOf[media] = cat
 		}
 	}
 
@@ -50,12 +95,18 @@ func DownloadAll(s *config.Settings, data []*api.Category) error {
 		return mediaList[i].Date > mediaList[j].Date
 	})
 
-	if s.DownloadSubtitles {
+	if s.DownloadSubtitles || len(s.SubtitleLangs) > 0 {
 		if err := downloadAllSubtitles(s, mediaList, wd); err != nil {
 			return err
 		}
 	}
 
+	if s.DownloadImages {
+		if err := downloadAllImages(s, data, mediaList, wd); err != nil {
+			return err
+		}
+	}
+
 	if s.Download {
 		if s.KeepFree > 0 && s.Warning && s.Quiet < 2 {
 			fmt.Fprintf(os.Stderr, "warning: disk space limit is set: old MP4 files in %s will be DELETED when free space drops below %d MiB (disable this warning with --no-warning)\n",
@@ -77,53 +128,64 @@ func DownloadAll(s *config.Settings, data []*api.Category) error {
 
 		var downloadList []*api.Media
 		checkedFiles := make(map[string]bool)
+		upgrades := make(map[*api.Media]string)
 		for _, media := range mediaList {
-			if !checkedFiles[media.Filename] {
-				checkedFiles[media.Filename] = true
-				if !checkMedia(s, media, wd) {
-					downloadList = append(downloadList, media)
-				}
+			if checkedFiles[media.Filename] {
+				continue
 			}
-		}
+			checkedFiles[media.Filename] = true
 
-		for i, media := range downloadList {
-			if s.KeepFree > 0 {
-				if err := diskCleanup(s, wd, media); err != nil {
-					if err == ErrDiskLimitReached || err == ErrMissingTimestamp {
-						if s.Quiet < 2 {
-							fmt.Fprintf(os.Stderr, "low disk space and missing metadata, skipping: %s\n", media.Name)
-						}
-						continue
+			if old, lower := localVariant(ctx, s, media, wd); old != "" {
+				if lower && s.UpgradeQuality {
+					upgrades[media] = old
+					downloadList = append(downloadList, media)
+					continue
+				}
+				if old == filepath.Join(wd, media.Filename) {
+					// Another variant, not a broken download: it is kept,
+					// and recorded as the local copy, until it is upgraded
+					if lower && s.Quiet < 2 {
+						fmt.Fprintf(os.Stderr, "local copy of %s is %s, %s is selected (use --upgrade-quality to replace it)\n", media.Name, media.LocalLabel, media.Label)
 					}
-					return err
+					continue
 				}
+				// The selected variant has its own name, so it is downloaded
+				// next to the old one, which only --upgrade-quality removes
 			}
 
-			if s.Quiet < 2 {
-				fmt.Fprintf(os.Stderr, "[%d/%d] ", i+1, len(downloadList))
-			}
-			if err := downloadMedia(s, media, wd); err != nil {
-				if s.Quiet < 2 {
-					fmt.Fprintf(os.Stderr, "download failed for %s: %v\n", media.Name, err)
+			if !checkMedia(s, media, wd) {
+				downloadList = append(downloadList, media)
+			} else if media.LocalLabel == "" && media.Size > 0 && !s.WriteMetadata {
+				// The variant of files from before labels were recorded
+				// is known when the size matches the selected file.
+				if fi, err := Stat(ctx, s, filepath.Join(wd, media.Filename)); err == nil && fi.Size == media.Size {
+					media.LocalFilename, media.LocalLabel = media.Filename, media.Label
 				}
 			}
 		}
+
+		if err := downloadMediaList(s, downloadList, upgrades, wd); err != nil {
+			return err
+		}
 	}
 
 	if s.WriteMetadata {
 		writeAllMetadata(s, mediaList, categoryOf, wd)
 	}
 
-	return nil
+	return publishAll(s, wd)
 }
 
 // writeAllMetadata embeds metadata into every media file that exists
 // locally (ID3v2 tags for MP3, iTunes-style atoms for MP4). Formats that
 // cannot carry embedded tags, or files that fail to embed, get a JSON
-// sidecar file instead. Embedding is idempotent, so unchanged files are not
-// rewritten on subsequent runs. Failures are reported but never abort the
-// run.
+// sidecar file instead, as do media with details that tags cannot carry.
+// Embedding is idempotent, so unchanged files are not rewritten on
+// subsequent runs. With a library outside the work directory, only the
+// files downloaded in this run are still local. Failures are reported but
+// never abort the run.
 func writeAllMetadata(s *config.Settings, mediaList []*api.Media, categoryOf map[*api.Media]*api.Category, directory string) {
+	ctx := RequestContext(s)
 	if s.Quiet < 1 {
 		fmt.Fprintln(os.Stderr, "writing metadata")
 	}
@@ -143,9 +205,18 @@ func writeAllMetadata(s *config.Settings, mediaList []*api.Media, categoryOf map
 
 		meta := metadata.FromMedia(s.Lang, categoryOf[media], media)
 		if err := metadata.Embed(path, meta); err == nil {
-			// Remove any sidecar left over from earlier versions that wrote
-			// JSON files instead of embedding.
-			_ = os.Remove(metadata.SidecarPath(directory, media.Filename))
+			if meta.NeedsSidecar() {
+				// The description, images and references to other
+				// languages cannot be embedded, so they are kept in a
+				// sidecar next to the tagged file.
+				if err := metadata.Write(directory, media.Filename, meta); err != nil && s.Quiet < 2 {
+					fmt.Fprintf(os.Stderr, "failed to write metadata for %s: %v\n", media.Filename, err)
+				}
+			} else {
+				// Remove any sidecar left over from earlier versions that
+				// wrote JSON files instead of embedding.
+				_ = Remove(ctx, s, metadata.SidecarPath(directory, media.Filename))
+			}
 			count++
 		} else {
 			if !errors.Is(err, metadata.ErrUnsupportedFormat) && s.Quiet < 2 {
@@ -166,146 +237,267 @@ func writeAllMetadata(s *config.Settings, mediaList []*api.Media, categoryOf map
 	}
 }
 
+// downloadAllSubtitles downloads the subtitles of the chosen files with
+// --download-subtitles and the language-suffixed subtitles found for
+// --subtitle-langs, skipping files that already exist unless --fix-broken
+// is set. With --subtitle-format every subtitle is also converted, next to
+// the WebVTT file, when the converted file is missing or the WebVTT file
+// was just downloaded.
 func downloadAllSubtitles(s *config.Settings, mediaList []*api.Media, directory string) error {
 	if err := os.MkdirAll(directory, 0o750); err != nil {
 		return err
 	}
+	ctx := RequestContext(s)
 
-	var queue []*api.Media
+	type subtitleFile struct{ url, filename string }
+	var all, queue []subtitleFile
+	queued := make(map[string]bool)
+	add := func(subtitleURL, filename string) {
+		if subtitleURL == "" || filename == "" || queued[filename] {
+			return
+		}
+		queued[filename] = true
+		all = append(all, subtitleFile{subtitleURL, filename})
+		if s.OverwriteBad || !exists(ctx, s, filepath.Join(directory, filename)) {
+			queue = append(queue, subtitleFile{subtitleURL, filename})
+		}
+	}
 	for _, media := range mediaList {
-		if media.SubtitleURL != "" {
-			subtitlePath := filepath.Join(directory, media.SubtitleFilename)
-			if s.OverwriteBad || !fileExists(subtitlePath) {
-				queue = append(queue, media)
-			}
+		if s.DownloadSubtitles {
+			add(media.SubtitleURL, media.SubtitleFilename)
+		}
+		for _, sub := range media.Subtitles {
+			add(sub.URL, sub.Filename)
 		}
 	}
 
-	for i, media := range queue {
+	downloaded := make(map[string]bool)
+	for i, sub := range queue {
 		if s.Quiet < 2 {
-			fmt.Fprintf(os.Stderr, "[%d/%d] downloading: %s\n", i+1, len(queue), media.SubtitleFilename)
-		}
-		// Download to a temporary file and rename on success so a failed
-		// download never leaves a truncated subtitle file behind that would
-		// be treated as complete on the next run.
-		subtitlePath := filepath.Join(directory, media.SubtitleFilename)
-		tmpPath := subtitlePath + ".part"
-		if err := DownloadFile(media.SubtitleURL, tmpPath, false, 0); err != nil {
+			fmt.Fprintf(os.Stderr, "[%d/%d] downloading: %s\n", i+1, len(queue), sub.filename)
+		}
+		if err := downloadWhole(s, sub.url, filepath.Join(directory, sub.filename)); err != nil {
 			if s.Quiet < 2 {
-				fmt.Fprintf(os.Stderr, "failed to download subtitle %s: %v\n", media.SubtitleFilename, err)
-			}
-			if removeErr := os.Remove(tmpPath); removeErr != nil && !os.IsNotExist(removeErr) && s.Quiet < 2 {
-				fmt.Fprintf(os.Stderr, "failed to clean up partial file %s: %v\n", tmpPath, removeErr)
+				fmt.Fprintf(os.Stderr, "failed to download subtitle %s: %v\n", sub.filename, err)
 			}
 			continue
 		}
-		if err := os.Rename(tmpPath, subtitlePath); err != nil {
-			if s.Quiet < 2 {
-				fmt.Fprintf(os.Stderr, "failed to finalize subtitle %s: %v\n", media.SubtitleFilename, err)
-			}
+		downloaded[sub.filename] = true
+	}
+
+	if s.SubtitleFormat == "" {
+		return nil
+	}
+	format, err := subtitle.ParseFormat(s.SubtitleFormat)
+	if err != nil {
+		return err
+	}
+	if format == subtitle.VTT {
+		return nil
+	}
+	for _, sub := range all {
+		vttPath := filepath.Join(directory, sub.filename)
+		convertedPath := filepath.Join(directory, subtitle.Filename(sub.filename, format))
+		if !exists(ctx, s, vttPath) || (exists(ctx, s, convertedPath) && !downloaded[sub.filename]) {
+			continue
+		}
+		if err := convertSubtitle(ctx, s, vttPath, convertedPath, format); err != nil && s.Quiet < 2 {
+			fmt.Fprintf(os.Stderr, "failed to convert subtitle %s: %v\n", sub.filename, err)
 		}
 	}
 
 	return nil
 }
 
-func checkMedia(s *config.Settings, media *api.Media, directory string) bool {
-	file := filepath.Join(directory, media.Filename)
-	if !fileExists(file) {
-		return false
+// convertSubtitle converts the WebVTT file at vttPath, read from the work
+// directory or the library, to format f. The result is written to a
+// ".part" file first and renamed into place.
+func convertSubtitle(ctx context.Context, s *config.Settings, vttPath, path string, f subtitle.Format) error {
+	in, err := Open(ctx, s, vttPath)
+	if err != nil {
+		return err
 	}
+	defer func() { _ = in.Close() }()
 
-	if s.OverwriteBad {
-		fi, err := os.Stat(file)
-		if err != nil {
-			return false
+	tmpPath := path + ".part"
+	// #nosec G304 - path is constructed from the work directory and sanitized filenames
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	err = subtitle.Convert(in, out, f)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("could not finalize conversion: %w", err)
+	}
+	return nil
+}
+
+// downloadAllImages downloads the image of every category and media item
+// that has one, skipping images that already exist unless --fix-broken is
+// set. Categories come first, then media in the given order.
+func downloadAllImages(s *config.Settings, data []*api.Category, mediaList []*api.Media, directory string) error {
+	if err := os.MkdirAll(directory, 0o750); err != nil {
+		return err
+	}
+	ctx := RequestContext(s)
+
+	type image struct{ url, filename string }
+	var queue []image
+	queued := make(map[string]bool)
+	add := func(imageURL, filename string) {
+		if imageURL == "" || filename == "" || queued[filename] {
+			return
+		}
+		queued[filename] = true
+		if s.OverwriteBad || !exists(ctx, s, filepath.Join(directory, filename)) {
+			queue = append(queue, image{imageURL, filename})
 		}
+	}
+	for _, cat := range data {
+		add(cat.ImageURL, cat.ImageFilename)
+	}
+	for _, media := range mediaList {
+		add(media.ImageURL, media.ImageFilename)
+	}
 
-		if media.Size > 0 {
-			if s.WriteMetadata {
-				// Embedded metadata grows files beyond the size reported by
-				// the API, so only treat files smaller than the original
-				// download as broken.
-				if fi.Size() < media.Size {
-					if s.Quiet < 2 {
-						fmt.Fprintf(os.Stderr, "size mismatch: %s\n", file)
-					}
-					return false
-				}
-			} else if fi.Size() != media.Size {
-				if s.Quiet < 2 {
-					fmt.Fprintf(os.Stderr, "size mismatch: %s\n", file)
-				}
-				return false
-			}
+	for i, img := range queue {
+		if s.Quiet < 2 {
+			fmt.Fprintf(os.Stderr, "[%d/%d] downloading: %s\n", i+1, len(queue), img.filename)
+		}
+		if err := downloadWhole(s, img.url, filepath.Join(directory, img.filename)); err != nil && s.Quiet < 2 {
+			fmt.Fprintf(os.Stderr, "failed to download image %s: %v\n", img.filename, err)
 		}
+	}
 
-		// Embedded metadata changes the file contents, so the API checksum
-		// can no longer match; skip checksum verification in that case.
-		if s.Checksums && media.MD5 != "" && !s.WriteMetadata {
-			ok, err := CheckMD5(file, media.MD5)
-			if err != nil || !ok {
-				if s.Quiet < 2 {
-					fmt.Fprintf(os.Stderr, "checksum mismatch: %s\n", file)
-				}
-				return false
-			}
+	return nil
+}
+
+// downloadWhole downloads a small file such as a subtitle or an image. The
+// data is written to a temporary ".part" file that is renamed on success, so
+// a failed download never leaves a truncated file behind that would be
+// treated as complete on the next run. A partial file is kept for the next
+// run only when it can be resumed safely (see DownloadFile).
+func downloadWhole(s *config.Settings, rawURL, path string) error {
+	tmpPath := path + ".part"
+	if err := DownloadFileContext(RequestContext(s), rawURL, tmpPath, true, 0); err != nil {
+		if fileExists(tmpPath + validatorSuffix) {
+			return err
+		}
+		if removeErr := os.Remove(tmpPath); removeErr != nil && !os.IsNotExist(removeErr) && s.Quiet < 2 {
+			fmt.Fprintf(os.Stderr, "failed to clean up partial file %s: %v\n", tmpPath, removeErr)
 		}
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("could not finalize download: %w", err)
 	}
+	return nil
+}
 
-	return true
+// checkMedia reports whether media is already in directory or the library.
+// With --fix-broken the file must also have the expected size (and checksum
+// with --checksum); a broken file is moved to the quarantine directory so
+// it is downloaded again.
+func checkMedia(s *config.Settings, media *api.Media, directory string) bool {
+	ctx := RequestContext(s)
+	file := filepath.Join(directory, media.Filename)
+	fi, err := Stat(ctx, s, file)
+	if err != nil {
+		return false
+	}
+	if !s.OverwriteBad {
+		return true
+	}
+
+	reason := ""
+	if media.Size > 0 {
+		// Embedded metadata grows files beyond the size reported by the
+		// API, so only treat files smaller than the original download as
+		// broken in that case.
+		if fi.Size < media.Size || (!s.WriteMetadata && fi.Size != media.Size) {
+			reason = "size mismatch"
+		}
+	}
+	// Embedded metadata changes the file contents, so the API checksum
+	// can no longer match; skip checksum verification in that case.
+	if reason == "" && s.Checksums && media.MD5 != "" && !s.WriteMetadata {
+		if ok, err := CheckStoredMD5(ctx, s, file, media.MD5); err != nil || !ok {
+			reason = "checksum mismatch"
+		}
+	}
+	if reason == "" {
+		return true
+	}
+
+	if s.Quiet < 2 {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", reason, file)
+	}
+	// A broken file in the library is fetched and quarantined locally
+	err = fetchFile(ctx, s, file)
+	if err == nil {
+		_, err = Quarantine(QuarantineDir(s), file, media.Filename, media.URL, reason)
+	}
+	if st, name, ok := libraryName(s, file); err == nil && ok {
+		err = st.Remove(ctx, name)
+	}
+	if err != nil && s.Quiet < 2 {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	return false
 }
 
-func downloadMedia(s *config.Settings, media *api.Media, directory string) error {
+// downloadMedia downloads media into directory through a ".part" file,
+// resuming an earlier partial download. With settings.Segments, large files
+// are downloaded over several connections. Every download is verified
+// (see VerifyFile); a file failing verification is moved to the quarantine
+// directory and downloaded again up to settings.VerifyRetries times, after
+// which its *VerificationError is returned. Messages go to out, starting
+// with prefix.
+func downloadMedia(ctx context.Context, s *config.Settings, media *api.Media, directory string, out *console, prefix string) error {
 	file := filepath.Join(directory, media.Filename)
 	tmpFile := file + ".part"
 
-	if fileExists(tmpFile) {
+	for attempt := 0; ; attempt++ {
+		action := "downloading"
+		if fileExists(tmpFile) {
+			action = "resuming"
+		}
 		if s.Quiet < 2 {
-			fmt.Fprintf(os.Stderr, "resuming: %s (%s)\n", media.Filename, media.Name)
+			out.Printf("%s%s: %s (%s)\n", prefix, action, media.Filename, media.Name)
 		}
-		if err := DownloadFile(media.URL, tmpFile, true, s.RateLimit); err != nil {
-			return err
+		var err error
+		if s.Segments > 1 {
+			// Verified by DownloadFileSegmented
+			err = DownloadFileSegmented(ctx, media.URL, tmpFile, s.Segments, media.Size, media.MD5)
+		} else if err = DownloadFileContext(ctx, media.URL, tmpFile, true, 0); err == nil {
+			err = VerifyFile(tmpFile, media.Filename, media.Size, media.MD5)
 		}
-
-		// Validate resumed download if we have expected file size or checksum
-		if media.Size > 0 || (s.Checksums && media.MD5 != "") {
-			fi, err := os.Stat(tmpFile)
-			if err == nil {
-				// Check file size if available
-				if media.Size > 0 && fi.Size() != media.Size {
-					if s.Quiet < 2 {
-						fmt.Fprintf(os.Stderr, "resumed download size mismatch, restarting: %s\n", media.Filename)
-					}
-					if err := os.Remove(tmpFile); err != nil {
-						return err
-					}
-					if err := DownloadFile(media.URL, tmpFile, false, s.RateLimit); err != nil {
-						return err
-					}
-				} else if s.Checksums && media.MD5 != "" {
-					// Verify checksum if enabled and available
-					if ok, err := CheckMD5(tmpFile, media.MD5); err == nil && !ok {
-						if s.Quiet < 2 {
-							fmt.Fprintf(os.Stderr, "resumed download checksum mismatch, restarting: %s\n", media.Filename)
-						}
-						if err := os.Remove(tmpFile); err != nil {
-							return err
-						}
-						if err := DownloadFile(media.URL, tmpFile, false, s.RateLimit); err != nil {
-							return err
-						}
-					}
-				}
+		var verr *VerificationError
+		if !errors.As(err, &verr) {
+			if err != nil {
+				return err
 			}
+			break
+		}
+
+		dest, qerr := Quarantine(QuarantineDir(s), tmpFile, media.Filename, media.URL, verr.Reason)
+		if qerr != nil {
+			return errors.Join(err, qerr)
 		}
-	} else {
 		if s.Quiet < 2 {
-			fmt.Fprintf(os.Stderr, "downloading: %s (%s)\n", media.Filename, media.Name)
+			out.Printf("%s failed verification (%s), moved to %s\n", media.Filename, verr.Reason, dest)
 		}
-		if err := DownloadFile(media.URL, tmpFile, false, s.RateLimit); err != nil {
+		if attempt >= s.VerifyRetries {
 			return err
 		}
+		prefix = fmt.Sprintf("retry %d/%d: ", attempt+1, s.VerifyRetries)
 	}
 
 	if media.Date > 0 {
@@ -318,8 +510,56 @@ func downloadMedia(s *config.Settings, media *api.Media, directory string) error
 	return os.Rename(tmpFile, file)
 }
 
-// DownloadFile downloads a file from a URL to a specified path.
+// localVariant returns the path of the local copy of media when it is
+// known to be another variant than the selected file, and whether it has
+// a lower resolution.
+func localVariant(ctx context.Context, s *config.Settings, media *api.Media, directory string) (path string, lower bool) {
+	if media.LocalLabel == "" || media.LocalFilename == "" || media.LocalLabel == media.Label {
+		return "", false
+	}
+	path = filepath.Join(directory, media.LocalFilename)
+	if !exists(ctx, s, path) {
+		return "", false
+	}
+	local, selected := api.Resolution(media.LocalLabel), api.Resolution(media.Label)
+	return path, local > 0 && local < selected
+}
+
+// removeReplaced removes the local copy old after an upgraded variant has
+// been renamed into place at path. When both have the same name, the
+// rename already replaced it atomically; otherwise the old file and its
+// metadata sidecar are removed only now, from the work directory and the
+// library, so there is always a complete copy.
+func removeReplaced(ctx context.Context, s *config.Settings, old, path string, out *console) {
+	if old == path {
+		return
+	}
+	for _, p := range []string{old, metadata.SidecarPath(filepath.Dir(old), filepath.Base(old))} {
+		if err := Remove(ctx, s, p); err != nil && s.Quiet < 2 {
+			out.Printf("could not remove replaced file %s: %v\n", p, err)
+		}
+	}
+}
+
+// DownloadFile downloads a file from a URL to a specified path. A positive
+// rateLimit (megabytes/s) limits this download on its own.
+//
+// With resume, an existing partial file at path is continued with a range
+// request, provided the ETag or Last-Modified date of the response it was
+// started from was recorded next to it (path+".validator"). The request
+// carries an If-Range header, so a file that changed on the server is
+// downloaded again from the start instead of being spliced; partial files
+// without a recorded validator are restarted as well.
 func DownloadFile(rawURL, path string, resume bool, rateLimit float64) error {
+	return DownloadFileContext(context.Background(), rawURL, path, resume, rateLimit)
+}
+
+// DownloadFileContext is like DownloadFile but uses ctx for the request, so
+// callers can cancel the download or adjust its retry behaviour (see
+// RequestContext). A context from RequestContext also subjects the download
+// to the shared bandwidth limit. Transient failures before the transfer
+// starts are retried.
+func DownloadFileContext(ctx context.Context, rawURL, path string, resume bool, rateLimit float64) error {
 	parsedURL, err := url.Parse(rawURL)
 	if err != nil {
 		return fmt.Errorf("invalid URL: %w", err)
@@ -328,22 +568,27 @@ func DownloadFile(rawURL, path string, resume bool, rateLimit float64) error {
 		return fmt.Errorf("unsupported URL scheme: %s", parsedURL.Scheme)
 	}
 
-	req, err := http.NewRequest("GET", parsedURL.String(), http.NoBody)
+	req, err := http.NewRequestWithContext(ctx, "GET", parsedURL.String(), http.NoBody)
 	if err != nil {
 		return err
 	}
 
-	var start int64
+	var (
+		start    int64
+		recorded validator
+	)
 	if resume {
 		fi, err := os.Stat(path)
-		if err == nil {
-			start = fi.Size()
+		v, ok := loadValidator(path)
+		if err == nil && fi.Size() > 0 && ok && v.URL == rawURL && v.ifRange() != "" {
+			start, recorded = fi.Size(), v
 			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+			req.Header.Set("If-Range", v.ifRange())
 		}
 	}
 
 	// #nosec G704 - URL scheme is validated above to only allow http/https; this is a legitimate file download
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -353,8 +598,15 @@ func DownloadFile(rawURL, path string, resume bool, rateLimit float64) error {
 		return fmt.Errorf("bad status: %s", resp.Status)
 	}
 
+	if start > 0 && resp.StatusCode == http.StatusPartialContent && !recorded.matches(resp.Header) {
+		// The server ignored If-Range and sent part of another version
+		_ = resp.Body.Close()
+		removeValidator(path)
+		return DownloadFileContext(ctx, rawURL, path, false, rateLimit)
+	}
+
 	var out *os.File
-	if resume && resp.StatusCode == http.StatusPartialContent {
+	if start > 0 && resp.StatusCode == http.StatusPartialContent {
 		// Server supports range requests; append to existing file
 		// #nosec G304 - Path is from download logic for legitimate file operations
 		out, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
@@ -363,6 +615,9 @@ func DownloadFile(rawURL, path string, resume bool, rateLimit float64) error {
 		start = 0
 		// #nosec G304 - Path is from download logic for legitimate file operations
 		out, err = os.Create(path)
+		if err == nil {
+			err = saveValidator(path, validatorOf(rawURL, resp.Header))
+		}
 	}
 	if err != nil {
 		return err
@@ -375,7 +630,38 @@ func DownloadFile(rawURL, path string, resume bool, rateLimit float64) error {
 		size = -1
 	}
 
-	bar := progressbar.NewOptions64(
+	var progress io.Writer
+	if report := progressFrom(ctx); report != nil {
+		progress = &progressWriter{report: report, written: start, total: size}
+		report(start, size)
+	} else {
+		bar := newProgressBar(size)
+		if err := bar.Add64(start); err != nil {
+			// Log error but continue - progress bar errors shouldn't stop download
+			fmt.Fprintf(os.Stderr, "Progress bar error: %v\n", err)
+		}
+		progress = bar
+	}
+
+	var body io.Reader = resp.Body
+	if l := limiterFrom(ctx); l != nil {
+		body = l.Reader(ctx, body)
+	}
+	if rateLimit > 0 {
+		body = newThrottledReader(body, rateLimit)
+	}
+
+	if _, err := io.Copy(io.MultiWriter(out, progress), body); err != nil {
+		return err
+	}
+	removeValidator(path)
+	return nil
+}
+
+// newProgressBar returns the progress bar of a single download of size
+// bytes (-1 when unknown).
+func newProgressBar(size int64) *progressbar.ProgressBar {
+	return progressbar.NewOptions64(
 		size,
 		progressbar.OptionSetDescription("downloading"),
 		progressbar.OptionSetWriter(os.Stderr),
@@ -387,18 +673,6 @@ func DownloadFile(rawURL, path string, resume bool, rateLimit float64) error {
 		progressbar.OptionSpinnerType(14),
 		progressbar.OptionFullWidth(),
 	)
-	if err := bar.Add64(start); err != nil {
-		// Log error but continue - progress bar errors shouldn't stop download
-		fmt.Fprintf(os.Stderr, "Progress bar error: %v\n", err)
-	}
-
-	var body io.Reader = resp.Body
-	if rateLimit > 0 {
-		body = newThrottledReader(body, rateLimit)
-	}
-
-	_, err = io.Copy(io.MultiWriter(out, bar), body)
-	return err
 }
 
 // CheckMD5 calculates the MD5 checksum of a file and compares it to the expected checksum.
@@ -411,16 +685,24 @@ func CheckMD5(path, expectedMD5 string) (bool, error) {
 		return false, err
 	}
 	defer func() { _ = f.Close() }()
+	return readerMD5(f, expectedMD5)
+}
 
-	h := md5.New() // #nosec G401 - MD5 used for file integrity verification, not cryptographic security
-	if _, err := io.Copy(h, f); err != nil {
-		return false, err
+// CheckDiskLimit returns ErrDiskLimitNeedsWorkDir when settings.KeepFree is
+// set while the library is not the work directory: diskCleanup only frees
+// space there, and finished files are moved out of it.
+func CheckDiskLimit(s *config.Settings) error {
+	if s.KeepFree > 0 && !libraryInWorkDir(s) {
+		return fmt.Errorf("%w, not %s", ErrDiskLimitNeedsWorkDir, s.Library())
 	}
-
-	return fmt.Sprintf("%x", h.Sum(nil)) == expectedMD5, nil
+	return nil
 }
 
-func diskCleanup(s *config.Settings, directory string, referenceMedia *api.Media) error {
+// diskCleanup removes the oldest MP4 files in directory until there is
+// room for the needed bytes that referenceMedia and the other downloads in
+// progress still have to write, plus settings.KeepFree, but never files
+// newer than referenceMedia. Messages go to out.
+func diskCleanup(s *config.Settings, directory string, referenceMedia *api.Media, needed int64, out *console) error {
 	if s.KeepFree == 0 || referenceMedia.Size == 0 {
 		return nil
 	}
@@ -435,19 +717,19 @@ func diskCleanup(s *config.Settings, directory string, referenceMedia *api.Media
 			return err
 		}
 
-		needed := referenceMedia.Size + s.KeepFree
-		if needed < 0 {
-			// Integer overflow detected: referenceMedia.Size + s.KeepFree exceeded int64 max value
+		limit := needed + s.KeepFree
+		if limit < 0 {
+			// Integer overflow detected: the sum exceeded int64 max value
 			// This can happen with very large file sizes on 32-bit systems
 			// Skip the disk space check to avoid incorrect behavior
 			break
 		}
-		if free > uint64(needed) {
+		if free > uint64(limit) {
 			break
 		}
 
 		if s.Quiet < 1 {
-			fmt.Fprintf(os.Stderr, "free space: %d MiB, needed: %d MiB\n", free/(1024*1024), needed/(1024*1024))
+			out.Printf("free space: %d MiB, needed: %d MiB\n", free/(1024*1024), limit/(1024*1024))
 		}
 
 		if referenceMedia.Date == 0 {
@@ -464,7 +746,7 @@ func diskCleanup(s *config.Settings, directory string, referenceMedia *api.Media
 		}
 
 		if s.Quiet < 2 {
-			fmt.Fprintf(os.Stderr, "removing old video: %s\n", oldest.Name())
+			out.Printf("removing old video: %s\n", oldest.Name())
 		}
 		if err := os.Remove(filepath.Join(directory, oldest.Name())); err != nil {
 			return err
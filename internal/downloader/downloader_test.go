@@ -1,10 +1,29 @@
 package downloader
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5" // #nosec G501 - MD5 matches the checksums of the API
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/darkace1998/jw-scripts/internal/api"
+	"github.com/darkace1998/jw-scripts/internal/config"
+	"github.com/darkace1998/jw-scripts/internal/ratelimit"
+	"github.com/darkace1998/jw-scripts/internal/storage"
+	"github.com/darkace1998/jw-scripts/internal/testutil"
 )
 
 const (
@@ -194,3 +213,809 @@ func TestThrottledReaderRestart(t *testing.T) {
 
 	t.Logf("Multi-read test: %.3f MB/s (target 0.1 MB/s)", actualRate)
 }
+
+func TestDownloadFileRetriesTransientErrors(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte("video data"))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	ctx := RequestContext(&config.Settings{Quiet: 2, Retries: 2})
+	if err := DownloadFileContext(ctx, server.URL+"/video.mp4", path, false, 0); err != nil {
+		t.Fatalf("DownloadFileContext() returned error: %v", err)
+	}
+
+	// #nosec G304 - path is constrained to t.TempDir() in this test
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); string(data) != "video data" || n != 2 {
+		t.Errorf("expected retried download, got %q after %d requests", data, n)
+	}
+}
+
+func TestDownloadFileHonoursRetryLimit(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	ctx := RequestContext(&config.Settings{Quiet: 2, Retries: 0})
+	if err := DownloadFileContext(ctx, server.URL+"/video.mp4", path, false, 0); err == nil {
+		t.Fatal("expected error for unavailable server")
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("expected a single request with retries disabled, got %d", n)
+	}
+}
+
+func TestDownloadAllImages(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if r.URL.Path == "/broken.jpg" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("image " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	wd := filepath.Join(dir, "jwb-E")
+	if err := os.MkdirAll(wd, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(wd, "existing.jpg"), []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	data := []*api.Category{{
+		Key:           "VideoOnDemand",
+		ImageURL:      server.URL + "/category.jpg",
+		ImageFilename: "VideoOnDemand.jpg",
+		Media: []*api.Media{
+			{Filename: "intro.mp4", ImageURL: server.URL + "/intro.jpg", ImageFilename: "intro.jpg"},
+			{Filename: "existing.mp4", ImageURL: server.URL + "/existing.jpg", ImageFilename: "existing.jpg"},
+			{Filename: "broken.mp4", ImageURL: server.URL + "/broken.jpg", ImageFilename: "broken.jpg"},
+			{Filename: "plain.mp4"},
+		},
+	}}
+
+	s := &config.Settings{WorkDir: dir, SubDir: "jwb-E", Quiet: 2, DownloadImages: true}
+	if err := DownloadAll(s, data); err != nil {
+		t.Fatalf("DownloadAll() returned error: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"VideoOnDemand.jpg": "image /category.jpg",
+		"intro.jpg":         "image /intro.jpg",
+		"existing.jpg":      "old",
+	} {
+		// #nosec G304 - path is constrained to t.TempDir() in this test
+		got, err := os.ReadFile(filepath.Join(wd, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q (%v), want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"broken.jpg", "broken.jpg.part", "intro.jpg.part"} {
+		if _, err := os.Stat(filepath.Join(wd, name)); !os.IsNotExist(err) {
+			t.Errorf("did not expect %s to exist", name)
+		}
+	}
+	if strings.Contains(strings.Join(requests, ","), "/existing.jpg") {
+		t.Error("existing image was downloaded again")
+	}
+}
+
+func TestDownloadAllSubtitlesInSeveralLanguages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("WEBVTT " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	data := []*api.Category{{
+		Key: "VideoOnDemand",
+		Media: []*api.Media{{
+			Filename:         "intro.mp4",
+			SubtitleURL:      server.URL + "/intro_E.vtt",
+			SubtitleFilename: "intro.vtt",
+			Subtitles: []api.Subtitle{
+				{Lang: "S", URL: server.URL + "/intro_S.vtt", Filename: "intro.S.vtt"},
+				{Lang: "F", URL: server.URL + "/intro_F.vtt", Filename: "intro.F.vtt"},
+			},
+		}},
+	}}
+
+	s := &config.Settings{WorkDir: dir, SubDir: "jwb-E", Quiet: 2, SubtitleLangs: []string{"S", "F"}}
+	if err := DownloadAll(s, data); err != nil {
+		t.Fatalf("DownloadAll() returned error: %v", err)
+	}
+
+	wd := filepath.Join(dir, "jwb-E")
+	for name, want := range map[string]string{
+		"intro.S.vtt": "WEBVTT /intro_S.vtt",
+		"intro.F.vtt": "WEBVTT /intro_F.vtt",
+	} {
+		// #nosec G304 - path is constrained to t.TempDir() in this test
+		got, err := os.ReadFile(filepath.Join(wd, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q (%v), want %q", name, got, err, want)
+		}
+	}
+	// Without --download-subtitles the indexing language is not fetched.
+	if _, err := os.Stat(filepath.Join(wd, "intro.vtt")); !os.IsNotExist(err) {
+		t.Error("did not expect intro.vtt to exist")
+	}
+}
+
+func TestDownloadAllConvertsSubtitles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("WEBVTT\n\n00:01.000 --> 00:02.000\n<i>Hello</i>\n"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	wd := filepath.Join(dir, "jwb-E")
+	if err := os.MkdirAll(wd, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	// An existing subtitle without a converted copy is converted as well.
+	if err := os.WriteFile(filepath.Join(wd, "Existing.vtt"), []byte("WEBVTT\n\n00:03.000 --> 00:04.000\nOld\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	data := []*api.Category{{
+		Key: "VideoOnDemand",
+		Media: []*api.Media{
+			{Filename: "Intro (1).mp4", SubtitleURL: server.URL + "/intro.vtt", SubtitleFilename: "Intro (1).vtt"},
+			{Filename: "Existing.mp4", SubtitleURL: server.URL + "/existing.vtt", SubtitleFilename: "Existing.vtt"},
+		},
+	}}
+
+	s := &config.Settings{WorkDir: dir, SubDir: "jwb-E", Quiet: 2, DownloadSubtitles: true, SubtitleFormat: "srt"}
+	if err := DownloadAll(s, data); err != nil {
+		t.Fatalf("DownloadAll() returned error: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"Intro (1).srt": "1\n00:00:01,000 --> 00:00:02,000\n<i>Hello</i>\n",
+		"Existing.srt":  "1\n00:00:03,000 --> 00:00:04,000\nOld\n",
+	} {
+		// #nosec G304 - path is constrained to t.TempDir() in this test
+		got, err := os.ReadFile(filepath.Join(wd, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q (%v), want %q", name, got, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(wd, "Intro (1).vtt")); err != nil {
+		t.Errorf("expected the WebVTT file to be kept: %v", err)
+	}
+}
+
+func TestDownloadAllUpgradesQuality(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(testutil.MP4("video " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	wd := filepath.Join(dir, "jwb-E")
+	if err := os.MkdirAll(wd, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a_480p.mp4", "a_480p.mp4.json", "Friendly.mp4", "c_720p.mp4"} {
+		if err := os.WriteFile(filepath.Join(wd, name), []byte("old"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newData := func() []*api.Category {
+		return []*api.Category{{Key: "VideoOnDemand", Media: []*api.Media{
+			// Renamed variant
+			{Name: "A", URL: server.URL + "/a_720p.mp4", Filename: "a_720p.mp4", Label: "720p", LocalFilename: "a_480p.mp4", LocalLabel: "480p"},
+			// Same friendly filename
+			{Name: "B", URL: server.URL + "/b_720p.mp4", Filename: "Friendly.mp4", Label: "720p", LocalFilename: "Friendly.mp4", LocalLabel: "480p"},
+			// Quality lowered: never downgraded
+			{Name: "C", URL: server.URL + "/c_480p.mp4", Filename: "c_480p.mp4", Label: "480p", LocalFilename: "c_720p.mp4", LocalLabel: "720p"},
+		}}}
+	}
+
+	s := &config.Settings{WorkDir: dir, SubDir: "jwb-E", Quiet: 2, Download: true, OverwriteBad: true}
+	data := newData()
+	if err := DownloadAll(s, data); err != nil {
+		t.Fatalf("DownloadAll() returned error: %v", err)
+	}
+	// Without --upgrade-quality, a variant under another name is downloaded
+	// next to the local copy, and one under the same name is kept
+	for name, want := range map[string]string{
+		"a_480p.mp4":   "old",
+		"a_720p.mp4":   string(testutil.MP4("video /a_720p.mp4")),
+		"Friendly.mp4": "old",
+		"c_720p.mp4":   "old",
+		"c_480p.mp4":   string(testutil.MP4("video /c_480p.mp4")),
+	} {
+		// #nosec G304 - path is constrained to t.TempDir() in this test
+		got, err := os.ReadFile(filepath.Join(wd, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q (%v), want %q", name, got, err, want)
+		}
+	}
+	for _, m := range data[0].Media {
+		if kept := m.Name == "B"; (m.LocalLabel != m.Label) != kept {
+			t.Errorf("%s: unexpected local copy %q %q", m.Name, m.LocalFilename, m.LocalLabel)
+		}
+	}
+
+	s.UpgradeQuality = true
+	data = newData()
+	if err := DownloadAll(s, data); err != nil {
+		t.Fatalf("DownloadAll() returned error: %v", err)
+	}
+	for name, want := range map[string]string{
+		"a_720p.mp4":   string(testutil.MP4("video /a_720p.mp4")),
+		"Friendly.mp4": string(testutil.MP4("video /b_720p.mp4")),
+		"c_720p.mp4":   "old",
+	} {
+		// #nosec G304 - path is constrained to t.TempDir() in this test
+		got, err := os.ReadFile(filepath.Join(wd, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q (%v), want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"a_480p.mp4", "a_480p.mp4.json", "Friendly.mp4.part"} {
+		if _, err := os.Stat(filepath.Join(wd, name)); !os.IsNotExist(err) {
+			t.Errorf("did not expect %s to exist", name)
+		}
+	}
+	for _, m := range data[0].Media[:2] {
+		if m.LocalLabel != "720p" || m.LocalFilename != m.Filename {
+			t.Errorf("local copy of %s not recorded: %q %q", m.Name, m.LocalFilename, m.LocalLabel)
+		}
+	}
+}
+
+func TestDownloadAllInParallel(t *testing.T) {
+	const jobs = 3
+	var inFlight, maxInFlight atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.mp4" {
+			http.NotFound(w, r)
+			return
+		}
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		if n == jobs {
+			close(release)
+		}
+		// Hold every download until all slots are busy
+		select {
+		case <-release:
+		case <-time.After(2 * time.Second):
+		}
+		body := testutil.MP4("video " + r.URL.Path)
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("Range") == "bytes=6-" && r.Header.Get("If-Range") == `"v1"` {
+			w.WriteHeader(http.StatusPartialContent)
+			body = body[6:]
+		}
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	wd := filepath.Join(dir, "jwb-E")
+	if err := os.MkdirAll(wd, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	// A partial download from an earlier run is resumed
+	if err := os.WriteFile(filepath.Join(wd, "e.mp4.part"), testutil.MP4("video /e.mp4")[:6], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveValidator(filepath.Join(wd, "e.mp4.part"), validator{URL: server.URL + "/e.mp4", ETag: `"v1"`}); err != nil {
+		t.Fatal(err)
+	}
+
+	var media []*api.Media
+	for i, name := range []string{"a.mp4", "b.mp4", "c.mp4", "missing.mp4", "e.mp4"} {
+		media = append(media, &api.Media{Name: name, URL: server.URL + "/" + name, Filename: name, Date: int64(1700000000 - i*86400)})
+	}
+	s := &config.Settings{WorkDir: dir, SubDir: "jwb-E", Quiet: 2, Download: true, Jobs: jobs}
+	if err := DownloadAll(s, []*api.Category{{Key: "VideoOnDemand", Media: media}}); err != nil {
+		t.Fatalf("DownloadAll() returned error: %v", err)
+	}
+
+	if got := maxInFlight.Load(); got != jobs {
+		t.Errorf("got at most %d downloads at a time, want %d", got, jobs)
+	}
+	for _, m := range media {
+		path := filepath.Join(wd, m.Filename)
+		// #nosec G304 - path is constrained to t.TempDir() in this test
+		got, err := os.ReadFile(path)
+		if m.Filename == "missing.mp4" {
+			if err == nil || m.LocalFilename != "" {
+				t.Errorf("did not expect %s to be downloaded", m.Filename)
+			}
+			continue
+		}
+		if want := string(testutil.MP4("video /" + m.Filename)); err != nil || string(got) != want {
+			t.Errorf("%s: got %q (%v), want %q", m.Filename, got, err, want)
+		}
+		if fi, err := os.Stat(path); err != nil || fi.ModTime().Unix() != m.Date {
+			t.Errorf("%s: modification time not set to the media date", m.Filename)
+		}
+		if m.LocalFilename != m.Filename {
+			t.Errorf("%s: local copy not recorded", m.Filename)
+		}
+	}
+	for _, name := range []string{"e.mp4.part", "e.mp4.part" + validatorSuffix} {
+		if _, err := os.Stat(filepath.Join(wd, name)); !os.IsNotExist(err) {
+			t.Errorf("did not expect %s to remain", name)
+		}
+	}
+}
+
+func TestDownloadAllQuarantinesCorruptFiles(t *testing.T) {
+	var flakyRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := testutil.MP4("video " + r.URL.Path)
+		switch {
+		case r.URL.Path == "/broken.mp4":
+			body = body[:len(body)-4]
+		case r.URL.Path == "/flaky.mp4" && flakyRequests.Add(1) == 1:
+			body = body[:len(body)-4]
+		}
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	var media []*api.Media
+	for _, name := range []string{"flaky.mp4", "broken.mp4"} {
+		media = append(media, &api.Media{Name: name, URL: server.URL + "/" + name, Filename: name, Date: 1700000000})
+	}
+	s := &config.Settings{WorkDir: dir, SubDir: "jwb-E", Quiet: 2, Download: true, VerifyRetries: 1}
+	if err := DownloadAll(s, []*api.Category{{Key: "VideoOnDemand", Media: media}}); err != nil {
+		t.Fatalf("DownloadAll() returned error: %v", err)
+	}
+
+	// #nosec G304 - path is constrained to t.TempDir() in this test
+	if got, err := os.ReadFile(filepath.Join(dir, "jwb-E", "flaky.mp4")); err != nil || !bytes.Equal(got, testutil.MP4("video /flaky.mp4")) {
+		t.Errorf("expected flaky.mp4 to be downloaded again after failing verification (%v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "jwb-E", "broken.mp4")); !os.IsNotExist(err) || media[1].LocalFilename != "" {
+		t.Error("did not expect broken.mp4 to be downloaded")
+	}
+
+	// The first download of flaky.mp4 and both downloads of broken.mp4
+	// are quarantined
+	quarantine := filepath.Join(dir, QuarantineDirName)
+	// #nosec G304 - path is constrained to t.TempDir() in this test
+	log, err := os.ReadFile(filepath.Join(quarantine, QuarantineLogName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(log)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d quarantine log lines, want 3:\n%s", len(lines), log)
+	}
+	for _, line := range lines {
+		if fields := strings.Split(line, "\t"); len(fields) != 4 || !strings.Contains(fields[3], "damaged file structure") {
+			t.Errorf("unexpected quarantine log line %q", line)
+		}
+	}
+	// Quarantines within the same second get names of their own
+	entries, err := os.ReadDir(quarantine)
+	if err != nil || len(entries) != 4 {
+		t.Errorf("expected 3 quarantined files and the log in %s, got %d (%v)", quarantine, len(entries), err)
+	}
+}
+
+func TestDownloadAllMovesFilesIntoLibrary(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write(testutil.MP4("video " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	dir, libDir := t.TempDir(), t.TempDir()
+	var media []*api.Media
+	for _, name := range []string{"a.mp4", "b.mp4"} {
+		size := int64(len(testutil.MP4("video /" + name)))
+		media = append(media, &api.Media{Name: name, URL: server.URL + "/" + name, Filename: name, Size: size, Date: 1700000000})
+	}
+	s := &config.Settings{WorkDir: dir, SubDir: "jwb-E", Quiet: 2, Download: true, OverwriteBad: true, Storage: storage.NewLocal(libDir)}
+	data := []*api.Category{{Key: "VideoOnDemand", Media: media}}
+	if err := DownloadAll(s, data); err != nil {
+		t.Fatalf("DownloadAll() returned error: %v", err)
+	}
+	for _, m := range media {
+		// #nosec G304 - path is constrained to t.TempDir() in this test
+		if got, err := os.ReadFile(filepath.Join(libDir, "jwb-E", m.Filename)); err != nil || !bytes.Equal(got, testutil.MP4("video /"+m.Filename)) {
+			t.Errorf("%s: not moved into the library (%v)", m.Filename, err)
+		}
+		if fileExists(filepath.Join(dir, "jwb-E", m.Filename)) {
+			t.Errorf("%s: did not expect a copy in the work directory", m.Filename)
+		}
+	}
+
+	// Files in the library are not downloaded again
+	requests.Store(0)
+	if err := DownloadAll(s, data); err != nil {
+		t.Fatalf("DownloadAll() returned error: %v", err)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("got %d requests for files in the library, want 0", n)
+	}
+
+	// A broken file in the library is quarantined and replaced
+	if err := os.WriteFile(filepath.Join(libDir, "jwb-E", "b.mp4"), []byte("broken"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := DownloadAll(s, data); err != nil {
+		t.Fatalf("DownloadAll() returned error: %v", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("got %d requests, want 1 for the broken file", n)
+	}
+	// #nosec G304 - path is constrained to t.TempDir() in this test
+	if got, err := os.ReadFile(filepath.Join(libDir, "jwb-E", "b.mp4")); err != nil || !bytes.Equal(got, testutil.MP4("video /b.mp4")) {
+		t.Errorf("broken file not replaced in the library (%v)", err)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, QuarantineDirName))
+	if err != nil || len(entries) != 2 {
+		t.Errorf("expected the broken file and the quarantine log in the work directory, got %d entries (%v)", len(entries), err)
+	}
+
+	// The disk space limit only cleans up the work directory
+	s.KeepFree = 1 << 20
+	if err := DownloadAll(s, data); !errors.Is(err, ErrDiskLimitNeedsWorkDir) {
+		t.Errorf("DownloadAll() = %v, want ErrDiskLimitNeedsWorkDir", err)
+	}
+	s.Storage = storage.NewLocal(dir)
+	if err := CheckDiskLimit(s); err != nil {
+		t.Errorf("CheckDiskLimit() = %v for a library in the work directory", err)
+	}
+}
+
+func TestDownloadSummary(t *testing.T) {
+	var d downloadSummary
+	d.add(1, 0, 0, 3<<20)
+	d.add(1, 0, 0, 1<<19)
+	d.add(0, 1, 0, 0)
+	d.add(0, 0, 1, 0)
+	want := "downloaded 2 of 5 files (3.5 MiB) in 12s, 1 failed, 1 skipped for lack of disk space"
+	if got := d.String(5, 12300*time.Millisecond); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	d.addBroken("Intro", "MD5 checksum mismatch")
+	want += "\npermanently broken (failed verification on every attempt):\n  Intro (MD5 checksum mismatch)"
+	if got := d.String(5, 12300*time.Millisecond); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := progressLine(1, "video.mp4", 1<<20, 4<<20); got != "  #2 video.mp4                                1.0 MiB / 4.0 MiB  25%" {
+		t.Errorf("got progress line %q", got)
+	}
+}
+
+func TestRequestContextSharesBandwidth(t *testing.T) {
+	schedule, err := ratelimit.ParseSchedule("00:00-24:00=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &config.Settings{RateLimit: 2}
+	first, second := limiterFrom(RequestContext(s)), limiterFrom(RequestContext(s))
+	if first == nil || first != second {
+		t.Fatalf("RequestContext() limiters %p and %p, want one shared limiter", first, second)
+	}
+	if got := first.Rate(time.Now()); got != 2*ratelimit.MB {
+		t.Errorf("Rate() = %v, want 2 MB/s", got)
+	}
+
+	s.RateSchedule = schedule
+	RequestContext(s)
+	if got := first.Rate(time.Now()); got != 0 {
+		t.Errorf("Rate() with a schedule = %v, want unlimited", got)
+	}
+	RequestContext(&config.Settings{})
+}
+
+// newRangeServer serves data at /video.mp4 with range support and the ETag
+// "v1", and records the Range header of every GET request.
+func newRangeServer(t *testing.T, data []byte, ranges *[]string) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			*ranges = append(*ranges, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "video.mp4", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func withMinSegmentSize(t *testing.T, size int64) {
+	t.Helper()
+	old := minSegmentSize
+	minSegmentSize = size
+	t.Cleanup(func() { minSegmentSize = old })
+}
+
+func testData(n int) ([]byte, string) {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data, fmt.Sprintf("%x", md5.Sum(data)) // #nosec G401 - test checksum
+}
+
+func TestDownloadFileSegmented(t *testing.T) {
+	withMinSegmentSize(t, 100)
+	data, sum := testData(1000)
+	var ranges []string
+	server := newRangeServer(t, data, &ranges)
+	path := filepath.Join(t.TempDir(), "video.bin.part")
+
+	if err := DownloadFileSegmented(context.Background(), server.URL+"/video.mp4", path, 4, int64(len(data)), sum); err != nil {
+		t.Fatalf("DownloadFileSegmented() returned error: %v", err)
+	}
+	// #nosec G304 - path is constrained to t.TempDir() in this test
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("downloaded file differs from the served data (%v)", err)
+	}
+	sort.Strings(ranges)
+	want := []string{"bytes=0-249", "bytes=250-499", "bytes=500-749", "bytes=750-999"}
+	if strings.Join(ranges, " ") != strings.Join(want, " ") {
+		t.Errorf("got range requests %v, want %v", ranges, want)
+	}
+	if fileExists(path + segmentsSuffix) {
+		t.Error("did not expect the segment state to remain")
+	}
+}
+
+func TestDownloadFileSegmentedResumes(t *testing.T) {
+	withMinSegmentSize(t, 100)
+	data, sum := testData(1000)
+	var ranges []string
+	server := newRangeServer(t, data, &ranges)
+	rawURL := server.URL + "/video.mp4"
+	path := filepath.Join(t.TempDir(), "video.bin.part")
+
+	// An interrupted download: the first segment is complete, the second
+	// one half done and the others not started
+	partial := make([]byte, len(data))
+	copy(partial, data[:375])
+	if err := os.WriteFile(path, partial, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	state := newSegmentState(rawURL, int64(len(data)), `"v1"`, 4)
+	state.Segments[0].Done, state.Segments[1].Done = 250, 125
+	stateData, _ := json.Marshal(state)
+	if err := os.WriteFile(path+segmentsSuffix, stateData, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DownloadFileSegmented(context.Background(), rawURL, path, 4, int64(len(data)), sum); err != nil {
+		t.Fatalf("DownloadFileSegmented() returned error: %v", err)
+	}
+	// #nosec G304 - path is constrained to t.TempDir() in this test
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("resumed file differs from the served data (%v)", err)
+	}
+	sort.Strings(ranges)
+	want := []string{"bytes=375-499", "bytes=500-749", "bytes=750-999"}
+	if strings.Join(ranges, " ") != strings.Join(want, " ") {
+		t.Errorf("got range requests %v, want %v", ranges, want)
+	}
+
+	// A download of another version of the file starts over
+	if err := os.WriteFile(path, make([]byte, len(data)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	state.IfRange = `"v0"`
+	stateData, _ = json.Marshal(state)
+	if err := os.WriteFile(path+segmentsSuffix, stateData, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ranges = nil
+	if err := DownloadFileSegmented(context.Background(), rawURL, path, 4, int64(len(data)), sum); err != nil {
+		t.Fatalf("DownloadFileSegmented() of a changed file returned error: %v", err)
+	}
+	if len(ranges) != 4 {
+		t.Errorf("got range requests %v for a changed file, want all four segments", ranges)
+	}
+}
+
+func TestDownloadFileSegmentedFallsBack(t *testing.T) {
+	withMinSegmentSize(t, 100)
+	data, sum := testData(1000)
+	var gets int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// No Accept-Ranges
+		if r.Method == http.MethodGet {
+			gets++
+		}
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "video.bin.part")
+
+	if err := DownloadFileSegmented(context.Background(), server.URL+"/video.mp4", path, 4, 0, sum); err != nil {
+		t.Fatalf("DownloadFileSegmented() returned error: %v", err)
+	}
+	// #nosec G304 - path is constrained to t.TempDir() in this test
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("downloaded file differs from the served data (%v)", err)
+	}
+	if gets != 1 {
+		t.Errorf("got %d GET requests, want a single stream", gets)
+	}
+}
+
+func TestDownloadFileSegmentedVerifies(t *testing.T) {
+	withMinSegmentSize(t, 100)
+	data, _ := testData(1000)
+	var ranges []string
+	server := newRangeServer(t, data, &ranges)
+	dir := t.TempDir()
+
+	for _, tc := range []struct {
+		name string
+		size int64
+		md5  string
+	}{
+		{"checksum", int64(len(data)), "0123456789abcdef0123456789abcdef"},
+		{"size", 1200, ""},
+	} {
+		path := filepath.Join(dir, tc.name+".bin.part")
+		err := DownloadFileSegmented(context.Background(), server.URL+"/video.mp4", path, 4, tc.size, tc.md5)
+		if !errors.Is(err, ErrVerificationFailed) {
+			t.Errorf("%s mismatch: got error %v, want ErrVerificationFailed", tc.name, err)
+		}
+		// The file is left for quarantine
+		if !fileExists(path) || fileExists(path+segmentsSuffix) {
+			t.Errorf("%s mismatch: want the downloaded file without its segment state", tc.name)
+		}
+	}
+}
+
+func TestDownloadFileResumesSafely(t *testing.T) {
+	data := []byte("0123456789")
+	version := `"v1"`
+	ignoreIfRange := false
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", version)
+		if ignoreIfRange && r.Header.Get("Range") != "" {
+			w.Header().Set("Content-Range", "bytes 4-9/10")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(data[4:])
+			return
+		}
+		http.ServeContent(w, r, "file.pdf", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+	rawURL := server.URL + "/file.pdf"
+	path := filepath.Join(t.TempDir(), "file.pdf.part")
+
+	for _, tc := range []struct {
+		name       string
+		recorded   string // ETag recorded with the partial file; none when empty
+		changed    bool   // the file changed and the server ignores If-Range
+		wantRanges []string
+	}{
+		{"unchanged", `"v1"`, false, []string{"bytes=4-"}},
+		{"changed", `"v0"`, false, []string{"bytes=4-"}},
+		{"no validator", "", false, []string{""}},
+		{"If-Range ignored", `"v0"`, true, []string{"bytes=4-", ""}},
+	} {
+		ranges, ignoreIfRange = nil, tc.changed
+		partial := "0123"
+		if tc.recorded != `"v1"` {
+			partial = "XXXX"
+		}
+		if err := os.WriteFile(path, []byte(partial), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		removeValidator(path)
+		if tc.recorded != "" {
+			if err := saveValidator(path, validator{URL: rawURL, ETag: tc.recorded}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := DownloadFile(rawURL, path, true, 0); err != nil {
+			t.Fatalf("%s: DownloadFile() returned error: %v", tc.name, err)
+		}
+		// #nosec G304 - path is constrained to t.TempDir() in this test
+		if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: got %q (%v), want %q", tc.name, got, err, data)
+		}
+		if strings.Join(ranges, ",") != strings.Join(tc.wantRanges, ",") {
+			t.Errorf("%s: got range requests %q, want %q", tc.name, ranges, tc.wantRanges)
+		}
+		if fileExists(path + validatorSuffix) {
+			t.Errorf("%s: did not expect the validator to remain after the download", tc.name)
+		}
+	}
+
+	// An interrupted download keeps the validator of its response
+	truncated := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v2"`)
+		w.Header().Set("Content-Length", "10")
+		_, _ = w.Write(data[:4])
+	}))
+	defer truncated.Close()
+	err := DownloadFileContext(context.Background(), truncated.URL, path, false, 0)
+	if err == nil {
+		t.Fatal("expected an error for a truncated download")
+	}
+	if v, ok := loadValidator(path); !ok || v.URL != truncated.URL || v.ifRange() != `"v2"` {
+		t.Errorf("loadValidator() = %+v, %v, want the ETag of the interrupted response", v, ok)
+	}
+	if v := (validator{ETag: `W/"weak"`, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"}); v.ifRange() != v.LastModified {
+		t.Errorf("ifRange() of a weak ETag = %q, want the Last-Modified date", v.ifRange())
+	}
+}
+
+func TestDiskSpaceReservesRemainingBytes(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.mp4.part"), make([]byte, 40), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// A segmented download allocates its file in full; only the finished
+	// parts of its segments count
+	if err := os.WriteFile(filepath.Join(dir, "b.mp4.part"), make([]byte, 100), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	state := `{"segments":[{"start":0,"end":50,"done":20},{"start":50,"end":100,"done":5}]}`
+	if err := os.WriteFile(filepath.Join(dir, "b.mp4.part"+segmentsSuffix), []byte(state), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &config.Settings{KeepFree: 1, Quiet: 2}
+	out := newConsole(io.Discard, 2, false)
+	a := &api.Media{Filename: "a.mp4", Size: 100, Date: 1}
+	b := &api.Media{Filename: "b.mp4", Size: 100, Date: 1}
+	var space diskSpace
+	for _, m := range []*api.Media{a, b} {
+		if err := space.reserve(s, dir, m, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if space.pending[a] != 60 || space.pending[b] != 75 {
+		t.Errorf("got reservations %d and %d, want 60 and 75", space.pending[a], space.pending[b])
+	}
+	space.progress(a, 90)
+	space.release(b)
+	space.progress(b, 50)
+	if space.pending[a] != 10 || len(space.pending) != 1 {
+		t.Errorf("unexpected reservations after progress: %v", space.pending)
+	}
+}
@@ -0,0 +1,197 @@
+package downloader
+
+import (
+	"context"
+	"crypto/md5" // #nosec G501 - MD5 used for file integrity verification, not cryptographic security
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/darkace1998/jw-scripts/internal/config"
+	"github.com/darkace1998/jw-scripts/internal/storage"
+)
+
+// stagingSuffixes are the suffixes of the files of unfinished downloads,
+// which are never moved into the library.
+var stagingSuffixes = []string{".part", validatorSuffix, segmentsSuffix, ".tmp"}
+
+// libraryName returns the library of s and the name in it of path, a file
+// in the work directory. ok is false for files outside the work directory.
+func libraryName(s *config.Settings, path string) (st storage.Storage, name string, ok bool) {
+	name, err := storage.Name(s.WorkDir, path)
+	if err != nil {
+		return nil, "", false
+	}
+	return s.Library(), name, true
+}
+
+// Stat returns the size and modification time of the file at path in the
+// work directory of s, or, once it has been moved there, of its copy in the
+// library (see config.Settings.Library).
+func Stat(ctx context.Context, s *config.Settings, path string) (storage.FileInfo, error) {
+	fi, err := os.Stat(path)
+	if err == nil && !fi.IsDir() {
+		return storage.FileInfo{Name: filepath.Base(path), Size: fi.Size(), ModTime: fi.ModTime()}, nil
+	}
+	st, name, ok := libraryName(s, path)
+	if !ok {
+		return storage.FileInfo{}, err
+	}
+	return st.Stat(ctx, name)
+}
+
+// exists reports whether the file at path is in the work directory or the
+// library.
+func exists(ctx context.Context, s *config.Settings, path string) bool {
+	_, err := Stat(ctx, s, path)
+	return err == nil
+}
+
+// Open opens the file at path in the work directory of s, or its copy in
+// the library.
+func Open(ctx context.Context, s *config.Settings, path string) (io.ReadCloser, error) {
+	// #nosec G304 - path is constructed from the work directory and sanitized filenames
+	f, err := os.Open(path)
+	if err == nil || !os.IsNotExist(err) {
+		return f, err
+	}
+	st, name, ok := libraryName(s, path)
+	if !ok {
+		return nil, err
+	}
+	return st.Open(ctx, name)
+}
+
+// fetchFile copies the library copy of path into the work directory, for
+// changes that need a local file, unless it is there already.
+func fetchFile(ctx context.Context, s *config.Settings, path string) error {
+	if fileExists(path) {
+		return nil
+	}
+	st, name, ok := libraryName(s, path)
+	if !ok {
+		return os.ErrNotExist
+	}
+	r, err := st.Open(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	tmpPath := path + ".part"
+	// #nosec G304 - path is constructed from the work directory and sanitized filenames
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Remove removes the file at path from the work directory of s and from
+// the library.
+func Remove(ctx context.Context, s *config.Settings, path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if st, name, ok := libraryName(s, path); ok {
+		return st.Remove(ctx, name)
+	}
+	return nil
+}
+
+// Publish moves the finished file at path in the work directory of s into
+// the library. Files outside the work directory stay where they are, as do
+// all files when the library is the work directory itself.
+func Publish(ctx context.Context, s *config.Settings, path string) error {
+	st, name, ok := libraryName(s, path)
+	if !ok {
+		return nil
+	}
+	return st.PutFile(ctx, name, path)
+}
+
+// publishAll moves the finished files in directory into the library. It
+// runs once all downloads and metadata of a run are done, and picks up
+// files left behind by an earlier run that failed to move them.
+func publishAll(s *config.Settings, directory string) error {
+	if libraryInWorkDir(s) {
+		return nil
+	}
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return err
+	}
+	ctx := RequestContext(s)
+	var failed int
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || isStaging(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(directory, entry.Name())
+		if err := Publish(ctx, s, path); err != nil {
+			failed++
+			if s.Quiet < 2 {
+				fmt.Fprintf(os.Stderr, "could not move %s to %s: %v\n", entry.Name(), s.Library(), err)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be moved to %s; they are moved on the next run", failed, s.Library())
+	}
+	return nil
+}
+
+// libraryInWorkDir reports whether the library of s is the work directory
+// itself, where finished files already are.
+func libraryInWorkDir(s *config.Settings) bool {
+	l, ok := s.Library().(*storage.Local)
+	if !ok {
+		return false
+	}
+	root, err := filepath.Abs(l.Root)
+	if err != nil {
+		return false
+	}
+	wd, err := filepath.Abs(s.WorkDir)
+	return err == nil && root == wd
+}
+
+// isStaging reports whether name is a file of an unfinished download.
+func isStaging(name string) bool {
+	for _, suffix := range stagingSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckStoredMD5 is like CheckMD5 for the file at path in the work
+// directory of s or its copy in the library.
+func CheckStoredMD5(ctx context.Context, s *config.Settings, path, expectedMD5 string) (bool, error) {
+	r, err := Open(ctx, s, path)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = r.Close() }()
+	return readerMD5(r, expectedMD5)
+}
+
+// readerMD5 reports whether the MD5 checksum of the data read from r is
+// expectedMD5.
+func readerMD5(r io.Reader, expectedMD5 string) (bool, error) {
+	h := md5.New() // #nosec G401 - MD5 used for file integrity verification, not cryptographic security
+	if _, err := io.Copy(h, r); err != nil {
+		return false, err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)) == expectedMD5, nil
+}
@@ -32,20 +32,20 @@ func TestDownloadAllWritesMetadataForExistingFiles(t *testing.T) {
 		{
 			Key:  "VideoOnDemand",
 			Name: "Video on Demand",
-			Contents: []interface{}{
-				&api.Media{
+			Media: []*api.Media{
+				{
 					Name:     "Embedded Song",
 					Filename: "song.mp3",
 					URL:      "https://example.com/song.mp3",
 					Date:     1700000000,
 					Duration: 60,
 				},
-				&api.Media{
+				{
 					Name:     "Broken Video",
 					Filename: "broken.mp4",
 					URL:      "https://example.com/broken.mp4",
 				},
-				&api.Media{
+				{
 					Name:     "Missing Video",
 					Filename: "missing.mp4",
 					URL:      "https://example.com/missing.mp4",
@@ -123,8 +123,8 @@ func TestDownloadAllRemovesStaleSidecarAfterEmbedding(t *testing.T) {
 		{
 			Key:  "Audio",
 			Name: "Audio",
-			Contents: []interface{}{
-				&api.Media{Name: "Song", Filename: "song.mp3", URL: "https://example.com/song.mp3"},
+			Media: []*api.Media{
+				{Name: "Song", Filename: "song.mp3", URL: "https://example.com/song.mp3"},
 			},
 		},
 	}
@@ -148,17 +148,25 @@ func TestDownloadAllRemovesStaleSidecarAfterEmbedding(t *testing.T) {
 
 func TestCheckMediaToleratesEmbeddedMetadataGrowth(t *testing.T) {
 	dir := t.TempDir()
-	if err := os.WriteFile(filepath.Join(dir, "video.mp4"), []byte("0123456789"), 0o600); err != nil {
-		t.Fatal(err)
+	writeVideo := func() {
+		if err := os.WriteFile(filepath.Join(dir, "video.mp4"), []byte("0123456789"), 0o600); err != nil {
+			t.Fatal(err)
+		}
 	}
+	writeVideo()
 
 	media := &api.Media{Name: "Video", Filename: "video.mp4", Size: 5, MD5: "doesnotmatch"}
 
 	// Without embedded metadata, a size mismatch marks the file as broken
-	s := &config.Settings{OverwriteBad: true, Checksums: true, Quiet: 2}
+	// and moves it to the quarantine directory
+	s := &config.Settings{OverwriteBad: true, Checksums: true, Quiet: 2, WorkDir: t.TempDir()}
 	if checkMedia(s, media, dir) {
 		t.Error("expected size mismatch to mark file as broken without --metadata")
 	}
+	if fileExists(filepath.Join(dir, "video.mp4")) {
+		t.Error("expected broken file to be quarantined")
+	}
+	writeVideo()
 
 	// With embedded metadata, larger-than-expected files are considered
 	// complete and the checksum check is skipped
@@ -0,0 +1,352 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/darkace1998/jw-scripts/internal/api"
+	"github.com/darkace1998/jw-scripts/internal/config"
+)
+
+// progressInterval is how often the progress lines of parallel downloads
+// are redrawn.
+const progressInterval = 200 * time.Millisecond
+
+// progressFunc is told how many of the total bytes of a download have been
+// written; total is -1 when the size is unknown.
+type progressFunc func(written, total int64)
+
+type progressKey struct{}
+
+// withProgress makes DownloadFileContext report its progress to p instead
+// of drawing a progress bar.
+func withProgress(ctx context.Context, p progressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+func progressFrom(ctx context.Context) progressFunc {
+	p, _ := ctx.Value(progressKey{}).(progressFunc)
+	return p
+}
+
+// progressWriter counts the bytes written through it for a progressFunc.
+type progressWriter struct {
+	report  progressFunc
+	written int64
+	total   int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	w.report(w.written, w.total)
+	return len(p), nil
+}
+
+// console serializes the messages of concurrent downloads and keeps one
+// progress line per download slot at the bottom of the terminal. When the
+// output is not a terminal, only the messages are written.
+type console struct {
+	mu    sync.Mutex
+	w     io.Writer
+	slots []string // progress line of every slot; nil without live progress
+	drawn int      // number of progress lines currently on screen
+	last  time.Time
+}
+
+// newConsole returns a console writing to w with the given number of
+// progress lines.
+func newConsole(w io.Writer, slots int, live bool) *console {
+	c := &console{w: w}
+	if live {
+		c.slots = make([]string, slots)
+	}
+	return c
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Printf writes a message above the progress lines.
+func (c *console) Printf(format string, args ...any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clear()
+	fmt.Fprintf(c.w, format, args...)
+	c.draw()
+}
+
+// setSlot replaces the progress line of slot. Redraws are throttled unless
+// force is set.
+func (c *console) setSlot(slot int, line string, force bool) {
+	if c.slots == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.slots[slot] = line
+	if !force && time.Since(c.last) < progressInterval {
+		return
+	}
+	c.clear()
+	c.draw()
+}
+
+// Close removes the progress lines.
+func (c *console) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clear()
+}
+
+func (c *console) clear() {
+	if c.drawn > 0 {
+		fmt.Fprintf(c.w, "\033[%dA\033[J", c.drawn)
+		c.drawn = 0
+	}
+}
+
+func (c *console) draw() {
+	if c.slots == nil {
+		return
+	}
+	for _, line := range c.slots {
+		fmt.Fprintln(c.w, line)
+	}
+	c.drawn = len(c.slots)
+	c.last = time.Now()
+}
+
+// progressLine formats the progress line of a download slot.
+func progressLine(slot int, name string, written, total int64) string {
+	if len([]rune(name)) > 40 {
+		name = string([]rune(name)[:37]) + "..."
+	}
+	if total <= 0 {
+		return fmt.Sprintf("  #%d %-40s %s", slot+1, name, formatMiB(written))
+	}
+	return fmt.Sprintf("  #%d %-40s %s / %s %3d%%", slot+1, name, formatMiB(written), formatMiB(total), written*100/total)
+}
+
+// formatMiB formats a byte count in MiB.
+func formatMiB(n int64) string {
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+}
+
+// downloadSummary counts the outcome of the downloads of a run.
+type downloadSummary struct {
+	mu         sync.Mutex
+	downloaded int
+	failed     int
+	skipped    int
+	bytes      int64
+	broken     []string // media that failed verification on every attempt, with the reason
+}
+
+func (d *downloadSummary) add(downloaded, failed, skipped int, bytes int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.downloaded += downloaded
+	d.failed += failed
+	d.skipped += skipped
+	d.bytes += bytes
+}
+
+// addBroken records media that failed verification on every attempt.
+func (d *downloadSummary) addBroken(name, reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.broken = append(d.broken, fmt.Sprintf("%s (%s)", name, reason))
+}
+
+// String summarizes the downloads of a run that took elapsed, followed by
+// the list of permanently broken media.
+func (d *downloadSummary) String(total int, elapsed time.Duration) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "downloaded %d of %d files (%s) in %s", d.downloaded, total, formatMiB(d.bytes), elapsed.Round(time.Second))
+	if d.failed > 0 {
+		fmt.Fprintf(&b, ", %d failed", d.failed)
+	}
+	if d.skipped > 0 {
+		fmt.Fprintf(&b, ", %d skipped for lack of disk space", d.skipped)
+	}
+	if len(d.broken) > 0 {
+		b.WriteString("\npermanently broken (failed verification on every attempt):")
+		for _, item := range d.broken {
+			fmt.Fprintf(&b, "\n  %s", item)
+		}
+	}
+	return b.String()
+}
+
+// diskSpace serializes the disk-space cleanup of concurrent downloads. The
+// bytes that downloads in progress still have to write are reserved, so
+// several downloads never count on the same free space. Bytes already
+// written have lowered the free space and are not reserved again.
+type diskSpace struct {
+	mu      sync.Mutex
+	pending map[*api.Media]int64
+}
+
+// reserve frees disk space for the part of media that is not downloaded
+// yet, like diskCleanup, and reserves it until release is called.
+func (d *diskSpace) reserve(s *config.Settings, directory string, media *api.Media, out *console) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	size := max(media.Size-partialSize(filepath.Join(directory, media.Filename)+".part"), 0)
+	needed := size
+	for _, n := range d.pending {
+		needed += n
+	}
+	if err := diskCleanup(s, directory, media, needed, out); err != nil {
+		return err
+	}
+	if d.pending == nil {
+		d.pending = make(map[*api.Media]int64)
+	}
+	d.pending[media] = size
+	return nil
+}
+
+// progress shrinks the reservation of media once written of its bytes are
+// on disk.
+func (d *diskSpace) progress(media *api.Media, written int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.pending[media]; ok {
+		d.pending[media] = max(media.Size-written, 0)
+	}
+}
+
+func (d *diskSpace) release(media *api.Media) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pending, media)
+}
+
+// downloadMediaList downloads the media of list with up to settings.Jobs
+// downloads at a time. Every download slot gets its own progress line on a
+// terminal, and a summary is printed at the end. upgrades maps the media
+// that replace a lower-quality local copy to the path of that copy.
+//
+// Disk space is freed before each download while the other slots hold on
+// to the space of their files. A cleanup error other than running out of
+// space stops the remaining downloads and is returned once the running
+// ones have finished.
+func downloadMediaList(s *config.Settings, list []*api.Media, upgrades map[*api.Media]string, directory string) error {
+	if len(list) == 0 {
+		return nil
+	}
+	jobs := s.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	if jobs > len(list) {
+		jobs = len(list)
+	}
+	out := newConsole(os.Stderr, jobs, jobs > 1 && s.Quiet < 2 && isTerminal(os.Stderr))
+	defer out.Close()
+
+	var (
+		summary downloadSummary
+		space   diskSpace
+		errMu   sync.Mutex
+		fatal   error
+	)
+	stopped := func() bool {
+		errMu.Lock()
+		defer errMu.Unlock()
+		return fatal != nil
+	}
+
+	start := time.Now()
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for slot := 0; slot < jobs; slot++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if stopped() {
+					continue
+				}
+				media := list[i]
+				if s.KeepFree > 0 {
+					if err := space.reserve(s, directory, media, out); err != nil {
+						if err == ErrDiskLimitReached || err == ErrMissingTimestamp {
+							if s.Quiet < 2 {
+								out.Printf("low disk space and missing metadata, skipping: %s\n", media.Name)
+							}
+							summary.add(0, 0, 1, 0)
+							continue
+						}
+						errMu.Lock()
+						if fatal == nil {
+							fatal = err
+						}
+						errMu.Unlock()
+						continue
+					}
+				}
+
+				prefix := fmt.Sprintf("[%d/%d] ", i+1, len(list))
+				if old, ok := upgrades[media]; ok {
+					prefix += fmt.Sprintf("upgrading %s from %s to %s: ", filepath.Base(old), media.LocalLabel, media.Label)
+				}
+				ctx := RequestContext(s)
+				if jobs > 1 {
+					ctx = withProgress(ctx, func(written, total int64) {
+						space.progress(media, written)
+						out.setSlot(slot, progressLine(slot, media.Filename, written, total), false)
+					})
+				}
+				err := downloadMedia(ctx, s, media, directory, out, prefix)
+				out.setSlot(slot, "", true)
+				if s.KeepFree > 0 {
+					space.release(media)
+				}
+				if err != nil {
+					if s.Quiet < 2 {
+						out.Printf("download failed for %s: %v\n", media.Name, err)
+					}
+					summary.add(0, 1, 0, 0)
+					var verr *VerificationError
+					if errors.As(err, &verr) {
+						summary.addBroken(media.Name, verr.Reason)
+					}
+					continue
+				}
+
+				var size int64
+				if fi, err := Stat(ctx, s, filepath.Join(directory, media.Filename)); err == nil {
+					size = fi.Size
+				}
+				summary.add(1, 0, 0, size)
+				media.LocalFilename, media.LocalLabel = media.Filename, media.Label
+				if old, ok := upgrades[media]; ok {
+					removeReplaced(ctx, s, old, filepath.Join(directory, media.Filename), out)
+				}
+			}
+		}()
+	}
+	for i := range list {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	out.Close()
+
+	if s.Quiet < 2 {
+		fmt.Fprintln(os.Stderr, summary.String(len(list), time.Since(start)))
+	}
+	return fatal
+}
@@ -0,0 +1,385 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errSingleStream reports that a file is downloaded in a single stream,
+// because it is too small to split or the server does not accept range
+// requests.
+var errSingleStream = errors.New("single stream download")
+
+// minSegmentSize is the smallest segment a file is split into, so only
+// large files are downloaded over several connections.
+var minSegmentSize int64 = 16 << 20
+
+// stateInterval is how often the progress of a segmented download is
+// saved.
+const stateInterval = time.Second
+
+// segmentsSuffix is appended to the ".part" file of a segmented download to
+// name the file recording the progress of its segments.
+const segmentsSuffix = ".segments"
+
+// segmentState is the progress of a segmented download, saved next to its
+// ".part" file so every segment of an interrupted download resumes where it
+// stopped.
+type segmentState struct {
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+	// IfRange is the ETag or Last-Modified date of the file, sent with
+	// every range request so no segment is taken from another version.
+	IfRange  string    `json:"ifRange"`
+	Segments []segment `json:"segments"`
+}
+
+// segment is the byte range [Start, End) of a file, of which the first Done
+// bytes have been written.
+type segment struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Done  int64 `json:"done"`
+}
+
+func (s *segment) remaining() int64 {
+	return s.End - s.Start - s.Done
+}
+
+// newSegmentState splits a file of size bytes into n segments.
+func newSegmentState(rawURL string, size int64, ifRange string, n int) *segmentState {
+	state := &segmentState{URL: rawURL, Size: size, IfRange: ifRange}
+	chunk := size / int64(n)
+	for i := 0; i < n; i++ {
+		end := int64(i+1) * chunk
+		if i == n-1 {
+			end = size
+		}
+		state.Segments = append(state.Segments, segment{Start: int64(i) * chunk, End: end})
+	}
+	return state
+}
+
+// loadSegmentState reads the progress of a segmented download of rawURL
+// with size bytes and validator ifRange into path. It returns nil when there
+// is none, or when it belongs to another file or version of the file.
+func loadSegmentState(path, rawURL string, size int64, ifRange string) *segmentState {
+	// #nosec G304 - Path is from download logic for legitimate file operations
+	data, err := os.ReadFile(path + segmentsSuffix)
+	if err != nil {
+		return nil
+	}
+	var state segmentState
+	if err := json.Unmarshal(data, &state); err != nil || state.URL != rawURL || state.Size != size || state.IfRange == "" || state.IfRange != ifRange || len(state.Segments) == 0 {
+		return nil
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != size {
+		return nil
+	}
+	for _, seg := range state.Segments {
+		if seg.Done < 0 || seg.remaining() < 0 {
+			return nil
+		}
+	}
+	return &state
+}
+
+// partialSize returns how many bytes of a file have already been written to
+// the partial download at path: the finished part of every segment of a
+// segmented download, whose file is allocated in full up front, or the size
+// of a single-stream download.
+func partialSize(path string) int64 {
+	// #nosec G304 - Path is from download logic for legitimate file operations
+	data, err := os.ReadFile(path + segmentsSuffix)
+	if err == nil {
+		var state segmentState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return 0
+		}
+		var done int64
+		for _, seg := range state.Segments {
+			done += seg.Done
+		}
+		return done
+	}
+	if fi, err := os.Stat(path); err == nil {
+		return fi.Size()
+	}
+	return 0
+}
+
+// DownloadFileSegmented downloads rawURL to path over up to segments
+// connections at once, each fetching a byte range of the file straight into
+// path. The progress of every segment is saved in path+segmentsSuffix, so
+// an interrupted download resumes each segment where it stopped, provided
+// the ETag or Last-Modified date of the file is unchanged.
+//
+// Files smaller than two segments of 16 MiB, and files on servers that do
+// not advertise Accept-Ranges, are downloaded in a single stream like
+// DownloadFileContext, resuming a partial path. Either way the result is
+// checked with VerifyFile against size and the MD5 checksum md5sum when they
+// are given (and against the size reported by the server); a file failing
+// verification is left at path, to be quarantined, and a
+// *VerificationError is returned.
+func DownloadFileSegmented(ctx context.Context, rawURL, path string, segments int, size int64, md5sum string) error {
+	err := downloadSegments(ctx, rawURL, path, segments, &size)
+	if errors.Is(err, errSingleStream) {
+		err = DownloadFileContext(ctx, rawURL, path, fileExists(path), 0)
+	}
+	if err != nil {
+		return err
+	}
+	return VerifyFile(path, strings.TrimSuffix(filepath.Base(path), ".part"), size, md5sum)
+}
+
+// downloadSegments does the segmented download of DownloadFileSegmented.
+// It returns errSingleStream when the file has to be downloaded in a single
+// stream instead. *size is set to the size reported by the server when it
+// was not known.
+func downloadSegments(ctx context.Context, rawURL, path string, segments int, size *int64) error {
+	if segments < 2 {
+		return errSingleStream
+	}
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme: %s", parsedURL.Scheme)
+	}
+
+	length, v, err := probeRanges(ctx, parsedURL.String())
+	if err != nil {
+		return err
+	}
+	if *size <= 0 {
+		*size = length
+	}
+	n := int(min(int64(segments), length/minSegmentSize))
+	if n < 2 {
+		return errSingleStream
+	}
+
+	state := loadSegmentState(path, rawURL, length, v.ifRange())
+	if state == nil {
+		// A partial single-stream download is resumed as such
+		if fileExists(path) && !fileExists(path+segmentsSuffix) {
+			return errSingleStream
+		}
+		state = newSegmentState(rawURL, length, v.ifRange(), n)
+		// #nosec G304 - Path is from download logic for legitimate file operations
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		err = f.Truncate(length)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// #nosec G304 - Path is from download logic for legitimate file operations
+	f, err := os.OpenFile(path, os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	d := &segmentedDownload{state: state, statePath: path + segmentsSuffix}
+	var done int64
+	for _, seg := range state.Segments {
+		done += seg.Done
+	}
+	d.written = done
+	if report := progressFrom(ctx); report != nil {
+		d.report = report
+	} else {
+		bar := newProgressBar(length)
+		d.report = func(written, _ int64) { _ = bar.Set64(written) }
+	}
+	d.report(done, length)
+	if err := d.save(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+	)
+	for i := range state.Segments {
+		if state.Segments[i].remaining() == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := d.fetch(ctx, parsedURL.String(), f, i); err != nil {
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				errMu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		if errors.Is(firstErr, errSingleStream) {
+			// Start over in a single stream
+			_ = f.Close()
+			_ = os.Remove(path)
+			_ = os.Remove(path + segmentsSuffix)
+			return firstErr
+		}
+		if err := d.save(); err != nil {
+			return errors.Join(firstErr, err)
+		}
+		return firstErr
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path + segmentsSuffix)
+}
+
+// probeRanges asks the server for the size and validator of rawURL and
+// whether it accepts range requests. It returns errSingleStream when it
+// does not.
+func probeRanges(ctx context.Context, rawURL string) (int64, validator, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, http.NoBody)
+	if err != nil {
+		return 0, validator{}, err
+	}
+	// #nosec G704 - URL scheme is validated by the caller to only allow http/https
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, validator{}, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength <= 0 || !strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes") {
+		return 0, validator{}, errSingleStream
+	}
+	return resp.ContentLength, validatorOf(rawURL, resp.Header), nil
+}
+
+// segmentedDownload tracks the progress of the segments of a download.
+type segmentedDownload struct {
+	mu        sync.Mutex
+	state     *segmentState
+	statePath string
+	saved     time.Time
+	written   int64
+	report    progressFunc
+}
+
+// fetch downloads the rest of segment i into f.
+func (d *segmentedDownload) fetch(ctx context.Context, rawURL string, f *os.File, i int) error {
+	d.mu.Lock()
+	seg := d.state.Segments[i]
+	d.mu.Unlock()
+	from, to := seg.Start+seg.Done, seg.End-1
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, http.NoBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, to))
+	if d.state.IfRange != "" {
+		req.Header.Set("If-Range", d.state.IfRange)
+	}
+	// #nosec G704 - URL scheme is validated by the caller to only allow http/https
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusOK {
+		// The range was ignored, or the file changed since the download
+		// started
+		return errSingleStream
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+	if want := fmt.Sprintf("bytes %d-%d/", from, to); !strings.HasPrefix(resp.Header.Get("Content-Range"), want) {
+		return fmt.Errorf("unexpected Content-Range %q for bytes %d-%d", resp.Header.Get("Content-Range"), from, to)
+	}
+
+	var body io.Reader = resp.Body
+	if l := limiterFrom(ctx); l != nil {
+		body = l.Reader(ctx, body)
+	}
+	w := &segmentWriter{d: d, f: f, segment: i, offset: from}
+	n, err := io.Copy(w, io.LimitReader(body, to-from+1))
+	if err == nil && n != to-from+1 {
+		err = fmt.Errorf("segment %d ended after %d of %d bytes: %w", i+1, n, to-from+1, io.ErrUnexpectedEOF)
+	}
+	return err
+}
+
+// add records n more bytes written to segment i.
+func (d *segmentedDownload) add(i int, n int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.state.Segments[i].Done += n
+	d.written += n
+	d.report(d.written, d.state.Size)
+	if time.Since(d.saved) >= stateInterval {
+		_ = d.saveLocked()
+	}
+}
+
+func (d *segmentedDownload) save() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.saveLocked()
+}
+
+// saveLocked writes the state file through a temporary file, so an
+// interruption never leaves a truncated one.
+func (d *segmentedDownload) saveLocked() error {
+	data, err := json.Marshal(d.state)
+	if err != nil {
+		return err
+	}
+	tmp := d.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	d.saved = time.Now()
+	return os.Rename(tmp, d.statePath)
+}
+
+// segmentWriter writes a segment at its offset in the file.
+type segmentWriter struct {
+	d       *segmentedDownload
+	f       *os.File
+	segment int
+	offset  int64
+}
+
+func (w *segmentWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	w.d.add(w.segment, int64(n))
+	return n, err
+}
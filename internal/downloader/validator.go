@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// validatorSuffix is appended to a ".part" file to name the file recording
+// the validator of the response it is downloaded from.
+const validatorSuffix = ".validator"
+
+// validator identifies the version of a remote file that a partial
+// download belongs to, so a resumed download never appends the rest of a
+// changed file.
+type validator struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// validatorOf returns the validator of a response for rawURL.
+func validatorOf(rawURL string, h http.Header) validator {
+	return validator{URL: rawURL, ETag: h.Get("ETag"), LastModified: h.Get("Last-Modified")}
+}
+
+// ifRange returns the If-Range value of v: the ETag when it is a strong
+// one, otherwise the Last-Modified date. It is empty when v cannot be used
+// to resume a download.
+func (v validator) ifRange() string {
+	if v.ETag != "" && !strings.HasPrefix(v.ETag, "W/") {
+		return v.ETag
+	}
+	return v.LastModified
+}
+
+// matches reports whether a response with header h is for the same version
+// of the file as v. Validators missing from h are not compared.
+func (v validator) matches(h http.Header) bool {
+	if etag := h.Get("ETag"); etag != "" && v.ETag != "" && etag != v.ETag {
+		return false
+	}
+	if modified := h.Get("Last-Modified"); modified != "" && v.LastModified != "" && modified != v.LastModified {
+		return false
+	}
+	return true
+}
+
+// loadValidator reads the validator recorded for the partial download at
+// path.
+func loadValidator(path string) (validator, bool) {
+	var v validator
+	// #nosec G304 - Path is from download logic for legitimate file operations
+	data, err := os.ReadFile(path + validatorSuffix)
+	if err != nil || json.Unmarshal(data, &v) != nil {
+		return validator{}, false
+	}
+	return v, true
+}
+
+// saveValidator records v for the partial download at path. Validators
+// that cannot be used to resume are not recorded.
+func saveValidator(path string, v validator) error {
+	if v.ifRange() == "" {
+		removeValidator(path)
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+validatorSuffix, data, 0o600)
+}
+
+// removeValidator removes the validator recorded for path, if any.
+func removeValidator(path string) {
+	_ = os.Remove(path + validatorSuffix)
+}
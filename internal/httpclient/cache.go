@@ -0,0 +1,200 @@
+package httpclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// CacheDirName is the name of the response cache directory that the
+// command-line tools create inside their work directory.
+const CacheDirName = ".jwb-cache"
+
+// maxCachedBodySize is the largest response body stored in the cache
+// (16 MiB). Larger responses are passed through uncached.
+const maxCachedBodySize = 16 << 20
+
+// Cache is an on-disk cache for GET responses. Cached responses are
+// revalidated with conditional requests (If-None-Match / If-Modified-Since)
+// and served from disk when the server answers 304 Not Modified.
+type Cache struct {
+	// Dir is the directory holding the cache entries.
+	Dir string
+	// MaxAge is the maximum age of a cached body. Older entries are not
+	// revalidated but fetched again in full.
+	MaxAge time.Duration
+	// Refresh bypasses cached entries; fresh responses are still stored.
+	Refresh bool
+}
+
+// cacheEntry is a cached response as stored on disk.
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	ContentType  string    `json:"contentType,omitempty"`
+	StoredAt     time.Time `json:"storedAt"`
+	Body         []byte    `json:"body"`
+}
+
+// Wrap returns a copy of client whose requests go through the cache.
+func (c *Cache) Wrap(client *http.Client) *http.Client {
+	wrapped := *client
+	wrapped.Transport = &cacheTransport{base: client.Transport, cache: c}
+	return &wrapped
+}
+
+// cacheTransport is the http.RoundTripper installed by Cache.Wrap.
+type cacheTransport struct {
+	base  http.RoundTripper
+	cache *Cache
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return base.RoundTrip(req)
+	}
+
+	key := req.URL.String()
+	var entry *cacheEntry
+	if !t.cache.Refresh {
+		entry = t.cache.load(key)
+	}
+
+	if entry != nil {
+		req = req.Clone(req.Context())
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry != nil && resp.StatusCode == http.StatusNotModified {
+		_ = resp.Body.Close()
+		return entry.response(req), nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		// Without a validator the entry could never be revalidated
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCachedBodySize+1))
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	if len(body) > maxCachedBodySize {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	// A failure to store the entry only costs a full download next time.
+	_ = t.cache.store(&cacheEntry{
+		URL:          key,
+		ETag:         etag,
+		LastModified: lastModified,
+		ContentType:  resp.Header.Get("Content-Type"),
+		StoredAt:     time.Now().UTC(),
+		Body:         body,
+	})
+	return resp, nil
+}
+
+// path returns the file holding the cache entry for url.
+func (c *Cache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// load returns the cached entry for url, or nil when there is no usable
+// entry.
+func (c *Cache) load(url string) *cacheEntry {
+	// #nosec G304 - Path is derived from a hash inside the cache directory
+	data, err := os.ReadFile(c.path(url))
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.URL != url {
+		return nil
+	}
+	if c.MaxAge > 0 && time.Since(entry.StoredAt) > c.MaxAge {
+		return nil
+	}
+	return &entry
+}
+
+// store writes entry to the cache. The file is written to a temporary name
+// first so concurrent readers never see a partial entry.
+func (c *Cache) store(entry *cacheEntry) error {
+	if err := os.MkdirAll(c.Dir, 0o750); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.Dir, ".entry-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(entry.URL))
+}
+
+// response builds the response served for a revalidated entry.
+func (e *cacheEntry) response(req *http.Request) *http.Response {
+	header := make(http.Header)
+	if e.ContentType != "" {
+		header.Set("Content-Type", e.ContentType)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", http.StatusOK, http.StatusText(http.StatusOK)),
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,132 @@
+package httpclient
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newETagServer serves body with a fixed ETag and counts full (200) and
+// conditional (304) responses.
+func newETagServer(t *testing.T, body string) (*httptest.Server, *int32, *int32) {
+	t.Helper()
+	var full, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&full, 1)
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &full, &notModified
+}
+
+func get(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Get() returned error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCacheServesBodyOnNotModified(t *testing.T) {
+	server, full, notModified := newETagServer(t, `{"ok":true}`)
+	cache := &Cache{Dir: t.TempDir(), MaxAge: time.Hour}
+	client := cache.Wrap(&http.Client{})
+
+	for i := 0; i < 3; i++ {
+		if body := get(t, client, server.URL+"/category"); body != `{"ok":true}` {
+			t.Fatalf("request %d: unexpected body %q", i, body)
+		}
+	}
+
+	if *full != 1 || *notModified != 2 {
+		t.Errorf("expected 1 full and 2 conditional responses, got %d and %d", *full, *notModified)
+	}
+}
+
+func TestCacheRefreshBypassesEntries(t *testing.T) {
+	server, full, notModified := newETagServer(t, "data")
+	dir := t.TempDir()
+
+	get(t, (&Cache{Dir: dir, MaxAge: time.Hour}).Wrap(&http.Client{}), server.URL)
+	get(t, (&Cache{Dir: dir, MaxAge: time.Hour, Refresh: true}).Wrap(&http.Client{}), server.URL)
+
+	if *full != 2 || *notModified != 0 {
+		t.Errorf("expected --refresh to fetch in full, got %d full and %d conditional", *full, *notModified)
+	}
+}
+
+func TestCacheExpiresOldEntries(t *testing.T) {
+	server, full, _ := newETagServer(t, "data")
+	cache := &Cache{Dir: t.TempDir(), MaxAge: time.Hour}
+	client := cache.Wrap(&http.Client{})
+
+	get(t, client, server.URL)
+
+	// Age the stored entry beyond MaxAge
+	entry := cache.load(server.URL)
+	if entry == nil {
+		t.Fatal("expected response to be cached")
+	}
+	entry.StoredAt = time.Now().Add(-2 * time.Hour)
+	if err := cache.store(entry); err != nil {
+		t.Fatal(err)
+	}
+
+	get(t, client, server.URL)
+	if *full != 2 {
+		t.Errorf("expected expired entry to be fetched in full, got %d full responses", *full)
+	}
+}
+
+func TestCacheSkipsResponsesWithoutValidator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("data"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	get(t, (&Cache{Dir: dir, MaxAge: time.Hour}).Wrap(&http.Client{}), server.URL)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no cache entries, got %d", len(entries))
+	}
+}
+
+func TestCacheIgnoresCorruptEntries(t *testing.T) {
+	server, full, _ := newETagServer(t, "data")
+	cache := &Cache{Dir: t.TempDir(), MaxAge: time.Hour}
+	if err := os.WriteFile(cache.path(server.URL), []byte("{broken"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if body := get(t, cache.Wrap(&http.Client{}), server.URL); body != "data" || *full != 1 {
+		t.Errorf("expected corrupt entry to be replaced, got %q after %d requests", body, *full)
+	}
+	if _, err := os.Stat(cache.path(server.URL)); err != nil {
+		t.Errorf("expected new cache entry: %v", err)
+	}
+}
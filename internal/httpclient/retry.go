@@ -0,0 +1,316 @@
+// Package httpclient provides the HTTP client shared by the API clients and
+// the downloader. Requests that fail with a transient error are retried with
+// exponential backoff and jitter, honouring Retry-After response headers.
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultMaxRetries is the number of retries used by the command-line
+	// tools when --retries is not given.
+	DefaultMaxRetries = 3
+
+	// defaultBaseDelay is the backoff before the first retry; it doubles
+	// for every further attempt.
+	defaultBaseDelay = 500 * time.Millisecond
+
+	// defaultMaxDelay caps the exponential backoff.
+	defaultMaxDelay = 30 * time.Second
+
+	// maxRetryAfter is the longest Retry-After delay that is honoured.
+	// Servers asking for a longer pause are not retried at all.
+	maxRetryAfter = 5 * time.Minute
+
+	// maxDrainSize limits how much of a failed response body is read so the
+	// connection can be reused for the next attempt.
+	maxDrainSize = 64 << 10
+)
+
+// Retry describes a failed attempt that is about to be retried.
+type Retry struct {
+	Method     string
+	URL        string
+	Attempt    int // 1-based number of the attempt that failed
+	MaxRetries int
+	StatusCode int   // 0 when the attempt failed with a transport error
+	Err        error // nil when the attempt failed with a bad status
+	Wait       time.Duration
+}
+
+// String returns a human readable description of the retry.
+func (r Retry) String() string {
+	reason := fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode))
+	if r.Err != nil {
+		reason = r.Err.Error()
+	}
+	return fmt.Sprintf("%s %s: attempt %d/%d failed (%s), retrying in %s",
+		r.Method, r.URL, r.Attempt, r.MaxRetries+1, reason, r.Wait.Round(time.Millisecond))
+}
+
+// LogRetries returns an OnRetry function that prints every retry to w.
+func LogRetries(w io.Writer) func(Retry) {
+	return func(r Retry) {
+		fmt.Fprintln(w, r.String())
+	}
+}
+
+type contextKey int
+
+const (
+	maxRetriesKey contextKey = iota
+	onRetryKey
+	userAgentKey
+)
+
+// WithMaxRetries returns a copy of ctx that overrides the retry limit of the
+// transport for requests made with it.
+func WithMaxRetries(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, maxRetriesKey, n)
+}
+
+// WithOnRetry returns a copy of ctx that reports retries of requests made
+// with it to fn instead of the transport's OnRetry function.
+func WithOnRetry(ctx context.Context, fn func(Retry)) context.Context {
+	return context.WithValue(ctx, onRetryKey, fn)
+}
+
+// WithUserAgent returns a copy of ctx whose requests are sent with the given
+// User-Agent header, unless the request sets one itself. An empty ua leaves
+// the header unchanged.
+func WithUserAgent(ctx context.Context, ua string) context.Context {
+	return context.WithValue(ctx, userAgentKey, ua)
+}
+
+// Transport is an http.RoundTripper that retries idempotent requests which
+// fail with a network error, 429 Too Many Requests or a 5xx gateway/server
+// error.
+type Transport struct {
+	// Base is the underlying transport; http.DefaultTransport when nil.
+	Base http.RoundTripper
+	// MaxRetries is the number of retries after the first attempt.
+	MaxRetries int
+	// Timeout limits each individual attempt, including reading the
+	// response body. Zero means no timeout.
+	Timeout time.Duration
+	// BaseDelay and MaxDelay bound the exponential backoff. Defaults are
+	// used when they are zero.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// OnRetry, when set, is called before every retry.
+	OnRetry func(Retry)
+
+	// sleep waits between attempts; replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// New returns an HTTP client that retries transient failures up to
+// maxRetries times. timeout limits every single attempt; the retries
+// themselves are not bounded by it.
+func New(timeout time.Duration, maxRetries int, onRetry func(Retry)) *http.Client {
+	return &http.Client{
+		Transport: &Transport{
+			MaxRetries: maxRetries,
+			Timeout:    timeout,
+			OnRetry:    onRetry,
+		},
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	maxRetries := t.MaxRetries
+	if n, ok := req.Context().Value(maxRetriesKey).(int); ok {
+		maxRetries = n
+	}
+	if !isIdempotent(req) {
+		maxRetries = 0
+	}
+	onRetry := t.OnRetry
+	if fn, ok := req.Context().Value(onRetryKey).(func(Retry)); ok {
+		onRetry = fn
+	}
+	if ua, ok := req.Context().Value(userAgentKey).(string); ok && ua != "" && req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", ua)
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.attempt(req)
+
+		if attempt > maxRetries || !shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		wait := t.backoff(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				if after > maxRetryAfter {
+					return resp, nil
+				}
+				if after > wait {
+					wait = after
+				}
+			}
+		}
+
+		if onRetry != nil {
+			r := Retry{
+				Method:     req.Method,
+				URL:        req.URL.String(),
+				Attempt:    attempt,
+				MaxRetries: maxRetries,
+				Err:        err,
+				Wait:       wait,
+			}
+			if resp != nil {
+				r.StatusCode = resp.StatusCode
+			}
+			onRetry(r)
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainSize))
+			_ = resp.Body.Close()
+		}
+
+		sleep := t.sleep
+		if sleep == nil {
+			sleep = sleepContext
+		}
+		if err := sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// attempt performs a single round trip, applying the per-attempt timeout.
+func (t *Transport) attempt(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if t.Timeout <= 0 {
+		return base.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.Timeout)
+	resp, err := base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The timeout also covers reading the body, so it is only released
+	// once the caller closes it.
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// backoff returns the jittered exponential delay before retry number
+// attempt: a random duration between half and all of BaseDelay*2^(attempt-1),
+// capped at MaxDelay.
+func (t *Transport) backoff(attempt int) time.Duration {
+	base, maxDelay := t.BaseDelay, t.MaxDelay
+	if base <= 0 {
+		base = defaultBaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxDelay
+	}
+
+	d := base
+	for i := 1; i < attempt && d < maxDelay; i++ {
+		d *= 2
+	}
+	if d > maxDelay {
+		d = maxDelay
+	}
+	// #nosec G404 - jitter does not need a cryptographically secure source
+	return d/2 + rand.N(d/2+1)
+}
+
+// shouldRetry reports whether the outcome of an attempt is transient.
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		// Give up when the caller cancelled the request or its deadline
+		// passed; per-attempt timeouts are transient. Unknown hosts will
+		// not appear by waiting, so they are not retried either.
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return false
+		}
+		return req.Context().Err() == nil && !errors.Is(err, context.Canceled)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isIdempotent reports whether req can safely be sent more than once.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions:
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header, which holds either a number of
+// seconds or an HTTP date.
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// cancelOnClose releases a per-attempt context once the body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
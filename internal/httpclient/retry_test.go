@@ -0,0 +1,250 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client whose transport records the delays it
+// would sleep instead of actually sleeping.
+func newTestClient(maxRetries int) (*http.Client, *[]time.Duration, *[]Retry) {
+	var waits []time.Duration
+	var retries []Retry
+	t := &Transport{
+		MaxRetries: maxRetries,
+		OnRetry:    func(r Retry) { retries = append(retries, r) },
+		sleep: func(_ context.Context, d time.Duration) error {
+			waits = append(waits, d)
+			return nil
+		},
+	}
+	return &http.Client{Transport: t}, &waits, &retries
+}
+
+// newFlakyServer fails the first n requests with the given status.
+func newFlakyServer(t *testing.T, n int32, status int, header http.Header) (*httptest.Server, *int32) {
+	t.Helper()
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= n {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte("try again"))
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestRetriesServerErrors(t *testing.T) {
+	server, calls := newFlakyServer(t, 2, http.StatusServiceUnavailable, nil)
+	client, waits, retries := newTestClient(3)
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get() returned error: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 after retries, got %d", resp.StatusCode)
+	}
+	if *calls != 3 {
+		t.Errorf("expected 3 requests, got %d", *calls)
+	}
+	if len(*retries) != 2 {
+		t.Fatalf("expected 2 reported retries, got %d", len(*retries))
+	}
+	for i, r := range *retries {
+		if r.Attempt != i+1 || r.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("retry %d: got attempt %d status %d", i, r.Attempt, r.StatusCode)
+		}
+	}
+	// Backoff grows exponentially: 250-500ms, then 500ms-1s
+	if w := (*waits)[0]; w < 250*time.Millisecond || w > 500*time.Millisecond {
+		t.Errorf("first backoff out of range: %v", w)
+	}
+	if w := (*waits)[1]; w < 500*time.Millisecond || w > time.Second {
+		t.Errorf("second backoff out of range: %v", w)
+	}
+}
+
+func TestGivesUpAfterMaxRetries(t *testing.T) {
+	server, calls := newFlakyServer(t, 100, http.StatusBadGateway, nil)
+	client, _, _ := newTestClient(2)
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get() returned error: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected final 502 to be returned, got %d", resp.StatusCode)
+	}
+	if *calls != 3 {
+		t.Errorf("expected 3 requests (1 + 2 retries), got %d", *calls)
+	}
+}
+
+func TestHonoursRetryAfter(t *testing.T) {
+	server, _ := newFlakyServer(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"7"}})
+	client, waits, _ := newTestClient(3)
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get() returned error: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 after retry, got %d", resp.StatusCode)
+	}
+	if len(*waits) != 1 || (*waits)[0] != 7*time.Second {
+		t.Errorf("expected a single 7s wait, got %v", *waits)
+	}
+}
+
+func TestDoesNotRetryExcessiveRetryAfter(t *testing.T) {
+	server, calls := newFlakyServer(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"3600"}})
+	client, _, _ := newTestClient(3)
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get() returned error: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests || *calls != 1 {
+		t.Errorf("expected a single 429 response, got %d after %d requests", resp.StatusCode, *calls)
+	}
+}
+
+func TestPerCallRetryLimit(t *testing.T) {
+	server, calls := newFlakyServer(t, 100, http.StatusInternalServerError, nil)
+	client, _, _ := newTestClient(5)
+
+	req, err := http.NewRequestWithContext(WithMaxRetries(context.Background(), 1), http.MethodGet, server.URL, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() returned error: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if *calls != 2 {
+		t.Errorf("expected 2 requests with a per-call limit of 1 retry, got %d", *calls)
+	}
+}
+
+func TestPerCallRetryHook(t *testing.T) {
+	server, _ := newFlakyServer(t, 1, http.StatusInternalServerError, nil)
+	client, _, transportRetries := newTestClient(3)
+
+	var hooked []Retry
+	ctx := WithOnRetry(context.Background(), func(r Retry) { hooked = append(hooked, r) })
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() returned error: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if len(hooked) != 1 || len(*transportRetries) != 0 {
+		t.Errorf("expected retry to be reported only to the per-call hook, got %d/%d", len(hooked), len(*transportRetries))
+	}
+}
+
+func TestDoesNotRetryClientErrorsOrPosts(t *testing.T) {
+	server, calls := newFlakyServer(t, 100, http.StatusNotFound, nil)
+	client, _, _ := newTestClient(3)
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if *calls != 1 {
+		t.Errorf("expected 404 not to be retried, got %d requests", *calls)
+	}
+
+	server, calls = newFlakyServer(t, 100, http.StatusServiceUnavailable, nil)
+	resp, err = client.Post(server.URL, "text/plain", strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if *calls != 1 {
+		t.Errorf("expected POST not to be retried, got %d requests", *calls)
+	}
+}
+
+func TestRetriesNetworkErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	client, waits, retries := newTestClient(2)
+	if _, err := client.Get(url); err == nil {
+		t.Fatal("expected error for closed server")
+	}
+	if len(*waits) != 2 || len(*retries) != 2 || (*retries)[0].Err == nil {
+		t.Errorf("expected 2 retries with transport errors, got %d waits and %v", len(*waits), *retries)
+	}
+}
+
+func TestRetryAfterParsing(t *testing.T) {
+	if d, ok := retryAfter("120"); !ok || d != 2*time.Minute {
+		t.Errorf("retryAfter(120) = %v, %v", d, ok)
+	}
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if d, ok := retryAfter(date); !ok || d < 59*time.Minute || d > time.Hour {
+		t.Errorf("retryAfter(%q) = %v, %v", date, d, ok)
+	}
+	if _, ok := retryAfter("soon"); ok {
+		t.Error("expected invalid Retry-After to be rejected")
+	}
+}
+
+func TestUserAgentFromContext(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("User-Agent"))
+	}))
+	defer server.Close()
+	client, _, _ := newTestClient(0)
+
+	ctx := WithUserAgent(context.Background(), "jwb-test/1.0")
+	for _, explicit := range []string{"", "explicit/1.0"} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if explicit != "" {
+			req.Header.Set("User-Agent", explicit)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Do() returned error: %v", err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	if len(got) != 2 || got[0] != "jwb-test/1.0" || got[1] != "explicit/1.0" {
+		t.Errorf("unexpected User-Agent headers: %v", got)
+	}
+}
@@ -0,0 +1,119 @@
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrBadContainer is returned by CheckContainer for files whose structure
+// is damaged.
+var ErrBadContainer = errors.New("damaged file structure")
+
+// zipTailSize is how much of the end of a ZIP file is searched for the end
+// of central directory record (its size plus the longest comment).
+const zipTailSize = 22 + 0xffff
+
+// CheckContainer checks the structure of the file at path for the format
+// given by ext (".mp4", ".mp3", ".pdf", ".epub", ...): the MP4 boxes must
+// span the whole file and include ftyp, moov and mdat, MP3 audio must start
+// with a frame header after any ID3v2 tag, PDF files need their header and
+// end-of-file marker and EPUB and other ZIP based files their end of
+// central directory. Truncated downloads fail these checks. Other formats
+// are not checked.
+func CheckContainer(path, ext string) error {
+	// #nosec G304 - Path points to a downloaded file being verified
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+
+	switch strings.ToLower(ext) {
+	case ".mp4", ".m4v", ".m4a", ".mov":
+		return checkMP4(f, size)
+	case ".mp3":
+		return checkMP3(f, size)
+	case ".pdf":
+		return checkPDF(f, size)
+	case ".epub", ".jwpub", ".zip":
+		return checkZIP(f, size)
+	}
+	return nil
+}
+
+func checkMP4(f *os.File, size int64) error {
+	boxes, err := readMP4Boxes(f, 0, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadContainer, err)
+	}
+	found := map[string]bool{}
+	for _, box := range boxes {
+		found[box.boxType] = true
+	}
+	for _, boxType := range []string{"ftyp", "moov", "mdat"} {
+		if !found[boxType] {
+			return fmt.Errorf("%w: no %s box", ErrBadContainer, boxType)
+		}
+	}
+	return nil
+}
+
+func checkMP3(f *os.File, size int64) error {
+	tagSize, err := existingID3TagSize(f)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, 2)
+	if tagSize+int64(len(header)) > size {
+		return fmt.Errorf("%w: no audio after the ID3 tag", ErrBadContainer)
+	}
+	if _, err := f.ReadAt(header, tagSize); err != nil {
+		return err
+	}
+	if header[0] != 0xff || header[1]&0xe0 != 0xe0 {
+		return fmt.Errorf("%w: no MPEG frame header at offset %d", ErrBadContainer, tagSize)
+	}
+	return nil
+}
+
+func checkPDF(f *os.File, size int64) error {
+	header := make([]byte, 5)
+	if _, err := f.ReadAt(header, 0); err != nil || string(header) != "%PDF-" {
+		return fmt.Errorf("%w: no PDF header", ErrBadContainer)
+	}
+	if !tailContains(f, size, 1024, []byte("%%EOF")) {
+		return fmt.Errorf("%w: no PDF end-of-file marker", ErrBadContainer)
+	}
+	return nil
+}
+
+func checkZIP(f *os.File, size int64) error {
+	header := make([]byte, 4)
+	if _, err := f.ReadAt(header, 0); err != nil || string(header) != "PK\x03\x04" {
+		return fmt.Errorf("%w: no ZIP header", ErrBadContainer)
+	}
+	if !tailContains(f, size, zipTailSize, []byte("PK\x05\x06")) {
+		return fmt.Errorf("%w: no ZIP central directory", ErrBadContainer)
+	}
+	return nil
+}
+
+// tailContains reports whether the last n bytes of a file of size bytes
+// contain sep.
+func tailContains(f *os.File, size, n int64, sep []byte) bool {
+	n = min(n, size)
+	tail := make([]byte, n)
+	if _, err := f.ReadAt(tail, size-n); err != nil && err != io.EOF {
+		return false
+	}
+	return bytes.Contains(tail, sep)
+}
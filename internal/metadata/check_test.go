@@ -0,0 +1,46 @@
+package metadata
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/darkace1998/jw-scripts/internal/testutil"
+)
+
+func TestCheckContainer(t *testing.T) {
+	mp4 := buildTestMP4(true, []byte("MEDIAMEDIA"))
+	mp3 := append([]byte{0xff, 0xfb, 0x90, 0x00}, make([]byte, 32)...)
+	tagged := append(buildID3Tag(testMeta()), mp3...)
+	pdf := []byte("%PDF-1.7\n1 0 obj\n<<>>\nendobj\ntrailer\n<<>>\n%%EOF\n")
+	epub := []byte("PK\x03\x04mimetypeapplication/epub+zip....PK\x01\x02....PK\x05\x06\x00\x00\x00\x00\x01\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+
+	for _, tc := range []struct {
+		name    string
+		content []byte
+		ok      bool
+	}{
+		{"video.mp4", mp4, true},
+		{"sample.mp4", testutil.MP4("video"), true},
+		{"truncated.mp4", mp4[:len(mp4)-4], false},
+		{"nomoov.mp4", writeBox("ftyp", []byte("isom")), false},
+		{"audio.mp3", mp3, true},
+		{"tagged.mp3", tagged, true},
+		{"html.mp3", []byte("<html>Not found</html>"), false},
+		{"tagonly.mp3", buildID3Tag(testMeta()), false},
+		{"book.pdf", pdf, true},
+		{"truncated.pdf", pdf[:20], false},
+		{"book.epub", epub, true},
+		{"truncated.epub", epub[:30], false},
+		{"notes.txt", []byte("anything"), true},
+	} {
+		path := writeTestFile(t, tc.name+".part", tc.content)
+		err := CheckContainer(path, filepath.Ext(tc.name))
+		if tc.ok && err != nil {
+			t.Errorf("%s: CheckContainer() returned error: %v", tc.name, err)
+		}
+		if !tc.ok && !errors.Is(err, ErrBadContainer) {
+			t.Errorf("%s: CheckContainer() error = %v, want ErrBadContainer", tc.name, err)
+		}
+	}
+}
@@ -31,6 +31,32 @@ type FileMetadata struct {
 	Issue            string  `json:"issue,omitempty"`
 	Source           string  `json:"source"`
 	GeneratedAt      string  `json:"generatedAt"`
+
+	Description                string        `json:"description,omitempty"`
+	Tags                       []string      `json:"tags,omitempty"`
+	Images                     api.Images    `json:"images,omitempty"`
+	GUID                       string        `json:"guid,omitempty"`
+	NaturalKey                 string        `json:"naturalKey,omitempty"`
+	LanguageAgnosticNaturalKey string        `json:"languageAgnosticNaturalKey,omitempty"`
+	SubtitleLanguages          []string      `json:"subtitleLanguages,omitempty"`
+	Translations               []Translation `json:"translations,omitempty"`
+}
+
+// Translation refers to the same media item in another language. Path is
+// relative to the directory of the file being described.
+type Translation struct {
+	Language string `json:"language"`
+	Title    string `json:"title"`
+	Path     string `json:"path,omitempty"`
+	URL      string `json:"url,omitempty"`
+}
+
+// NeedsSidecar reports whether meta holds details that cannot be embedded
+// in media file tags (a description, images or translations) and therefore
+// need a JSON sidecar even for files with embedded tags. Identifiers, tags
+// and subtitle languages alone do not.
+func (m *FileMetadata) NeedsSidecar() bool {
+	return m.Description != "" || len(m.Images) > 0 || len(m.Translations) > 0
 }
 
 // SidecarPath returns the path of the metadata sidecar file for the given
@@ -71,6 +97,14 @@ func FromMedia(lang string, cat *api.Category, m *api.Media) *FileMetadata {
 		ChecksumMD5:      m.MD5,
 		SubtitleURL:      m.SubtitleURL,
 		SubtitleFilename: m.SubtitleFilename,
+
+		Description:                m.Description,
+		Tags:                       m.Tags,
+		Images:                     m.Images,
+		GUID:                       m.GUID,
+		NaturalKey:                 m.NaturalKey,
+		LanguageAgnosticNaturalKey: m.LanguageAgnosticNaturalKey,
+		SubtitleLanguages:          m.SubtitleLanguages,
 	}
 	if cat != nil {
 		meta.Category = cat.Key
@@ -79,5 +113,12 @@ func FromMedia(lang string, cat *api.Category, m *api.Media) *FileMetadata {
 	if m.Date > 0 {
 		meta.Published = time.Unix(m.Date, 0).UTC().Format(time.RFC3339)
 	}
+	for _, t := range m.Translations {
+		tr := Translation{Language: t.Lang, Title: t.Name, URL: t.URL}
+		if t.Filename != "" {
+			tr.Path = filepath.ToSlash(filepath.Join("..", t.SubDir, t.Filename))
+		}
+		meta.Translations = append(meta.Translations, tr)
+	}
 	return meta
 }
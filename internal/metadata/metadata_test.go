@@ -118,3 +118,54 @@ func TestFromMediaNilCategoryAndNoDate(t *testing.T) {
 		t.Error("expected empty published date when media has no date")
 	}
 }
+
+func TestFromMediaCarriesDetails(t *testing.T) {
+	m := &api.Media{
+		Name:                       "Test",
+		Filename:                   "test.mp4",
+		Description:                "A short film",
+		Tags:                       []string{"Family"},
+		Images:                     api.Images{"lsr": {"xl": "https://example.com/lsr_xl.jpg"}},
+		GUID:                       "guid-1",
+		NaturalKey:                 "pub-test_1_VIDEO",
+		LanguageAgnosticNaturalKey: "pub-test_1_VIDEO",
+		SubtitleLanguages:          []string{"E", "S"},
+	}
+
+	meta := FromMedia("E", nil, m)
+	if !meta.NeedsSidecar() {
+		t.Error("expected details to require a sidecar")
+	}
+	if FromMedia("E", nil, &api.Media{Name: "Plain"}).NeedsSidecar() {
+		t.Error("expected plain media not to require a sidecar")
+	}
+	ids := &api.Media{
+		Name:              "Identified",
+		Tags:              []string{"Family"},
+		GUID:              "guid-2",
+		NaturalKey:        "pub-test_2_VIDEO",
+		SubtitleLanguages: []string{"E"},
+	}
+	if FromMedia("E", nil, ids).NeedsSidecar() {
+		t.Error("expected identifiers, tags and subtitle languages not to require a sidecar")
+	}
+
+	dir := t.TempDir()
+	if err := Write(dir, "test.mp4", meta); err != nil {
+		t.Fatal(err)
+	}
+	// #nosec G304 - path is constrained to t.TempDir() in this test
+	data, err := os.ReadFile(SidecarPath(dir, "test.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed map[string]any
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"description", "tags", "images", "guid", "naturalKey", "languageAgnosticNaturalKey", "subtitleLanguages"} {
+		if _, ok := parsed[key]; !ok {
+			t.Errorf("sidecar is missing %q: %s", key, data)
+		}
+	}
+}
@@ -2,8 +2,12 @@
 package output
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"html"
+	"io"
 	"math"
 	"math/rand"
 	"os"
@@ -15,6 +19,7 @@ import (
 
 	"github.com/darkace1998/jw-scripts/internal/api"
 	"github.com/darkace1998/jw-scripts/internal/config"
+	"github.com/darkace1998/jw-scripts/internal/storage"
 )
 
 // PlaylistEntry represents a single entry in a playlist.
@@ -95,39 +100,42 @@ func requiresOutputFilename(mode string) bool {
 func outputSingle(s *config.Settings, data []*api.Category, writer Writer) error {
 	var allMedia []*api.Media
 	for _, category := range data {
-		for _, item := range category.Contents {
-			if media, ok := item.(*api.Media); ok {
-				allMedia = append(allMedia, media)
-			}
+		for _, media := range category.Media {
+			allMedia = append(allMedia, media)
 		}
 	}
 	sortMedia(allMedia, s.Sort)
 
 	for _, media := range allMedia {
-		source := media.URL
-		if media.Filename != "" && fileExists(filepath.Join(s.WorkDir, s.SubDir, media.Filename)) {
-			source = filepath.Join(".", s.SubDir, media.Filename)
-		}
-		writer.Add(PlaylistEntry{
-			Name:     media.Name,
-			Source:   source,
-			Duration: int(math.Round(media.Duration)),
-		})
+		writer.Add(playlistEntry(s, media))
 	}
 
 	return writer.Dump()
 }
 
+// playlistEntry returns the playlist entry for media. Files that were
+// downloaded are referenced by their path relative to the playlist, all
+// others by URL.
+func playlistEntry(s *config.Settings, media *api.Media) PlaylistEntry {
+	source := media.URL
+	if name := localName(media, func(name string) bool { return stored(s, filepath.Join(s.SubDir, name)) }); name != "" {
+		source = filepath.Join(".", s.SubDir, name)
+	}
+	return PlaylistEntry{
+		Name:     media.Name,
+		Source:   source,
+		Duration: int(math.Round(media.Duration)),
+	}
+}
+
 func outputMulti(s *config.Settings, data []*api.Category) error {
 	originalFilename := s.OutputFilename
 	defer func() { s.OutputFilename = originalFilename }()
 
 	for _, category := range data {
 		var categoryMedia []*api.Media
-		for _, item := range category.Contents {
-			if media, ok := item.(*api.Media); ok {
-				categoryMedia = append(categoryMedia, media)
-			}
+		for _, media := range category.Media {
+			categoryMedia = append(categoryMedia, media)
 		}
 
 		if len(categoryMedia) == 0 {
@@ -157,15 +165,7 @@ func outputMulti(s *config.Settings, data []*api.Category) error {
 		}
 
 		for _, media := range categoryMedia {
-			source := media.URL
-			if media.Filename != "" && fileExists(filepath.Join(s.WorkDir, s.SubDir, media.Filename)) {
-				source = filepath.Join(".", s.SubDir, media.Filename)
-			}
-			categoryWriter.Add(PlaylistEntry{
-				Name:     media.Name,
-				Source:   source,
-				Duration: int(math.Round(media.Duration)),
-			})
+			categoryWriter.Add(playlistEntry(s, media))
 		}
 
 		if err := categoryWriter.Dump(); err != nil {
@@ -176,6 +176,74 @@ func outputMulti(s *config.Settings, data []*api.Category) error {
 	return nil
 }
 
+// CreateMultiLanguageOutput creates the output of a multi-language run.
+// Every language gets the regular output, with playlist filenames suffixed
+// by the language code. Single-file playlist modes (txt, m3u, html)
+// additionally get a combined playlist of all languages under the
+// configured filename; stdout and run modes only produce the combined
+// output.
+func CreateMultiLanguageOutput(s *config.Settings, langs []api.IndexedLanguage) error {
+	combined := s.Mode == "stdout" || s.Mode == "run" ||
+		(requiresOutputFilename(s.Mode) && !strings.HasSuffix(s.Mode, "multi") && !strings.HasSuffix(s.Mode, "tree"))
+
+	if s.Mode != "stdout" && s.Mode != "run" {
+		for _, l := range langs {
+			ls := *l.Settings
+			if requiresOutputFilename(s.Mode) {
+				ls.OutputFilename = languageFilename(s.OutputFilename, ls.Lang, s.Mode)
+			}
+			if err := CreateOutput(&ls, l.Data); err != nil {
+				return fmt.Errorf("output for language %s: %w", ls.Lang, err)
+			}
+		}
+	}
+
+	if !combined {
+		return nil
+	}
+	if s.OutputFilename == "" && requiresOutputFilename(s.Mode) {
+		s.OutputFilename = fmt.Sprintf("playlist.%s", getDefaultExtension(s.Mode))
+	}
+	writer, err := newWriter(s)
+	if err != nil {
+		return err
+	}
+
+	var allMedia []*api.Media
+	settingsOf := make(map[*api.Media]*config.Settings)
+	for _, l := range langs {
+		for _, category := range l.Data {
+			for _, media := range category.Media {
+				allMedia = append(allMedia, media)
+				settingsOf[media] = l.Settings
+			}
+		}
+	}
+	sortMedia(allMedia, s.Sort)
+
+	for _, media := range allMedia {
+		ls := settingsOf[media]
+		entry := playlistEntry(ls, media)
+		entry.Name = fmt.Sprintf("%s (%s)", entry.Name, ls.Lang)
+		writer.Add(entry)
+	}
+	return writer.Dump()
+}
+
+// languageFilename returns the per-language variant of a playlist filename,
+// e.g. "playlist_S.m3u" for "playlist.m3u".
+func languageFilename(filename, lang, mode string) string {
+	if filename == "" {
+		// Multi-file modes add the category key: "S_VODBible.m3u"
+		if strings.HasSuffix(mode, "multi") || strings.HasSuffix(mode, "tree") {
+			return fmt.Sprintf("%s.%s", lang, getDefaultExtension(mode))
+		}
+		filename = fmt.Sprintf("playlist.%s", getDefaultExtension(mode))
+	}
+	ext := filepath.Ext(filename)
+	return fmt.Sprintf("%s_%s%s", strings.TrimSuffix(filename, ext), lang, ext)
+}
+
 func getDefaultExtension(mode string) string {
 	switch {
 	case strings.HasPrefix(mode, "txt"):
@@ -189,75 +257,114 @@ func getDefaultExtension(mode string) string {
 	}
 }
 
+// localName returns the filename of the downloaded copy of media for which
+// exists reports true: the selected file, or else the other variant kept
+// from an earlier run (see --upgrade-quality). It returns "" when there is
+// none.
+func localName(media *api.Media, exists func(name string) bool) string {
+	for _, name := range []string{media.Filename, media.LocalFilename} {
+		if name != "" && exists(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// stored reports whether the file at the relative path name is in the work
+// directory or the library of s.
+func stored(s *config.Settings, name string) bool {
+	return fileExists(filepath.Join(s.WorkDir, name)) ||
+		storage.Exists(context.Background(), s.Library(), storage.Join(name))
+}
+
+// outputFilesystem creates the category tree of symlinks in the library,
+// which therefore has to be a local directory.
 func outputFilesystem(s *config.Settings, data []*api.Category) error {
-	dataDir := filepath.Join(s.WorkDir, s.SubDir)
+	lib, ok := s.Library().(*storage.Local)
+	if !ok {
+		return fmt.Errorf("filesystem mode needs a local library, not %s: %w", s.Library(), errors.ErrUnsupported)
+	}
+	root := lib.Root
+	dataDir := filepath.Join(root, s.SubDir)
 	if s.Quiet < 1 {
 		fmt.Fprintln(os.Stderr, "creating directory structure")
 	}
 
 	if s.CleanAllSymlinks {
-		if err := cleanSymlinks(s, dataDir); err != nil {
+		if err := cleanSymlinks(s, root, dataDir); err != nil {
 			return err
 		}
 	}
 
-	for _, category := range data {
-		catDir := filepath.Join(dataDir, category.Key)
-		if err := os.MkdirAll(catDir, 0o750); err != nil {
+	// A category listed under several parents gets its directory and links
+	// once; its other parents still link to it.
+	return api.Walk(data, func(v api.Visit) error {
+		if v.Duplicate || v.Cycle {
+			return nil
+		}
+		return linkCategory(root, dataDir, v.Category)
+	})
+}
+
+// linkCategory creates the directory of category in dataDir with symlinks to
+// its subcategories and downloaded media, and links home categories from
+// root.
+func linkCategory(root, dataDir string, category *api.Category) error {
+	catDir := filepath.Join(dataDir, category.Key)
+	if err := os.MkdirAll(catDir, 0o750); err != nil {
+		return err
+	}
+
+	if category.Home {
+		// Create symlink for home categories
+		linkPath := filepath.Join(root, category.Name)
+		targetPath, err := filepath.Rel(root, catDir)
+		if err != nil {
 			return err
 		}
+		if err := os.Symlink(targetPath, linkPath); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("failed to create symlink %s -> %s: %w", linkPath, targetPath, err)
+		}
+	}
 
-		if category.Home {
-			// Create symlink for home categories
-			linkPath := filepath.Join(s.WorkDir, category.Name)
-			targetPath, err := filepath.Rel(s.WorkDir, catDir)
-			if err != nil {
-				return err
-			}
-			if err := os.Symlink(targetPath, linkPath); err != nil && !os.IsExist(err) {
-				return fmt.Errorf("failed to create symlink %s -> %s: %w", linkPath, targetPath, err)
-			}
+	for _, sub := range category.Subcategories {
+		linkDest := filepath.Join(dataDir, sub.Key)
+		if err := os.MkdirAll(linkDest, 0o750); err != nil {
+			return err
+		}
+		linkFile := filepath.Join(catDir, sub.Name)
+		targetPath, err := filepath.Rel(catDir, linkDest)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(targetPath, linkFile); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("failed to create symlink %s -> %s: %w", linkFile, targetPath, err)
 		}
+	}
 
-		for _, item := range category.Contents {
-			switch v := item.(type) {
-			case *api.Category:
-				linkDest := filepath.Join(dataDir, v.Key)
-				if err := os.MkdirAll(linkDest, 0o750); err != nil {
-					return err
-				}
-				linkFile := filepath.Join(catDir, v.Name)
-				targetPath, err := filepath.Rel(catDir, linkDest)
-				if err != nil {
-					return err
-				}
-				if err := os.Symlink(targetPath, linkFile); err != nil && !os.IsExist(err) {
-					return fmt.Errorf("failed to create symlink %s -> %s: %w", linkFile, targetPath, err)
-				}
-			case *api.Media:
-				linkDest := filepath.Join(dataDir, v.Filename)
-				if !fileExists(linkDest) {
-					continue
-				}
-				linkFile := filepath.Join(catDir, v.FriendlyName)
-				targetPath, err := filepath.Rel(catDir, linkDest)
-				if err != nil {
-					return err
-				}
-				if err := os.Symlink(targetPath, linkFile); err != nil && !os.IsExist(err) {
-					return fmt.Errorf("failed to create symlink %s -> %s: %w", linkFile, targetPath, err)
-				}
-			}
+	for _, media := range category.Media {
+		name := localName(media, func(name string) bool { return fileExists(filepath.Join(dataDir, name)) })
+		if name == "" {
+			continue
+		}
+		linkDest := filepath.Join(dataDir, name)
+		linkFile := filepath.Join(catDir, media.FriendlyName)
+		targetPath, err := filepath.Rel(catDir, linkDest)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(targetPath, linkFile); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("failed to create symlink %s -> %s: %w", linkFile, targetPath, err)
 		}
 	}
 	return nil
 }
 
 // cleanSymlinks removes all symlinks below the data directory as well as
-// top-level symlinks in the work directory that point into the data
+// top-level symlinks in the library directory root that point into the data
 // directory. This implements the --clean-symlinks flag so stale links from
 // previous runs (renamed categories, removed media) do not accumulate.
-func cleanSymlinks(s *config.Settings, dataDir string) error {
+func cleanSymlinks(s *config.Settings, root, dataDir string) error {
 	if s.Quiet < 1 {
 		fmt.Fprintln(os.Stderr, "removing old symlinks")
 	}
@@ -278,9 +385,9 @@ func cleanSymlinks(s *config.Settings, dataDir string) error {
 		}
 	}
 
-	// Home-category symlinks are created at the top level of the work
+	// Home-category symlinks are created at the top level of the library
 	// directory; only remove the ones that point into our data directory.
-	entries, err := os.ReadDir(s.WorkDir)
+	entries, err := os.ReadDir(root)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
@@ -291,13 +398,13 @@ func cleanSymlinks(s *config.Settings, dataDir string) error {
 		if entry.Type()&os.ModeSymlink == 0 {
 			continue
 		}
-		linkPath := filepath.Join(s.WorkDir, entry.Name())
+		linkPath := filepath.Join(root, entry.Name())
 		target, err := os.Readlink(linkPath)
 		if err != nil {
 			continue
 		}
 		if !filepath.IsAbs(target) {
-			target = filepath.Join(s.WorkDir, target)
+			target = filepath.Join(root, target)
 		}
 		absTarget, err := filepath.Abs(target)
 		if err != nil {
@@ -349,10 +456,10 @@ func fileExists(path string) bool {
 // an existing HTML playlist in append mode.
 var hrefRegex = regexp.MustCompile(`href="([^"]*)"`)
 
-// TxtWriter handles writing playlist entries to a text file
+// TxtWriter handles writing playlist entries to a text file in the library
 type TxtWriter struct {
 	s            *config.Settings
-	path         string
+	name         string // name of the file in the library
 	queue        []PlaylistEntry
 	history      map[string]bool
 	existingBody string
@@ -389,7 +496,7 @@ func NewTxtWriter(s *config.Settings) (*TxtWriter, error) {
 
 	return &TxtWriter{
 		s:       s,
-		path:    cleanPath,
+		name:    filepath.ToSlash(rel),
 		history: make(map[string]bool),
 		formatter: func(e PlaylistEntry) string {
 			return e.Source
@@ -400,14 +507,18 @@ func NewTxtWriter(s *config.Settings) (*TxtWriter, error) {
 // LoadExisting reads an already existing output file so that its entries are
 // kept and not duplicated when new entries are appended (--append).
 func (w *TxtWriter) LoadExisting() error {
-	// #nosec G304 - Path is user-configured output file for legitimate file operations
-	data, err := os.ReadFile(w.path)
+	r, err := w.s.Library().Open(context.Background(), w.name)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if storage.IsNotExist(err) {
 			return nil
 		}
 		return err
 	}
+	defer func() { _ = r.Close() }()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
 
 	body := strings.TrimPrefix(string(data), w.start)
 	body = strings.TrimSuffix(body, w.end)
@@ -436,33 +547,18 @@ func (w *TxtWriter) Add(entry PlaylistEntry) {
 }
 
 // Dump writes the existing (appended) content plus all queued playlist
-// entries to the output file. The file is only created/replaced here, so a
-// failed indexing run never truncates a previously written playlist.
+// entries to the output file in the library. The file is only
+// created/replaced here, so a failed indexing run never truncates a
+// previously written playlist.
 func (w *TxtWriter) Dump() error {
-	// #nosec G304 - Path is user-configured output file for legitimate file operations
-	file, err := os.Create(w.path)
-	if err != nil {
-		return err
-	}
-	defer func() { _ = file.Close() }()
-
-	if _, err := file.WriteString(w.start); err != nil {
-		return err
-	}
-	if _, err := file.WriteString(w.existingBody); err != nil {
-		return err
-	}
-
+	var buf bytes.Buffer
+	buf.WriteString(w.start)
+	buf.WriteString(w.existingBody)
 	for _, entry := range w.queue {
-		if _, err := file.WriteString(w.formatter(entry) + "\n"); err != nil {
-			return err
-		}
+		buf.WriteString(w.formatter(entry) + "\n")
 	}
-
-	if _, err := file.WriteString(w.end); err != nil {
-		return err
-	}
-	return nil
+	buf.WriteString(w.end)
+	return w.s.Library().Put(context.Background(), w.name, &buf, int64(buf.Len()))
 }
 
 // --- M3uWriter ---
@@ -13,7 +13,7 @@ import (
 func makeData(urls ...string) []*api.Category {
 	cat := &api.Category{Key: "VideoOnDemand", Name: "Video on Demand"}
 	for i, u := range urls {
-		cat.Contents = append(cat.Contents, &api.Media{
+		cat.Media = append(cat.Media, &api.Media{
 			Name: "Video " + string(rune('A'+i)),
 			URL:  u,
 		})
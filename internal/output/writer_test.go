@@ -1,6 +1,7 @@
 package output
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/darkace1998/jw-scripts/internal/api"
 	"github.com/darkace1998/jw-scripts/internal/config"
+	"github.com/darkace1998/jw-scripts/internal/storage"
 )
 
 func TestPlaylistEntry(t *testing.T) {
@@ -88,8 +90,8 @@ func TestCreateOutputUsesDefaultFilenameForTextModes(t *testing.T) {
 		{
 			Key:  "VideoOnDemand",
 			Name: "Video on Demand",
-			Contents: []interface{}{
-				&api.Media{
+			Media: []*api.Media{
+				{
 					Name: "Test Video",
 					URL:  "https://example.com/test.mp4",
 				},
@@ -114,3 +116,120 @@ func TestCreateOutputUsesDefaultFilenameForTextModes(t *testing.T) {
 		t.Fatalf("expected output file to contain media URL, got: %s", content)
 	}
 }
+
+func TestCreateOutputWritesToLibrary(t *testing.T) {
+	dir, libDir := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(libDir, "jwb-E"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(libDir, "jwb-E", "a.mp4"), []byte("video"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	settings := &config.Settings{
+		Mode:    "txt",
+		WorkDir: dir,
+		SubDir:  "jwb-E",
+		Storage: storage.NewLocal(libDir),
+	}
+	data := []*api.Category{{
+		Key: "VideoOnDemand",
+		Media: []*api.Media{
+			{Name: "A", URL: "https://example.com/a.mp4", Filename: "a.mp4"},
+			{Name: "B", URL: "https://example.com/b.mp4", Filename: "b.mp4"},
+		},
+	}}
+
+	if err := CreateOutput(settings, data); err != nil {
+		t.Fatalf("CreateOutput() returned error: %v", err)
+	}
+	// #nosec G304 - path is constrained to t.TempDir() in this test
+	content, err := os.ReadFile(filepath.Join(libDir, "playlist.txt"))
+	if err != nil {
+		t.Fatalf("expected the playlist in the library: %v", err)
+	}
+	if want := filepath.Join("jwb-E", "a.mp4") + "\nhttps://example.com/b.mp4\n"; string(content) != want {
+		t.Errorf("got playlist %q, want %q", content, want)
+	}
+	if fileExists(filepath.Join(dir, "playlist.txt")) {
+		t.Error("did not expect a playlist in the work directory")
+	}
+
+	// Symlinks need a library on the local file system
+	settings.Mode = "filesystem"
+	settings.Storage = struct{ storage.Storage }{storage.NewLocal(libDir)}
+	if err := CreateOutput(settings, data); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("CreateOutput() = %v, want an error matching errors.ErrUnsupported", err)
+	}
+}
+
+func TestFilesystemLinksCategoryTree(t *testing.T) {
+	dir := t.TempDir()
+	dataDir := filepath.Join(dir, "jwb-E")
+	if err := os.MkdirAll(dataDir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "c.mp4"), []byte("video"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// C is listed under both A and B and lists A again, and only the root
+	// is in the index
+	c := &api.Category{Key: "C", Name: "Cat C", Media: []*api.Media{{Filename: "c.mp4", FriendlyName: "Video C.mp4"}}}
+	a := &api.Category{Key: "A", Name: "Cat A", Subcategories: []*api.Category{c}}
+	b := &api.Category{Key: "B", Name: "Cat B", Subcategories: []*api.Category{c}}
+	c.Subcategories = []*api.Category{a}
+	root := &api.Category{Key: "Root", Name: "Root", Home: true, Subcategories: []*api.Category{a, b}}
+	settings := &config.Settings{Mode: "filesystem", WorkDir: dir, SubDir: "jwb-E", Quiet: 2}
+
+	if err := CreateOutput(settings, []*api.Category{root}); err != nil {
+		t.Fatalf("CreateOutput() returned error: %v", err)
+	}
+	for _, link := range []string{
+		filepath.Join(dir, "Root"),
+		filepath.Join(dataDir, "Root", "Cat A"),
+		filepath.Join(dataDir, "A", "Cat C"),
+		filepath.Join(dataDir, "B", "Cat C"),
+		filepath.Join(dataDir, "C", "Cat A"),
+		filepath.Join(dataDir, "C", "Video C.mp4"),
+	} {
+		if _, err := os.Stat(link); err != nil {
+			t.Errorf("expected a working symlink %s: %v", link, err)
+		}
+	}
+}
+
+func TestOutputsFallBackToKeptVariant(t *testing.T) {
+	dir := t.TempDir()
+	dataDir := filepath.Join(dir, "jwb-E")
+	if err := os.MkdirAll(dataDir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "a_480p.mp4"), []byte("video"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	data := []*api.Category{{Key: "VideoOnDemand", Name: "Videos", Media: []*api.Media{{
+		Name: "A", URL: "https://example.com/a_720p.mp4", Filename: "a_720p.mp4", FriendlyName: "A.mp4",
+		Label: "720p", LocalFilename: "a_480p.mp4", LocalLabel: "480p",
+	}}}}
+
+	settings := &config.Settings{Mode: "txt", WorkDir: dir, SubDir: "jwb-E", Quiet: 2}
+	if err := CreateOutput(settings, data); err != nil {
+		t.Fatalf("CreateOutput() returned error: %v", err)
+	}
+	// #nosec G304 - path is constrained to t.TempDir() in this test
+	content, err := os.ReadFile(filepath.Join(dir, "playlist.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("jwb-E", "a_480p.mp4") + "\n"; string(content) != want {
+		t.Errorf("got playlist %q, want %q", content, want)
+	}
+
+	settings.Mode = "filesystem"
+	if err := CreateOutput(settings, data); err != nil {
+		t.Fatalf("CreateOutput() returned error: %v", err)
+	}
+	// #nosec G304 - path is constrained to t.TempDir() in this test
+	if got, err := os.ReadFile(filepath.Join(dataDir, "VideoOnDemand", "A.mp4")); err != nil || string(got) != "video" {
+		t.Errorf("expected a symlink to the kept 480p copy, got %q (%v)", got, err)
+	}
+}
@@ -0,0 +1,276 @@
+// Package pubmedia is a client for the JW.org Publication Media API
+// (GETPUBMEDIALINKS), which lists the downloadable files of a publication,
+// magazine issue or JW Broadcasting program in every format and language.
+// It is shared by the api and books packages.
+package pubmedia
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxResponseSize and maxAllLangsResponseSize limit the response body size
+// of single-language and all-languages requests.
+const (
+	maxResponseSize         = 10 << 20
+	maxAllLangsResponseSize = 50 << 20
+)
+
+// File formats of the Publication Media API.
+const (
+	PDF  = "PDF"
+	EPUB = "EPUB"
+	MP3  = "MP3"
+	MP4  = "MP4"
+	RTF  = "RTF"
+	BRL  = "BRL"
+)
+
+// AllFormats lists every file format the API is asked for when a query
+// names none.
+var AllFormats = []string{PDF, EPUB, MP3, MP4, RTF, BRL}
+
+// ErrNotFound is returned for publications the API does not know. It also
+// matches a *NoFilesError, so errors.Is(err, ErrNotFound) reports any
+// publication without the requested files.
+var ErrNotFound = errors.New("publication not found")
+
+// NoFilesError is returned when a publication has no files in a language,
+// or none in a format in that language.
+type NoFilesError struct {
+	Pub    string
+	Lang   string
+	Format string // empty when the language has no files at all
+}
+
+func (e *NoFilesError) Error() string {
+	if e.Format == "" {
+		return fmt.Sprintf("no files of %s found for language %s", e.Pub, e.Lang)
+	}
+	return fmt.Sprintf("no %s files of %s found for language %s", e.Format, e.Pub, e.Lang)
+}
+
+// Is reports whether target is ErrNotFound.
+func (e *NoFilesError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// Query selects the files of one publication.
+type Query struct {
+	Pub string
+	// Issue selects an issue of a periodical (YYYYMM or YYYYMMDD).
+	Issue string
+	// Track selects a single track; 0 selects all tracks.
+	Track int
+	// Formats lists the file formats; empty means AllFormats.
+	Formats []string
+	// Langs lists the languages; the first one is required. With more
+	// than one language the files of all languages are requested at once
+	// and the others are dropped.
+	Langs []string
+}
+
+// Publication is the response of the Publication Media API.
+type Publication struct {
+	PubName       string              `json:"pubName"`
+	ParentPubName string              `json:"parentPubName"`
+	Pub           string              `json:"pub"`
+	Issue         string              `json:"issue"`
+	FormattedDate string              `json:"formattedDate"`
+	Track         int                 `json:"track"`
+	FileFormat    []string            `json:"fileformat"`
+	Languages     map[string]Language `json:"languages"`
+	// Files holds the files by language and then by format.
+	Files map[string]map[string][]File `json:"files"`
+}
+
+// Language describes a language of a publication.
+type Language struct {
+	Name      string `json:"name"`
+	Direction string `json:"direction"`
+	Locale    string `json:"locale"`
+}
+
+// Resource is a file or image reference of the API.
+type Resource struct {
+	URL              string `json:"url"`
+	ModifiedDatetime string `json:"modifiedDatetime"`
+	Checksum         string `json:"checksum"`
+}
+
+// File is a single downloadable file of a publication.
+type File struct {
+	Title      string   `json:"title"`
+	File       Resource `json:"file"`
+	Filesize   int64    `json:"filesize"`
+	TrackImage Resource `json:"trackImage"`
+	Track      int      `json:"track"`
+	Pub        string   `json:"pub"`
+	Mimetype   string   `json:"mimetype"`
+	Duration   float64  `json:"duration"`
+	BitRate    float64  `json:"bitRate"`
+	// Label is the resolution of video files ("720p").
+	Label string `json:"label"`
+	// Subtitled reports video files with hard-coded subtitles.
+	Subtitled bool     `json:"subtitled"`
+	Subtitles Resource `json:"subtitles"`
+}
+
+// ByFormat returns the files of p in lang by format. It returns a
+// *NoFilesError when there are none.
+func (p *Publication) ByFormat(lang string) (map[string][]File, error) {
+	files, ok := p.Files[strings.ToUpper(lang)]
+	if !ok || len(files) == 0 {
+		return nil, &NoFilesError{Pub: p.Pub, Lang: lang}
+	}
+	return files, nil
+}
+
+// FilesOf returns the files of p in lang and format. It returns a
+// *NoFilesError when the language or the format is missing; a format that
+// is listed without files is not an error.
+func (p *Publication) FilesOf(lang, format string) ([]File, error) {
+	byFormat, err := p.ByFormat(lang)
+	if err != nil {
+		return nil, err
+	}
+	files, ok := byFormat[strings.ToUpper(format)]
+	if !ok {
+		return nil, &NoFilesError{Pub: p.Pub, Lang: lang, Format: format}
+	}
+	return files, nil
+}
+
+// Client sends queries to the Publication Media API.
+type Client struct {
+	url        string
+	userAgent  string
+	httpClient *http.Client
+}
+
+// Option configures optional behaviour of a Client.
+type Option func(*Client)
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
+}
+
+// WithHTTPClient makes the client send its requests through hc.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// NewClient creates a client for the Publication Media API at apiURL.
+func NewClient(apiURL string, opts ...Option) *Client {
+	c := &Client{url: apiURL, httpClient: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// URL returns the request URL of q.
+func (c *Client) URL(q Query) string {
+	formats := q.Formats
+	if len(formats) == 0 {
+		formats = AllFormats
+	}
+	params := url.Values{}
+	params.Set("output", "json")
+	params.Set("pub", q.Pub)
+	params.Set("fileformat", strings.Join(formats, ","))
+	if len(q.Langs) > 0 {
+		params.Set("langwritten", q.Langs[0])
+		params.Set("txtCMSLang", q.Langs[0])
+	}
+	if len(q.Langs) > 1 {
+		params.Set("alllangs", "1")
+	} else {
+		params.Set("alllangs", "0")
+	}
+	if q.Issue != "" {
+		params.Set("issue", q.Issue)
+	}
+	if q.Track > 0 {
+		params.Set("track", strconv.Itoa(q.Track))
+	}
+	return c.url + "?" + params.Encode()
+}
+
+// Get fetches the publication selected by q. It returns an error matching
+// ErrNotFound when the API does not know the publication; a missing
+// language or format is reported by Publication.ByFormat and
+// Publication.FilesOf.
+func (c *Client) Get(ctx context.Context, q Query) (*Publication, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL(q), http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	// #nosec G704 - URL is built from the configured publication media endpoint
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, describe(q))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get publication %s: %s", describe(q), resp.Status)
+	}
+
+	limit := int64(maxResponseSize)
+	if len(q.Langs) > 1 {
+		limit = maxAllLangsResponseSize
+	}
+	var pub Publication
+	if err := json.NewDecoder(io.LimitReader(resp.Body, limit)).Decode(&pub); err != nil {
+		return nil, fmt.Errorf("could not decode publication %s: %w", describe(q), err)
+	}
+	if pub.Pub == "" {
+		pub.Pub = q.Pub
+	}
+
+	// Keep only the requested languages of an all-languages response
+	if len(q.Langs) > 1 {
+		for lang := range pub.Files {
+			if !containsFold(q.Langs, lang) {
+				delete(pub.Files, lang)
+			}
+		}
+	}
+	return &pub, nil
+}
+
+// describe names the publication of q in errors ("w 202401").
+func describe(q Query) string {
+	s := q.Pub
+	if q.Issue != "" {
+		s += " " + q.Issue
+	}
+	if q.Track > 0 {
+		s += fmt.Sprintf(" track %d", q.Track)
+	}
+	return s
+}
+
+func containsFold(list []string, s string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, s) {
+			return true
+		}
+	}
+	return false
+}
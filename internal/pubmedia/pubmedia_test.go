@@ -0,0 +1,133 @@
+package pubmedia
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const sampleResponse = `{
+	"pubName": "JW Broadcasting",
+	"pub": "jwb",
+	"issue": "202401",
+	"languages": {"E": {"name": "English", "direction": "ltr", "locale": "en"}},
+	"files": {
+		"E": {
+			"MP3": [{"title": "Program", "track": 1, "filesize": 10,
+				"file": {"url": "https://example.com/jwb_E_01.mp3", "checksum": "abc", "modifiedDatetime": "2024-01-02 03:04:05"},
+				"trackImage": {"url": "https://example.com/jwb_01.jpg"}}],
+			"MP4": [{"title": "Program", "track": 1, "label": "720p", "subtitled": true,
+				"file": {"url": "https://example.com/jwb_E_01_720p.mp4"}}]
+		},
+		"S": {"MP3": [{"title": "Programa", "track": 1, "file": {"url": "https://example.com/jwb_S_01.mp3"}}]},
+		"F": {"MP3": []}
+	}
+}`
+
+func newServer(t *testing.T, queries *[]url.Values) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*queries = append(*queries, r.URL.Query())
+		if r.Header.Get("User-Agent") != "jwb-test/1.0" {
+			http.Error(w, "unexpected User-Agent", http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("pub") != "jwb" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(sampleResponse))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGet(t *testing.T) {
+	var queries []url.Values
+	server := newServer(t, &queries)
+	c := NewClient(server.URL, WithHTTPClient(server.Client()), WithUserAgent("jwb-test/1.0"))
+
+	pub, err := c.Get(context.Background(), Query{Pub: "jwb", Issue: "202401", Track: 1, Formats: []string{MP3, MP4}, Langs: []string{"E"}})
+	if err != nil {
+		t.Fatalf("Get() returned error: %v", err)
+	}
+	q := queries[0]
+	for key, want := range map[string]string{"pub": "jwb", "issue": "202401", "track": "1", "fileformat": "MP3,MP4", "langwritten": "E", "alllangs": "0"} {
+		if got := q.Get(key); got != want {
+			t.Errorf("query %s = %q, want %q", key, got, want)
+		}
+	}
+
+	files, err := pub.FilesOf("E", MP3)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("FilesOf(E, MP3) = %v, %v", files, err)
+	}
+	if f := files[0]; f.Track != 1 || f.TrackImage.URL != "https://example.com/jwb_01.jpg" || f.File.Checksum != "abc" {
+		t.Errorf("got file %+v", f)
+	}
+	if files, err := pub.FilesOf("e", "mp4"); err != nil || files[0].Label != "720p" || !files[0].Subtitled {
+		t.Errorf("FilesOf(e, mp4) = %+v, %v", files, err)
+	}
+	if pub.Languages["E"].Locale != "en" {
+		t.Errorf("got languages %+v", pub.Languages)
+	}
+}
+
+func TestGetSeveralLanguages(t *testing.T) {
+	var queries []url.Values
+	server := newServer(t, &queries)
+	c := NewClient(server.URL, WithUserAgent("jwb-test/1.0"))
+
+	pub, err := c.Get(context.Background(), Query{Pub: "jwb", Langs: []string{"E", "S"}})
+	if err != nil {
+		t.Fatalf("Get() returned error: %v", err)
+	}
+	if got := queries[0].Get("alllangs"); got != "1" {
+		t.Errorf("alllangs = %q, want 1", got)
+	}
+	if got := queries[0].Get("fileformat"); got != "PDF,EPUB,MP3,MP4,RTF,BRL" {
+		t.Errorf("fileformat = %q, want all formats", got)
+	}
+	if _, ok := pub.Files["F"]; ok || len(pub.Files) != 2 {
+		t.Errorf("expected only the requested languages, got %v", pub.Files)
+	}
+	if files, err := pub.FilesOf("S", MP3); err != nil || files[0].Title != "Programa" {
+		t.Errorf("FilesOf(S, MP3) = %+v, %v", files, err)
+	}
+}
+
+func TestNoFiles(t *testing.T) {
+	var queries []url.Values
+	server := newServer(t, &queries)
+	c := NewClient(server.URL, WithUserAgent("jwb-test/1.0"))
+
+	_, err := c.Get(context.Background(), Query{Pub: "unknown", Langs: []string{"E"}})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(unknown) error = %v, want ErrNotFound", err)
+	}
+
+	pub, err := c.Get(context.Background(), Query{Pub: "jwb", Langs: []string{"E"}})
+	if err != nil {
+		t.Fatalf("Get() returned error: %v", err)
+	}
+	if files, err := pub.FilesOf("F", MP3); err != nil || len(files) != 0 {
+		t.Errorf("FilesOf(F, MP3) = %v, %v, want no files and no error", files, err)
+	}
+	for _, tc := range []struct{ lang, format, wantFormat string }{
+		{"X", MP3, ""},
+		{"F", MP4, MP4},
+		{"E", PDF, PDF},
+	} {
+		_, err := pub.FilesOf(tc.lang, tc.format)
+		var noFiles *NoFilesError
+		if !errors.As(err, &noFiles) || noFiles.Lang != tc.lang || noFiles.Format != tc.wantFormat || noFiles.Pub != "jwb" {
+			t.Errorf("FilesOf(%s, %s) error = %#v, want NoFilesError", tc.lang, tc.format, err)
+		}
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("FilesOf(%s, %s) error does not match ErrNotFound", tc.lang, tc.format)
+		}
+	}
+}
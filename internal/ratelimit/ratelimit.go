@@ -0,0 +1,209 @@
+// Package ratelimit provides a token-bucket bandwidth limiter that can be
+// shared by concurrent transfers, with an optional time-of-day schedule.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MB is the unit of the rates given on the command line (megabytes/s).
+const MB = 1024 * 1024
+
+// ErrInvalidSchedule is returned for schedules that ParseSchedule cannot
+// read.
+var ErrInvalidSchedule = errors.New("invalid rate schedule")
+
+// Window is a daily time window with its own rate.
+type Window struct {
+	// Start and End are the times of day the window starts and ends. A
+	// window ending before it starts runs past midnight.
+	Start, End time.Duration
+	// Rate is the limit in bytes per second; 0 means unlimited.
+	Rate float64
+}
+
+// contains reports whether the time of day d lies in w.
+func (w Window) contains(d time.Duration) bool {
+	if w.Start <= w.End {
+		return d >= w.Start && d < w.End
+	}
+	return d >= w.Start || d < w.End
+}
+
+// Schedule is a list of daily windows; the first window containing a time
+// decides its rate.
+type Schedule []Window
+
+// ParseSchedule reads a comma separated list of HH:MM-HH:MM=RATE windows
+// in local time, with RATE in megabytes/s and 0 for unlimited, for example
+// "22:00-06:00=0,06:00-22:00=2". An empty string is an empty schedule.
+func ParseSchedule(s string) (Schedule, error) {
+	var schedule Schedule
+	if strings.TrimSpace(s) == "" {
+		return schedule, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		span, rate, ok := strings.Cut(part, "=")
+		from, to, ok2 := strings.Cut(span, "-")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("%w %q (expected HH:MM-HH:MM=RATE)", ErrInvalidSchedule, part)
+		}
+		start, err := parseTimeOfDay(from)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidSchedule, part, err)
+		}
+		end, err := parseTimeOfDay(to)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidSchedule, part, err)
+		}
+		mbps, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+		if err != nil || mbps < 0 {
+			return nil, fmt.Errorf("%w %q: rate must be a number of megabytes/s", ErrInvalidSchedule, part)
+		}
+		if start == end {
+			return nil, fmt.Errorf("%w %q: the window is empty", ErrInvalidSchedule, part)
+		}
+		schedule = append(schedule, Window{Start: start, End: end, Rate: mbps * MB})
+	}
+	return schedule, nil
+}
+
+// parseTimeOfDay parses "HH:MM" (00:00 to 24:00) as the time since
+// midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	h, m, ok := strings.Cut(strings.TrimSpace(s), ":")
+	hours, err1 := strconv.Atoi(h)
+	minutes, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || len(m) != 2 || hours < 0 || minutes < 0 || minutes > 59 || hours*60+minutes > 24*60 {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// RateAt returns the rate at t: the rate of the first window containing
+// the local time of day of t, or def outside all windows.
+func (sc Schedule) RateAt(t time.Time, def float64) float64 {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	d := t.Sub(midnight)
+	for _, w := range sc {
+		if w.contains(d) {
+			return w.Rate
+		}
+	}
+	return def
+}
+
+// Limiter is a token bucket holding up to one second of transfer at the
+// current rate. All transfers drawing from the same Limiter share its
+// bandwidth. The zero value is not usable; create limiters with New.
+type Limiter struct {
+	mu       sync.Mutex
+	rate     float64 // bytes per second outside the schedule; 0 is unlimited
+	schedule Schedule
+	tokens   float64
+	last     time.Time
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// New returns a limiter allowing rate bytes per second (0 for unlimited)
+// outside the windows of schedule.
+func New(rate float64, schedule Schedule) *Limiter {
+	return &Limiter{rate: rate, schedule: schedule, now: time.Now, sleep: sleepContext}
+}
+
+// Set changes the rate and schedule of l. Transfers in progress continue
+// at the new rate.
+func (l *Limiter) Set(rate float64, schedule Schedule) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate, l.schedule = rate, schedule
+}
+
+// Rate returns the rate of l at t in bytes per second; 0 is unlimited.
+func (l *Limiter) Rate(t time.Time) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.schedule.RateAt(t, l.rate)
+}
+
+// WaitN blocks until n bytes may be transferred, or ctx is done.
+//
+// Transfers take tokens in the order they ask, running the bucket into
+// debt when there are not enough; each one then waits until its debt is
+// paid off, so concurrent transfers share the rate fairly.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := l.now()
+	rate := l.schedule.RateAt(now, l.rate)
+	if rate <= 0 {
+		// Unlimited: start with a full bucket once a limit applies again
+		l.tokens, l.last = 0, time.Time{}
+		l.mu.Unlock()
+		return nil
+	}
+	if l.last.IsZero() {
+		l.tokens = rate
+	} else if elapsed := now.Sub(l.last).Seconds(); elapsed > 0 {
+		l.tokens = min(l.tokens+elapsed*rate, rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(0)
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	return l.sleep(ctx, wait)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reader returns a reader that draws every read from r from l.
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &reader{ctx: ctx, r: r, l: l}
+}
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *Limiter
+}
+
+// maxChunk bounds a single read, so a slow rate is not exceeded in bursts.
+const maxChunk = 32 * 1024
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > maxChunk {
+		p = p[:maxChunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,189 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	schedule, err := ParseSchedule("22:00-06:00=0, 06:00-22:00=2.5")
+	if err != nil {
+		t.Fatalf("ParseSchedule() returned error: %v", err)
+	}
+	want := Schedule{
+		{Start: 22 * time.Hour, End: 6 * time.Hour, Rate: 0},
+		{Start: 6 * time.Hour, End: 22 * time.Hour, Rate: 2.5 * MB},
+	}
+	if len(schedule) != len(want) {
+		t.Fatalf("got %d windows, want %d", len(schedule), len(want))
+	}
+	for i := range want {
+		if schedule[i] != want[i] {
+			t.Errorf("window %d = %+v, want %+v", i, schedule[i], want[i])
+		}
+	}
+
+	if schedule, err := ParseSchedule(""); err != nil || len(schedule) != 0 {
+		t.Errorf("ParseSchedule(\"\") = %v, %v, want an empty schedule", schedule, err)
+	}
+	for _, s := range []string{"22:00-06:00", "22:00=1", "25:00-06:00=1", "22:00-06:60=1", "22:00-6=1", "08:00-08:00=1", "08:00-09:00=-1", "08:00-09:00=fast"} {
+		if _, err := ParseSchedule(s); !errors.Is(err, ErrInvalidSchedule) {
+			t.Errorf("ParseSchedule(%q) error = %v, want ErrInvalidSchedule", s, err)
+		}
+	}
+}
+
+func TestScheduleRateAt(t *testing.T) {
+	schedule, err := ParseSchedule("22:00-06:00=0,06:00-22:00=2,00:00-24:00=5")
+	if err != nil {
+		t.Fatalf("ParseSchedule() returned error: %v", err)
+	}
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+	for _, tc := range []struct {
+		at   time.Duration
+		want float64
+	}{
+		{0, 0},
+		{5*time.Hour + 59*time.Minute, 0},
+		{6 * time.Hour, 2 * MB},
+		{21*time.Hour + 59*time.Minute, 2 * MB},
+		{22 * time.Hour, 0},
+	} {
+		if got := schedule.RateAt(day.Add(tc.at), 1); got != tc.want {
+			t.Errorf("RateAt(%s) = %v, want %v", tc.at, got, tc.want)
+		}
+	}
+
+	daytime, _ := ParseSchedule("08:00-18:00=2")
+	if got := daytime.RateAt(day.Add(20*time.Hour), 7); got != 7 {
+		t.Errorf("RateAt() outside the windows = %v, want the default 7", got)
+	}
+}
+
+// fakeClock makes a limiter sleep in virtual time; waited adds up the time
+// slept.
+type fakeClock struct {
+	now    time.Time
+	waited time.Duration
+}
+
+func newTestLimiter(rate float64, schedule Schedule, clock *fakeClock) *Limiter {
+	l := New(rate, schedule)
+	l.now = func() time.Time { return clock.now }
+	l.sleep = func(_ context.Context, d time.Duration) error {
+		clock.waited += d
+		clock.now = clock.now.Add(d)
+		return nil
+	}
+	return l
+}
+
+func TestLimiterWaitN(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)}
+	l := newTestLimiter(1000, nil, clock)
+	ctx := context.Background()
+
+	// The first second of transfer is allowed at once
+	if err := l.WaitN(ctx, 1000); err != nil || clock.waited != 0 {
+		t.Fatalf("WaitN() = %v after waiting %s, want no wait", err, clock.waited)
+	}
+	// Then the rate applies
+	_ = l.WaitN(ctx, 500)
+	if clock.waited != 500*time.Millisecond {
+		t.Errorf("waited %s, want 500ms", clock.waited)
+	}
+
+	// After an idle period the bucket is full, but holds no more than one
+	// second of transfer
+	clock.now = clock.now.Add(time.Minute)
+	clock.waited = 0
+	_ = l.WaitN(ctx, 1500)
+	if clock.waited != 500*time.Millisecond {
+		t.Errorf("waited %s after idling, want 500ms", clock.waited)
+	}
+}
+
+func TestLimiterConcurrentTransfers(t *testing.T) {
+	// Transfers asking at the same moment queue up behind each other
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	var waits []time.Duration
+	l := New(1000, nil)
+	l.now = func() time.Time { return now }
+	l.sleep = func(_ context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	for i := 0; i < 4; i++ {
+		_ = l.WaitN(context.Background(), 500)
+	}
+	want := []time.Duration{500 * time.Millisecond, time.Second}
+	if len(waits) != len(want) || waits[0] != want[0] || waits[1] != want[1] {
+		t.Errorf("got waits %v, want %v", waits, want)
+	}
+}
+
+func TestLimiterSchedule(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 23, 0, 0, 0, time.Local)}
+	schedule, _ := ParseSchedule("22:00-06:00=0")
+	l := newTestLimiter(1000, schedule, clock)
+
+	// Full speed at night
+	for i := 0; i < 10; i++ {
+		_ = l.WaitN(context.Background(), 1<<20)
+	}
+	if clock.waited != 0 {
+		t.Errorf("waited %s at night, want no wait", clock.waited)
+	}
+
+	// --limit-rate during the day, starting with a full bucket
+	clock.now = time.Date(2024, 3, 2, 9, 0, 0, 0, time.Local)
+	_ = l.WaitN(context.Background(), 3000)
+	if clock.waited != 2*time.Second {
+		t.Errorf("waited %s during the day, want 2s", clock.waited)
+	}
+	if got := l.Rate(clock.now); got != 1000 {
+		t.Errorf("Rate() = %v, want 1000", got)
+	}
+
+	l.Set(0, nil)
+	clock.waited = 0
+	_ = l.WaitN(context.Background(), 1<<20)
+	if clock.waited != 0 {
+		t.Errorf("waited %s after Set(0, nil), want no wait", clock.waited)
+	}
+}
+
+func TestLimiterContext(t *testing.T) {
+	l := New(1, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = l.WaitN(context.Background(), 1)
+	if err := l.WaitN(ctx, 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("WaitN() with a canceled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)}
+	l := newTestLimiter(64*1024, nil, clock)
+	data := bytes.Repeat([]byte("x"), 256*1024)
+
+	r := l.Reader(context.Background(), bytes.NewReader(data))
+	buf := make([]byte, 128*1024)
+	n, err := r.Read(buf)
+	if err != nil || n != maxChunk {
+		t.Fatalf("Read() = %d, %v, want a chunk of %d bytes", n, err, maxChunk)
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil || n+len(rest) != len(data) {
+		t.Fatalf("read %d bytes, %v, want %d", n+len(rest), err, len(data))
+	}
+	// 256 KiB at 64 KiB/s with a full bucket of 64 KiB
+	if clock.waited != 3*time.Second {
+		t.Errorf("waited %s, want 3s", clock.waited)
+	}
+}
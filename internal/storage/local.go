@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Local stores files in a directory of the local file system.
+type Local struct {
+	Root string
+}
+
+// NewLocal returns the storage in the directory root.
+func NewLocal(root string) *Local {
+	return &Local{Root: root}
+}
+
+// Path returns the local path of name.
+func (l *Local) Path(name string) string {
+	return filepath.Join(l.Root, filepath.FromSlash(name))
+}
+
+// Stat implements Storage. Directories are reported as missing files.
+func (l *Local) Stat(_ context.Context, name string) (FileInfo, error) {
+	fi, err := os.Stat(l.Path(name))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if fi.IsDir() {
+		return FileInfo{}, &fs.PathError{Op: "stat", Path: l.Path(name), Err: fs.ErrNotExist}
+	}
+	return FileInfo{Name: name, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// Open implements Storage.
+func (l *Local) Open(_ context.Context, name string) (io.ReadCloser, error) {
+	// #nosec G304 - Path is inside the configured library directory
+	return os.Open(l.Path(name))
+}
+
+// Put implements Storage. The data is written to a temporary file that is
+// renamed into place, so an interrupted write never replaces name with a
+// truncated file.
+func (l *Local) Put(_ context.Context, name string, r io.Reader, _ int64) error {
+	dest := l.Path(name)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o750); err != nil {
+		return err
+	}
+	tmp := dest + ".tmp"
+	// #nosec G304 - Path is inside the configured library directory
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// PutFile implements Storage. The file is renamed, or copied when it is on
+// another file system. A path that already is the file name is left alone.
+func (l *Local) PutFile(_ context.Context, name, path string) error {
+	dest := l.Path(name)
+	if same, err := samePath(path, dest); err != nil || same {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o750); err != nil {
+		return err
+	}
+	if err := os.Rename(path, dest); err == nil {
+		return nil
+	}
+	if err := copyFile(path, dest); err != nil {
+		return fmt.Errorf("could not move %s to %s: %w", path, dest, err)
+	}
+	return os.Remove(path)
+}
+
+// Remove implements Storage.
+func (l *Local) Remove(_ context.Context, name string) error {
+	if err := os.Remove(l.Path(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) String() string {
+	return l.Root
+}
+
+// samePath reports whether a and b are the same path.
+func samePath(a, b string) (bool, error) {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false, err
+	}
+	return absA == absB, nil
+}
+
+// copyFile copies src to dst, keeping its modification time.
+func copyFile(src, dst string) error {
+	// #nosec G304 - Path is a finished download in the work directory
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	// #nosec G304 - Path is inside the configured library directory
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
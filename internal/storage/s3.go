@@ -0,0 +1,345 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darkace1998/jw-scripts/internal/httpclient"
+)
+
+// DefaultPartSize is the size of the parts of multipart uploads. Files up
+// to this size are uploaded with a single request.
+const DefaultPartSize = 64 << 20
+
+// Environment variables NewS3 takes the credentials, region and endpoint
+// from, as used by the AWS tools.
+const (
+	EnvAccessKeyID     = "AWS_ACCESS_KEY_ID"
+	EnvSecretAccessKey = "AWS_SECRET_ACCESS_KEY"
+	EnvSessionToken    = "AWS_SESSION_TOKEN"
+	EnvRegion          = "AWS_REGION"
+	EnvEndpoint        = "AWS_ENDPOINT_URL"
+)
+
+// S3 stores files as objects in a bucket of Amazon S3 or a compatible
+// object store such as MinIO. Requests are signed with AWS Signature
+// Version 4.
+type S3 struct {
+	// Endpoint is the URL of the object store. Buckets are addressed in
+	// its path when PathStyle is set, otherwise as a subdomain.
+	Endpoint  *url.URL
+	PathStyle bool
+	Bucket    string
+	// Prefix is prepended to the name of every file; it is empty or ends
+	// with a slash.
+	Prefix      string
+	Region      string
+	Credentials Credentials
+	// PartSize is the size of the parts of multipart uploads;
+	// DefaultPartSize when zero.
+	PartSize int64
+	// Client sends the requests; failed GET and HEAD requests are retried.
+	Client *http.Client
+
+	// now returns the time requests are signed at; replaced in tests.
+	now func() time.Time
+}
+
+// NewS3 returns the storage at location, an URL of the form
+// s3://bucket/prefix. The credentials come from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN. The region is the region
+// query parameter, AWS_REGION or us-east-1, and the endpoint the endpoint
+// query parameter or AWS_ENDPOINT_URL; object stores at a custom endpoint,
+// such as s3://media?endpoint=http://localhost:9000 for a local MinIO, are
+// addressed path-style. Without an endpoint, Amazon S3 itself is used.
+func NewS3(location string) (*S3, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage location: %w", err)
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("invalid storage location %q: expected s3://bucket/prefix", location)
+	}
+	s := &S3{
+		Bucket: u.Host,
+		Prefix: strings.Trim(u.Path, "/"),
+		Region: firstOf(u.Query().Get("region"), os.Getenv(EnvRegion), "us-east-1"),
+		Credentials: Credentials{
+			AccessKeyID:     os.Getenv(EnvAccessKeyID),
+			SecretAccessKey: os.Getenv(EnvSecretAccessKey),
+			SessionToken:    os.Getenv(EnvSessionToken),
+		},
+		Client: httpclient.New(0, httpclient.DefaultMaxRetries, nil),
+	}
+	if s.Prefix != "" {
+		s.Prefix += "/"
+	}
+	if s.Credentials.AccessKeyID == "" || s.Credentials.SecretAccessKey == "" {
+		return nil, fmt.Errorf("storage %s needs the %s and %s environment variables", location, EnvAccessKeyID, EnvSecretAccessKey)
+	}
+
+	endpoint := firstOf(u.Query().Get("endpoint"), os.Getenv(EnvEndpoint))
+	if endpoint == "" {
+		endpoint = "https://s3." + s.Region + ".amazonaws.com"
+	} else {
+		s.PathStyle = true
+	}
+	if s.Endpoint, err = url.Parse(endpoint); err != nil || (s.Endpoint.Scheme != "http" && s.Endpoint.Scheme != "https") || s.Endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	// Bucket names with dots do not match the certificate of a subdomain
+	if strings.Contains(s.Bucket, ".") {
+		s.PathStyle = true
+	}
+	return s, nil
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (s *S3) String() string {
+	return "s3://" + s.Bucket + "/" + s.Prefix
+}
+
+// Stat implements Storage.
+func (s *S3) Stat(ctx context.Context, name string) (FileInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, name, nil, nil, 0)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	_ = resp.Body.Close()
+	fi := FileInfo{Name: name, Size: resp.ContentLength}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		fi.ModTime = t
+	}
+	return fi, nil
+}
+
+// Open implements Storage.
+func (s *S3) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, name, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Put implements Storage. Files larger than PartSize are uploaded in
+// parts.
+func (s *S3) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	if size > s.partSize() {
+		return s.putMultipart(ctx, name, r, size)
+	}
+	resp, err := s.do(ctx, http.MethodPut, name, nil, r, size)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// PutFile implements Storage. The local file is removed once it is
+// uploaded.
+func (s *S3) PutFile(ctx context.Context, name, path string) error {
+	// #nosec G304 - Path is a finished download in the work directory
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := s.Put(ctx, name, f, fi.Size()); err != nil {
+		return err
+	}
+	_ = f.Close()
+	return os.Remove(path)
+}
+
+// Remove implements Storage.
+func (s *S3) Remove(ctx context.Context, name string) error {
+	resp, err := s.do(ctx, http.MethodDelete, name, nil, nil, 0)
+	if IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *S3) partSize() int64 {
+	if s.PartSize > 0 {
+		return s.PartSize
+	}
+	return DefaultPartSize
+}
+
+// putMultipart uploads size bytes of r as name in parts of PartSize. The
+// upload is aborted when a part fails.
+func (s *S3) putMultipart(ctx context.Context, name string, r io.Reader, size int64) error {
+	resp, err := s.do(ctx, http.MethodPost, name, url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	_ = resp.Body.Close()
+	if err != nil || initiated.UploadID == "" {
+		return fmt.Errorf("could not start upload of %s: %v", name, err)
+	}
+
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for n := 1; size > 0; n++ {
+		length := min(size, s.partSize())
+		query := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {initiated.UploadID}}
+		resp, err := s.do(ctx, http.MethodPut, name, query, io.LimitReader(r, length), length)
+		if err != nil {
+			s.abort(ctx, name, initiated.UploadID)
+			return fmt.Errorf("part %d of %s: %w", n, name, err)
+		}
+		_ = resp.Body.Close()
+		complete.Parts = append(complete.Parts, part{PartNumber: n, ETag: resp.Header.Get("ETag")})
+		size -= length
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	resp, err = s.do(ctx, http.MethodPost, name, url.Values{"uploadId": {initiated.UploadID}}, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		s.abort(ctx, name, initiated.UploadID)
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	// Completing an upload can fail after the response status was sent
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := errorOf(data); err != nil {
+		s.abort(ctx, name, initiated.UploadID)
+		return fmt.Errorf("could not complete upload of %s: %w", name, err)
+	}
+	return nil
+}
+
+// abort aborts a multipart upload, so its parts do not take up space.
+func (s *S3) abort(ctx context.Context, name, uploadID string) {
+	if resp, err := s.do(ctx, http.MethodDelete, name, url.Values{"uploadId": {uploadID}}, nil, 0); err == nil {
+		_ = resp.Body.Close()
+	}
+}
+
+// objectURL returns the URL of the object of name, with query.
+func (s *S3) objectURL(name string, query url.Values) *url.URL {
+	key := s.Prefix + strings.TrimPrefix(name, "/")
+	u := *s.Endpoint
+	if s.PathStyle {
+		key = s.Bucket + "/" + key
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	base := strings.TrimSuffix(u.Path, "/")
+	u.Path = base + "/" + key
+	u.RawPath = uriEncode(base, false) + "/" + uriEncode(key, false)
+	// A lone "?uploads" is sent without a value
+	u.RawQuery = strings.TrimSuffix(query.Encode(), "=")
+	return &u
+}
+
+// do sends a signed request for the object of name with a body of size
+// bytes read from body. Errors of the object store are returned as an
+// error; a missing object matches fs.ErrNotExist.
+func (s *S3) do(ctx context.Context, method, name string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	if body == nil {
+		body = http.NoBody
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(name, query).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	payloadHash := emptyPayloadHash
+	if body != http.NoBody {
+		req.ContentLength = size
+		payloadHash = unsignedPayload
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	signer{creds: s.Credentials, region: s.Region, service: "s3"}.sign(req, payloadHash, now())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	// #nosec G704 - The URL is built from the configured object store endpoint
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &fs.PathError{Op: strings.ToLower(method), Path: s.String() + name, Err: fs.ErrNotExist}
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err := errorOf(data); err != nil {
+		return nil, fmt.Errorf("%s %s: %s: %w", method, name, resp.Status, err)
+	}
+	return nil, fmt.Errorf("%s %s: %s", method, name, resp.Status)
+}
+
+// s3Error is the error document of the object store.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// errorOf returns the error in the response body data, if it is an error
+// document.
+func errorOf(data []byte) error {
+	var doc struct {
+		XMLName xml.Name
+		s3Error
+	}
+	if xml.Unmarshal(data, &doc) != nil || doc.XMLName.Local != "Error" {
+		return nil
+	}
+	return &doc.s3Error
+}
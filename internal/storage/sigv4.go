@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Credentials are the access keys requests to an object store are signed
+// with.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // for temporary credentials; may be empty
+}
+
+// Formats of the dates in AWS Signature Version 4.
+const (
+	amzDateFormat = "20060102T150405Z"
+	scopeFormat   = "20060102"
+)
+
+// unsignedPayload is sent instead of the hash of request bodies, which are
+// streamed from disk.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// emptyPayloadHash is the SHA-256 hash of an empty body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// signer signs requests with AWS Signature Version 4.
+type signer struct {
+	creds   Credentials
+	region  string
+	service string
+}
+
+// sign adds the X-Amz-Date, X-Amz-Security-Token and Authorization headers
+// to req, made at t with a body of hash payloadHash. Every header already
+// set on req is signed, apart from User-Agent.
+func (s signer) sign(req *http.Request, payloadHash string, t time.Time) {
+	amzDate := t.UTC().Format(amzDateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.creds.SessionToken)
+	}
+	headers := []string{"host"}
+	for name := range req.Header {
+		name = strings.ToLower(name)
+		if name != "authorization" && name != "user-agent" {
+			headers = append(headers, name)
+		}
+	}
+	sort.Strings(headers)
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.creds.AccessKeyID+"/"+s.scope(amzDate)+
+		", SignedHeaders="+strings.Join(headers, ";")+
+		", Signature="+s.signature(req, payloadHash, amzDate, headers))
+}
+
+// scope returns the credential scope of a request made at amzDate.
+func (s signer) scope(amzDate string) string {
+	return amzDate[:len(scopeFormat)] + "/" + s.region + "/" + s.service + "/aws4_request"
+}
+
+// signature returns the signature of req, made at amzDate, over the sorted
+// lower-case header names headers.
+func (s signer) signature(req *http.Request, payloadHash, amzDate string, headers []string) string {
+	var canonical strings.Builder
+	canonical.WriteString(req.Method + "\n")
+	uri := req.URL.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	canonical.WriteString(uri + "\n")
+	canonical.WriteString(canonicalQuery(req.URL.RawQuery) + "\n")
+	for _, name := range headers {
+		canonical.WriteString(name + ":" + canonicalHeader(req, name) + "\n")
+	}
+	canonical.WriteString("\n" + strings.Join(headers, ";") + "\n" + payloadHash)
+
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + s.scope(amzDate) + "\n" + hashHex([]byte(canonical.String()))
+
+	key := hmacSHA256([]byte("AWS4"+s.creds.SecretAccessKey), amzDate[:len(scopeFormat)])
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalHeader returns the trimmed values of header name of req, joined
+// by commas.
+func canonicalHeader(req *http.Request, name string) string {
+	if name == "host" {
+		if req.Host != "" {
+			return req.Host
+		}
+		return req.URL.Host
+	}
+	values := req.Header.Values(name)
+	for i, v := range values {
+		values[i] = strings.Join(strings.Fields(v), " ")
+	}
+	return strings.Join(values, ",")
+}
+
+// canonicalQuery returns the query string rawQuery with its parameters
+// sorted and encoded as required by the signature.
+func canonicalQuery(rawQuery string) string {
+	values, _ := url.ParseQuery(rawQuery)
+	var params []string
+	for key, vals := range values {
+		for _, v := range vals {
+			params = append(params, uriEncode(key, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// uriEncode percent-encodes every byte of s except the unreserved
+// characters, and slashes unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,96 @@
+// Package storage provides the backends a media library is kept in: a local
+// directory or a bucket of an S3-compatible object store.
+//
+// Files are named by slash-separated paths relative to the root of the
+// library, such as "jwb-E/video.mp4". Downloads are written to the work
+// directory first and moved into the storage once they are complete, so
+// partial downloads, validators and caches always stay local.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedLocation is returned by New for storage locations of an
+// unknown scheme.
+var ErrUnsupportedLocation = errors.New("unsupported storage location")
+
+// FileInfo describes a stored file.
+type FileInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage stores the files of a media library. Methods report missing
+// files with an error matching fs.ErrNotExist.
+type Storage interface {
+	// Stat returns the size and modification time of name.
+	Stat(ctx context.Context, name string) (FileInfo, error)
+	// Open returns the contents of name.
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	// Put stores size bytes read from r as name, replacing any file of
+	// that name.
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+	// PutFile moves the local file at path into the storage as name.
+	PutFile(ctx context.Context, name, path string) error
+	// Remove removes name. Removing a missing file is not an error.
+	Remove(ctx context.Context, name string) error
+	// String describes the location of the storage for messages.
+	String() string
+}
+
+// New returns the storage at location: an s3:// URL (see NewS3), or a
+// local directory. An empty location is the work directory workDir.
+func New(location, workDir string) (Storage, error) {
+	switch {
+	case location == "":
+		return NewLocal(workDir), nil
+	case strings.HasPrefix(location, "s3://"):
+		return NewS3(location)
+	case strings.Contains(location, "://"):
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedLocation, location)
+	}
+	return NewLocal(location), nil
+}
+
+// Name returns the name of the file at path in a library rooted at the
+// local directory root, or an error when path is outside of it.
+func Name(root, path string) (string, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of %s", path, root)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Join joins the elements of a name, such as a subdirectory and a
+// filename.
+func Join(elem ...string) string {
+	for i := range elem {
+		elem[i] = filepath.ToSlash(elem[i])
+	}
+	return path.Join(elem...)
+}
+
+// Exists reports whether name is in st.
+func Exists(ctx context.Context, st Storage, name string) bool {
+	_, err := st.Stat(ctx, name)
+	return err == nil
+}
+
+// IsNotExist reports whether err reports a missing file.
+func IsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
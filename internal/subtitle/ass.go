@@ -0,0 +1,62 @@
+package subtitle
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// assHeader declares a single default style: white text with a black
+// outline at the bottom center, which matches how players render WebVTT.
+const assHeader = `[Script Info]
+ScriptType: v4.00+
+WrapStyle: 0
+ScaledBorderAndShadow: yes
+PlayResX: 384
+PlayResY: 288
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,Arial,16,&H00FFFFFF,&H000000FF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,1,0,2,10,10,10,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`
+
+var assStyle = markupStyle{
+	tags: map[string][2]string{
+		"b": {`{\b1}`, `{\b0}`},
+		"i": {`{\i1}`, `{\i0}`},
+		"u": {`{\u1}`, `{\u0}`},
+	},
+	newline: `\N`,
+	// Braces start override blocks and a backslash before n, N or h is a
+	// line break or hard space; neither can be escaped, so they are
+	// replaced by look-alikes.
+	escape: strings.NewReplacer("{", "(", "}", ")", `\`, "\u29f5").Replace,
+}
+
+// WriteASS writes t as Advanced SubStation Alpha (v4+) with a single
+// default style. Cues without any text are left out.
+func WriteASS(w io.Writer, t *Track) error {
+	bw := bufio.NewWriter(w)
+	_, _ = bw.WriteString(assHeader)
+	for _, c := range t.Cues {
+		text := render(c.Text, assStyle)
+		if text == "" {
+			continue
+		}
+		fmt.Fprintf(bw, "Dialogue: 0,%s,%s,Default,,0,0,0,,%s\n", formatASSTimestamp(c.Start), formatASSTimestamp(c.End), text)
+	}
+	return bw.Flush()
+}
+
+// formatASSTimestamp formats d as h:mm:ss.cc, the centisecond precision
+// used by ASS.
+func formatASSTimestamp(d time.Duration) string {
+	d = max(d, 0)
+	total := int(d / time.Second)
+	return fmt.Sprintf("%d:%02d:%02d.%02d", total/3600, total/60%60, total%60, (d%time.Second)/(10*time.Millisecond))
+}
@@ -0,0 +1,82 @@
+package subtitle
+
+import (
+	"html"
+	"strings"
+)
+
+// markupStyle describes how cue text markup is rendered in a target format.
+type markupStyle struct {
+	// tags maps the WebVTT tags b, i and u to their opening and closing
+	// markup. Tags without an entry (c, v, lang, ruby, timestamps) are
+	// dropped and only their text is kept.
+	tags map[string][2]string
+	// newline separates the lines of a cue.
+	newline string
+	// escape, if set, escapes plain text for the target format.
+	escape func(string) string
+}
+
+var plainStyle = markupStyle{newline: "\n"}
+
+// render converts WebVTT cue text to the given style. Character references
+// are decoded, supported tags are translated and tags left open at the end
+// of the cue are closed.
+func render(text string, style markupStyle) string {
+	var b strings.Builder
+	var open []string
+	writeText := func(s string) {
+		s = html.UnescapeString(s)
+		if style.escape != nil {
+			s = style.escape(s)
+		}
+		b.WriteString(strings.ReplaceAll(s, "\n", style.newline))
+	}
+
+	for text != "" {
+		i := strings.IndexByte(text, '<')
+		if i < 0 {
+			writeText(text)
+			break
+		}
+		writeText(text[:i])
+		j := strings.IndexByte(text[i:], '>')
+		if j < 0 {
+			// An unterminated tag runs to the end of the cue
+			break
+		}
+		tag := text[i+1 : i+j]
+		text = text[i+j+1:]
+
+		if name, ok := strings.CutPrefix(tag, "/"); ok {
+			name = tagName(name)
+			// Close the innermost matching tag
+			for k := len(open) - 1; k >= 0; k-- {
+				if open[k] == name {
+					b.WriteString(style.tags[name][1])
+					open = append(open[:k], open[k+1:]...)
+					break
+				}
+			}
+			continue
+		}
+		name := tagName(tag)
+		if markup, ok := style.tags[name]; ok {
+			b.WriteString(markup[0])
+			open = append(open, name)
+		}
+	}
+	for k := len(open) - 1; k >= 0; k-- {
+		b.WriteString(style.tags[open[k]][1])
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// tagName returns the name of a cue text tag without its classes and
+// annotation ("c.yellow" and "v Speaker" become "c" and "v").
+func tagName(tag string) string {
+	if i := strings.IndexAny(tag, ". \t"); i >= 0 {
+		tag = tag[:i]
+	}
+	return strings.ToLower(tag)
+}
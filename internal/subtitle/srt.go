@@ -0,0 +1,39 @@
+package subtitle
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var srtStyle = markupStyle{
+	tags: map[string][2]string{
+		"b": {"<b>", "</b>"},
+		"i": {"<i>", "</i>"},
+		"u": {"<u>", "</u>"},
+	},
+	newline: "\n",
+	// Players read any < as the start of a tag and SubRip has no escape
+	// for it, so a literal one is replaced by a look-alike.
+	escape: strings.NewReplacer("<", "\u2039").Replace,
+}
+
+// WriteSRT writes t as SubRip. Cues are renumbered from 1; cues without
+// any text are left out since SubRip cannot represent them.
+func WriteSRT(w io.Writer, t *Track) error {
+	bw := bufio.NewWriter(w)
+	n := 0
+	for _, c := range t.Cues {
+		text := render(c.Text, srtStyle)
+		if text == "" {
+			continue
+		}
+		n++
+		if n > 1 {
+			_, _ = bw.WriteString("\n")
+		}
+		fmt.Fprintf(bw, "%d\n%s --> %s\n%s\n", n, formatTimestamp(c.Start, ","), formatTimestamp(c.End, ","), text)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,94 @@
+// Package subtitle parses WebVTT subtitles and writes them as WebVTT, SRT or
+// ASS (Advanced SubStation Alpha).
+package subtitle
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Format is a subtitle file format, named after its file extension.
+type Format string
+
+// Supported subtitle formats.
+const (
+	VTT Format = "vtt"
+	SRT Format = "srt"
+	ASS Format = "ass"
+)
+
+// ErrUnknownFormat is returned for subtitle formats other than vtt, srt and
+// ass.
+var ErrUnknownFormat = errors.New("unknown subtitle format")
+
+// ParseFormat returns the format named by s (case-insensitive).
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case VTT, SRT, ASS:
+		return f, nil
+	default:
+		return "", fmt.Errorf("%w %q (expected vtt, srt or ass)", ErrUnknownFormat, s)
+	}
+}
+
+// Track is a parsed subtitle file.
+type Track struct {
+	Cues []Cue
+}
+
+// Cue is a single subtitle shown from Start to End.
+type Cue struct {
+	// ID is the optional cue identifier.
+	ID    string
+	Start time.Duration
+	End   time.Duration
+	// Settings holds the WebVTT cue settings (position, alignment, ...)
+	// verbatim.
+	Settings string
+	// Text is the cue payload with its WebVTT markup and character
+	// references; lines are separated by "\n".
+	Text string
+}
+
+// PlainText returns the cue text without markup, with character references
+// decoded.
+func (c Cue) PlainText() string {
+	return render(c.Text, plainStyle)
+}
+
+// Write writes t to w in format f.
+func Write(w io.Writer, t *Track, f Format) error {
+	switch f {
+	case VTT:
+		return WriteVTT(w, t)
+	case SRT:
+		return WriteSRT(w, t)
+	case ASS:
+		return WriteASS(w, t)
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownFormat, f)
+	}
+}
+
+// Convert parses the WebVTT subtitles read from r and writes them to w in
+// format f.
+func Convert(r io.Reader, w io.Writer, f Format) error {
+	t, err := Parse(r)
+	if err != nil {
+		return err
+	}
+	return Write(w, t, f)
+}
+
+// Filename returns the name of the subtitle file vttFilename converted to
+// format f: its ".vtt" extension is replaced (or the format's extension is
+// appended), so friendly, unique and language-suffixed names carry over.
+func Filename(vttFilename string, f Format) string {
+	if vttFilename == "" {
+		return ""
+	}
+	return strings.TrimSuffix(vttFilename, ".vtt") + "." + string(f)
+}
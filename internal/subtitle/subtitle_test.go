@@ -0,0 +1,143 @@
+package subtitle
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleVTT = "\ufeffWEBVTT - Sample\r\nKind: captions\r\n\r\n" +
+	"NOTE This is a comment\r\nspanning two lines\r\n\r\n" +
+	"STYLE\r\n::cue { color: yellow }\r\n\r\n" +
+	"intro\r\n00:01.000 --> 00:04.500 line:0 align:start\r\n<v Narrator>Welcome to <i>the</i> program</v>\r\nTom &amp; Jerry &lt;3\r\n\r\n" +
+	"01:00:02.250 --> 01:00:03.000\r\n<c.yellow>{Braces}</c> and <b>bold<00:00:02.500> text\r\n\r\n" +
+	"00:05.000 --> 00:xx.000\r\nbroken timing\r\n\r\n" +
+	"00:06.000 --> 00:07.000\r\n\r\n"
+
+func TestParse(t *testing.T) {
+	track, err := Parse(strings.NewReader(sampleVTT))
+	if err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+	want := []Cue{
+		{ID: "intro", Start: time.Second, End: 4500 * time.Millisecond, Settings: "line:0 align:start", Text: "<v Narrator>Welcome to <i>the</i> program</v>\nTom &amp; Jerry &lt;3"},
+		{Start: time.Hour + 2250*time.Millisecond, End: time.Hour + 3*time.Second, Text: "<c.yellow>{Braces}</c> and <b>bold<00:00:02.500> text"},
+		{Start: 6 * time.Second, End: 7 * time.Second},
+	}
+	if !reflect.DeepEqual(track.Cues, want) {
+		t.Errorf("got cues %+v, want %+v", track.Cues, want)
+	}
+	if got := track.Cues[0].PlainText(); got != "Welcome to the program\nTom & Jerry <3" {
+		t.Errorf("PlainText() = %q", got)
+	}
+}
+
+func TestParseRejectsOtherFormats(t *testing.T) {
+	for _, input := range []string{"", "1\n00:00:01,000 --> 00:00:02,000\nSRT\n", "WEBVTTX\n"} {
+		if _, err := Parse(strings.NewReader(input)); !errors.Is(err, ErrInvalidVTT) {
+			t.Errorf("Parse(%q) error = %v, want ErrInvalidVTT", input, err)
+		}
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	testCases := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"00:01.000", time.Second, true},
+		{"1:02:03.004", time.Hour + 2*time.Minute + 3*time.Second + 4*time.Millisecond, true},
+		{"100:00:00.000", 100 * time.Hour, true},
+		{"00:00:01,500", 1500 * time.Millisecond, true},
+		{"00:60.000", 0, false},
+		{"00:01.5", 0, false},
+		{"01.000", 0, false},
+		{"-1:00.000", 0, false},
+	}
+	for _, tc := range testCases {
+		got, err := parseTimestamp(tc.in)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("parseTimestamp(%q) = %v, %v", tc.in, got, err)
+		}
+	}
+}
+
+func TestWriteFormats(t *testing.T) {
+	track, err := Parse(strings.NewReader(sampleVTT))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		format Format
+		want   string
+	}{
+		{SRT, "1\n00:00:01,000 --> 00:00:04,500\nWelcome to <i>the</i> program\nTom & Jerry \u20393\n\n" +
+			"2\n01:00:02,250 --> 01:00:03,000\n{Braces} and <b>bold text</b>\n"},
+		{ASS, assHeader +
+			"Dialogue: 0,0:00:01.00,0:00:04.50,Default,,0,0,0,,Welcome to {\\i1}the{\\i0} program\\NTom & Jerry <3\n" +
+			"Dialogue: 0,1:00:02.25,1:00:03.00,Default,,0,0,0,,(Braces) and {\\b1}bold text{\\b0}\n"},
+		{VTT, "WEBVTT\n\nintro\n00:00:01.000 --> 00:00:04.500 line:0 align:start\n<v Narrator>Welcome to <i>the</i> program</v>\nTom &amp; Jerry &lt;3\n\n" +
+			"01:00:02.250 --> 01:00:03.000\n<c.yellow>{Braces}</c> and <b>bold<00:00:02.500> text\n\n" +
+			"00:00:06.000 --> 00:00:07.000\n\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(string(tc.format), func(t *testing.T) {
+			var b strings.Builder
+			if err := Write(&b, track, tc.format); err != nil {
+				t.Fatalf("Write() returned error: %v", err)
+			}
+			if b.String() != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", b.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteSRTKeepsEscapedTagsAsText(t *testing.T) {
+	track := &Track{Cues: []Cue{{Start: time.Second, End: 2 * time.Second, Text: "&lt;i&gt;not italic&lt;/i&gt; but <i>this</i>"}}}
+	var b strings.Builder
+	if err := WriteSRT(&b, track); err != nil {
+		t.Fatal(err)
+	}
+	if want := "1\n00:00:01,000 --> 00:00:02,000\n\u2039i>not italic\u2039/i> but <i>this</i>\n"; b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	var vtt strings.Builder
+	if err := Convert(strings.NewReader(sampleVTT), &vtt, VTT); err != nil {
+		t.Fatal(err)
+	}
+	original, _ := Parse(strings.NewReader(sampleVTT))
+	reparsed, err := Parse(strings.NewReader(vtt.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(original, reparsed) {
+		t.Errorf("round trip changed the cues:\n%+v\n%+v", original, reparsed)
+	}
+}
+
+func TestParseFormatAndFilename(t *testing.T) {
+	if f, err := ParseFormat("SRT"); err != nil || f != SRT {
+		t.Errorf("ParseFormat(SRT) = %q, %v", f, err)
+	}
+	if _, err := ParseFormat("sub"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("ParseFormat(sub) error = %v, want ErrUnknownFormat", err)
+	}
+	for in, want := range map[string]string{
+		"Intro (1).vtt": "Intro (1).ass",
+		"intro.S.vtt":   "intro.S.ass",
+		"intro":         "intro.ass",
+		"":              "",
+	} {
+		if got := Filename(in, ASS); got != want {
+			t.Errorf("Filename(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
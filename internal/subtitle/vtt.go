@@ -0,0 +1,188 @@
+package subtitle
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidVTT is returned for input that does not start with a WebVTT
+// signature.
+var ErrInvalidVTT = errors.New("not a WebVTT file")
+
+// maxVTTSize limits how much subtitle data Parse reads.
+const maxVTTSize = 16 * 1024 * 1024
+
+// Parse reads a WebVTT file. It follows the WebVTT parsing rules: the
+// header, NOTE, STYLE and REGION blocks are skipped, cue identifiers and
+// settings are kept, and cues with malformed timings are dropped instead of
+// failing the whole file.
+func Parse(r io.Reader) (*Track, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxVTTSize))
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	sc := bufio.NewScanner(strings.NewReader(text))
+	sc.Buffer(make([]byte, 0, 64*1024), maxVTTSize)
+	if !sc.Scan() || !isSignature(sc.Text()) {
+		return nil, ErrInvalidVTT
+	}
+
+	t := &Track{}
+	var block []string
+	flush := func() {
+		if cue, ok := parseBlock(block); ok {
+			t.Cues = append(t.Cues, cue)
+		}
+		block = block[:0]
+	}
+	// The header runs until the first blank line.
+	inHeader := true
+	for sc.Scan() {
+		line := sc.Text()
+		if inHeader {
+			if line == "" {
+				inHeader = false
+			} else if strings.Contains(line, "-->") {
+				// Not allowed by the spec, but some files omit the blank
+				// line after the signature.
+				inHeader = false
+				block = append(block, line)
+			}
+			continue
+		}
+		if line == "" {
+			flush()
+			continue
+		}
+		block = append(block, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return t, nil
+}
+
+// isSignature reports whether line is a valid WebVTT file signature.
+func isSignature(line string) bool {
+	rest, ok := strings.CutPrefix(line, "WEBVTT")
+	return ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t')
+}
+
+// parseBlock parses a block of lines as a cue. Other blocks (comments,
+// style sheets, regions) and malformed cues are reported as not ok.
+func parseBlock(lines []string) (Cue, bool) {
+	if len(lines) == 0 {
+		return Cue{}, false
+	}
+	var cue Cue
+	if !strings.Contains(lines[0], "-->") {
+		if len(lines) < 2 || !strings.Contains(lines[1], "-->") {
+			// NOTE, STYLE and REGION blocks, or garbage
+			return Cue{}, false
+		}
+		cue.ID = lines[0]
+		lines = lines[1:]
+	}
+
+	var err error
+	cue.Start, cue.End, cue.Settings, err = parseTiming(lines[0])
+	if err != nil {
+		return Cue{}, false
+	}
+	cue.Text = strings.Join(lines[1:], "\n")
+	return cue, true
+}
+
+// parseTiming parses a cue timings line such as
+// "00:01.000 --> 00:04.000 line:0 align:start".
+func parseTiming(line string) (start, end time.Duration, settings string, err error) {
+	left, right, ok := strings.Cut(line, "-->")
+	if !ok {
+		return 0, 0, "", fmt.Errorf("missing --> in %q", line)
+	}
+	if start, err = parseTimestamp(strings.TrimSpace(left)); err != nil {
+		return 0, 0, "", err
+	}
+	fields := strings.Fields(right)
+	if len(fields) == 0 {
+		return 0, 0, "", fmt.Errorf("missing end time in %q", line)
+	}
+	if end, err = parseTimestamp(fields[0]); err != nil {
+		return 0, 0, "", err
+	}
+	if end < start {
+		return 0, 0, "", fmt.Errorf("cue ends before it starts in %q", line)
+	}
+	return start, end, strings.Join(fields[1:], " "), nil
+}
+
+// parseTimestamp parses a WebVTT timestamp ("mm:ss.ttt" or "hh:mm:ss.ttt").
+// A comma is accepted as the decimal separator since SRT-style timestamps
+// are a common mistake in the wild.
+func parseTimestamp(s string) (time.Duration, error) {
+	s = strings.Replace(s, ",", ".", 1)
+	clock, frac, ok := strings.Cut(s, ".")
+	if !ok || len(frac) != 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	parts := strings.Split(clock, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+
+	var values []int
+	for _, p := range append(parts, frac) {
+		if p == "" || strings.TrimLeft(p, "0123456789") != "" {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp %q: %w", s, err)
+		}
+		values = append(values, v)
+	}
+	if len(values) == 3 {
+		values = append([]int{0}, values...)
+	}
+	hours, minutes, seconds, millis := values[0], values[1], values[2], values[3]
+	if minutes > 59 || seconds > 59 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds)*time.Second + time.Duration(millis)*time.Millisecond, nil
+}
+
+// WriteVTT writes t as WebVTT.
+func WriteVTT(w io.Writer, t *Track) error {
+	bw := bufio.NewWriter(w)
+	_, _ = bw.WriteString("WEBVTT\n")
+	for _, c := range t.Cues {
+		_, _ = bw.WriteString("\n")
+		if c.ID != "" {
+			fmt.Fprintf(bw, "%s\n", c.ID)
+		}
+		fmt.Fprintf(bw, "%s --> %s", formatTimestamp(c.Start, "."), formatTimestamp(c.End, "."))
+		if c.Settings != "" {
+			fmt.Fprintf(bw, " %s", c.Settings)
+		}
+		fmt.Fprintf(bw, "\n%s\n", c.Text)
+	}
+	return bw.Flush()
+}
+
+// formatTimestamp formats d as hh:mm:ss followed by sep and milliseconds.
+func formatTimestamp(d time.Duration, sep string) string {
+	d = max(d, 0)
+	total := int(d / time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", total/3600, total/60%60, total%60, sep, (d%time.Second)/time.Millisecond)
+}
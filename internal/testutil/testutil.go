@@ -0,0 +1,16 @@
+// Package testutil holds fixtures shared by the tests of several packages.
+package testutil
+
+import "encoding/binary"
+
+// MP4 returns the smallest MP4 file that passes metadata.CheckContainer,
+// with payload as its media data, to stand in for downloaded videos.
+func MP4(payload string) []byte {
+	box := func(boxType string, data []byte) []byte {
+		b := binary.BigEndian.AppendUint32(nil, uint32(8+len(data))) // #nosec G115 - test data
+		return append(append(b, boxType...), data...)
+	}
+	b := box("ftyp", []byte("isom"))
+	b = append(b, box("moov", nil)...)
+	return append(b, box("mdat", []byte(payload))...)
+}
@@ -0,0 +1,163 @@
+// Package transcript turns subtitle tracks into readable plain-text and
+// Markdown transcripts.
+package transcript
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/darkace1998/jw-scripts/internal/subtitle"
+)
+
+// Format is a transcript file format, named after its file extension.
+type Format string
+
+// Supported transcript formats.
+const (
+	Text     Format = "txt"
+	Markdown Format = "md"
+)
+
+// ErrUnknownFormat is returned for transcript formats other than txt and md.
+var ErrUnknownFormat = errors.New("unknown transcript format")
+
+// ParseFormat returns the format named by s (case-insensitive).
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case Text, Markdown:
+		return f, nil
+	default:
+		return "", fmt.Errorf("%w %q (expected txt or md)", ErrUnknownFormat, s)
+	}
+}
+
+// paragraphGap is the pause between cues that starts a new paragraph when
+// no timestamps are requested.
+const paragraphGap = 3 * time.Second
+
+// Paragraph is a run of cue text starting at Start.
+type Paragraph struct {
+	Start time.Duration
+	Text  string
+}
+
+// Document is the transcript of a single media item.
+type Document struct {
+	Title string
+	// Date is the publication date; zero when unknown.
+	Date time.Time
+	// Timestamps reports whether paragraphs start with their time.
+	Timestamps bool
+	Paragraphs []Paragraph
+}
+
+// Paragraphs merges the cues of t into paragraphs. Markup and cue timing
+// are dropped, lines broken across cues are joined and cues repeating the
+// previous one are skipped. With every > 0 a new paragraph starts with the
+// first cue of every interval of that length, so paragraphs can be
+// timestamped; otherwise paragraphs break at pauses in the speech.
+func Paragraphs(t *subtitle.Track, every time.Duration) []Paragraph {
+	var paragraphs []Paragraph
+	var current []string
+	var start, lastEnd time.Duration
+	previous := ""
+	flush := func() {
+		if len(current) > 0 {
+			paragraphs = append(paragraphs, Paragraph{Start: start, Text: strings.Join(current, " ")})
+			current = nil
+		}
+	}
+
+	for _, c := range t.Cues {
+		text := strings.Join(strings.Fields(c.PlainText()), " ")
+		if text == "" || text == previous {
+			continue
+		}
+		previous = text
+
+		var newParagraph bool
+		if every > 0 {
+			newParagraph = c.Start/every != start/every
+		} else {
+			newParagraph = c.Start-lastEnd >= paragraphGap
+		}
+		if len(current) == 0 || newParagraph {
+			flush()
+			start = c.Start
+		}
+		current = append(current, text)
+		lastEnd = c.End
+	}
+	flush()
+	return paragraphs
+}
+
+// Write writes doc to w in format f.
+func Write(w io.Writer, doc *Document, f Format) error {
+	var b strings.Builder
+	writeDocument(&b, doc, f, 1)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteCombined writes the documents to w as sections of a single document
+// titled title.
+func WriteCombined(w io.Writer, title string, docs []*Document, f Format) error {
+	var b strings.Builder
+	writeHeading(&b, title, f, 1)
+	for _, doc := range docs {
+		b.WriteString("\n")
+		writeDocument(&b, doc, f, 2)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeDocument(b *strings.Builder, doc *Document, f Format, level int) {
+	writeHeading(b, doc.Title, f, level)
+	if !doc.Date.IsZero() {
+		date := "Published " + doc.Date.Format("2006-01-02")
+		if f == Markdown {
+			date = "_" + date + "_"
+		}
+		fmt.Fprintf(b, "\n%s\n", date)
+	}
+	for _, p := range doc.Paragraphs {
+		b.WriteString("\n")
+		if doc.Timestamps {
+			stamp := "[" + formatTimestamp(p.Start) + "]"
+			if f == Markdown {
+				stamp = "**" + stamp + "**"
+			}
+			b.WriteString(stamp + " ")
+		}
+		b.WriteString(p.Text + "\n")
+	}
+}
+
+// writeHeading writes a level 1 or 2 heading: "#"/"##" in Markdown and an
+// underline of "="/"-" in plain text.
+func writeHeading(b *strings.Builder, title string, f Format, level int) {
+	if f == Markdown {
+		fmt.Fprintf(b, "%s %s\n", strings.Repeat("#", level), title)
+		return
+	}
+	underline := "="
+	if level > 1 {
+		underline = "-"
+	}
+	fmt.Fprintf(b, "%s\n%s\n", title, strings.Repeat(underline, max(len([]rune(title)), 3)))
+}
+
+// formatTimestamp formats d as h:mm:ss or m:ss.
+func formatTimestamp(d time.Duration) string {
+	total := int(d / time.Second)
+	h, m, s := total/3600, total/60%60, total%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
@@ -0,0 +1,86 @@
+package transcript
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/darkace1998/jw-scripts/internal/subtitle"
+)
+
+func sampleTrack() *subtitle.Track {
+	cue := func(start, end float64, text string) subtitle.Cue {
+		return subtitle.Cue{Start: time.Duration(start * float64(time.Second)), End: time.Duration(end * float64(time.Second)), Text: text}
+	}
+	return &subtitle.Track{Cues: []subtitle.Cue{
+		cue(1, 3, "<v Narrator>In the beginning</v>\nGod created"),
+		cue(3, 5, "the heavens and the earth."),
+		cue(5, 6, "the heavens and the earth."),
+		cue(20, 22, "<i>Now the earth</i> was\nformless &amp; waste."),
+		cue(65, 67, "And God said:"),
+	}}
+}
+
+func TestParagraphs(t *testing.T) {
+	got := Paragraphs(sampleTrack(), 0)
+	want := []Paragraph{
+		{Start: time.Second, Text: "In the beginning God created the heavens and the earth."},
+		{Start: 20 * time.Second, Text: "Now the earth was formless & waste."},
+		{Start: 65 * time.Second, Text: "And God said:"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Paragraphs(0) = %+v, want %+v", got, want)
+	}
+
+	got = Paragraphs(sampleTrack(), time.Minute)
+	want = []Paragraph{
+		{Start: time.Second, Text: "In the beginning God created the heavens and the earth. Now the earth was formless & waste."},
+		{Start: 65 * time.Second, Text: "And God said:"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Paragraphs(1m) = %+v, want %+v", got, want)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	doc := &Document{
+		Title:      "Creation",
+		Date:       time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		Timestamps: true,
+		Paragraphs: Paragraphs(sampleTrack(), time.Minute),
+	}
+
+	var b strings.Builder
+	if err := Write(&b, doc, Text); err != nil {
+		t.Fatal(err)
+	}
+	want := "Creation\n========\n\nPublished 2024-01-02\n\n" +
+		"[0:01] In the beginning God created the heavens and the earth. Now the earth was formless & waste.\n\n" +
+		"[1:05] And God said:\n"
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	doc.Timestamps = false
+	if err := WriteCombined(&b, "Bible Stories", []*Document{doc, {Title: "Empty"}}, Markdown); err != nil {
+		t.Fatal(err)
+	}
+	want = "# Bible Stories\n\n## Creation\n\n_Published 2024-01-02_\n\n" +
+		"In the beginning God created the heavens and the earth. Now the earth was formless & waste.\n\n" +
+		"And God said:\n\n## Empty\n"
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := ParseFormat("MD"); err != nil || f != Markdown {
+		t.Errorf("ParseFormat(MD) = %q, %v", f, err)
+	}
+	if _, err := ParseFormat("pdf"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("ParseFormat(pdf) error = %v, want ErrUnknownFormat", err)
+	}
+}